@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"log/slog"
+	// Time zones in pricing rules must resolve in minimal container images too
+	_ "time/tzdata"
 
 	"github.com/spa5k/zeller_go/internal"
 	"github.com/spa5k/zeller_go/internal/catalog"
@@ -11,16 +15,23 @@ import (
 )
 
 func main() {
+	rulesPath := flag.String("rules", "configs/pricingrules.yaml", "pricing rule file (JSON or YAML)")
+	catalogPath := flag.String("catalog", "", "catalog log file; the catalog is kept in memory if empty")
+	flag.Parse()
+
 	logger := internal.NewLogger()
-	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: 499.99},
+	catalog, err := openCatalog(*catalogPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pricingRules, err := pricingrules.NewRegistry().LoadFile(*rulesPath, catalog)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Scenario 1: SKUs Scanned: atv, atv, atv  -> 3 for 2 rule applies -> 2 * $109.50 = $219.00
 	co1 := checkout.NewCheckout(pricingRules, catalog)
-	err := co1.Scan(checkout.Item{SKU: "atv"})
+	err = co1.Scan(checkout.Item{SKU: "atv"})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -89,3 +100,20 @@ func main() {
 	}
 	logger.Info("Scenario 5 Total price", "total", total5)
 }
+
+// openCatalog opens the catalog log at path, starting a new log off with the
+// seed products. With no path the catalog is kept in memory.
+func openCatalog(path string) (*catalog.Catalog, error) {
+	if path == "" {
+		return catalog.NewCatalog(), nil
+	}
+	ctx := context.Background()
+	store, err := catalog.OpenFileStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := catalog.Seed(ctx, store, catalog.SeedProducts()); err != nil {
+		return nil, err
+	}
+	return catalog.OpenCatalog(ctx, store)
+}
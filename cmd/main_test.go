@@ -1,20 +1,27 @@
 package main_test
 
 import (
+	"errors"
 	"testing"
 
+	"github.com/spa5k/zeller_go/internal"
 	"github.com/spa5k/zeller_go/internal/catalog"
 	"github.com/spa5k/zeller_go/internal/checkout"
+	"github.com/spa5k/zeller_go/internal/money"
 	"github.com/spa5k/zeller_go/internal/pricingrules"
 	"github.com/stretchr/testify/assert"
 )
 
+func aud(amount string) money.Money {
+	return money.MustParse(amount, money.AUD)
+}
+
 func TestScenario1(t *testing.T) {
 	// Scenario 1: SKUs Scanned: atv, atv, atv  -> 3 for 2 rule applies -> 2 * $109.50 = $219.00
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: 499.99},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -27,17 +34,17 @@ func TestScenario1(t *testing.T) {
 
 	total, err := co.Total()
 	assert.NoError(t, err)
-	expectedTotal := 219.00
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("219.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestScenario2(t *testing.T) {
 	// Scenario 2: SKUs Scanned: ipd, ipd, ipd, ipd
 	// Buy 4 iPads so that the bulk discount rule applies: 4 * $499.99 = $1999.96
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: 499.99},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -48,30 +55,32 @@ func TestScenario2(t *testing.T) {
 
 	total, err := co.Total()
 	assert.NoError(t, err)
-	expectedTotal := 1999.96
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("1999.96")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestInvalidSKU(t *testing.T) {
 	// Edge Case Scenario: Invalid SKU
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: 499.99},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
 	err := co.Scan(checkout.Item{SKU: "unknown"})
 	assert.Error(t, err)
-	assert.EqualError(t, err, "product not found: unknown")
+	assert.EqualError(t, err, "unknown code unknown: no product has it as SKU, barcode or alias")
+	var notFound internal.ErrProductNotFound
+	assert.True(t, errors.As(err, &notFound))
 }
 
 func TestScenario3(t *testing.T) {
 	// Example scenario - SKUs Scanned: atv, atv, atv, vga Total expected: $249.00
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: 499.99},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -82,16 +91,16 @@ func TestScenario3(t *testing.T) {
 
 	total, err := co.Total()
 	assert.NoError(t, err)
-	expectedTotal := 249.00
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("249.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestScenario4(t *testing.T) {
 	// Example scenario - SKUs Scanned: atv, ipd, ipd, atv, ipd, ipd, ipd Total expected: $2718.95
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: 499.99},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -102,14 +111,14 @@ func TestScenario4(t *testing.T) {
 
 	total, err := co.Total()
 	assert.NoError(t, err)
-	expectedTotal := 2718.95
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("2718.95")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestEdgeCase_EmptySKU(t *testing.T) {
 	// Scanning an item with an empty SKU
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{}
+	pricingRules := []pricingrules.PricingRule{}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
 	err := co.Scan(checkout.Item{SKU: ""})
@@ -120,8 +129,8 @@ func TestEdgeCase_EmptySKU(t *testing.T) {
 func TestEdgeCase_ZeroQuantityPricingRule(t *testing.T) {
 	// Pricing rule with zero minimum quantity
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 0, NewPrice: 400.00},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 0, NewPrice: aud("400.00")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -131,15 +140,15 @@ func TestEdgeCase_ZeroQuantityPricingRule(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Since MinQuantity is 0, the discount should always apply
-	expectedTotal := 400.00
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("400.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestEdgeCase_HighQuantity(t *testing.T) {
 	// Scanning a very large number of items
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: 499.99},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -151,14 +160,14 @@ func TestEdgeCase_HighQuantity(t *testing.T) {
 	total, err := co.Total()
 	assert.NoError(t, err)
 
-	expectedTotal := float64(highQuantity) * 499.99
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("499.99").MulInt(int64(highQuantity))
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestEdgeCase_ScanAfterTotal(t *testing.T) {
 	// Scanning items after calling Total()
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{}
+	pricingRules := []pricingrules.PricingRule{}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
 	err := co.Scan(checkout.Item{SKU: "mbp"})
@@ -172,18 +181,18 @@ func TestEdgeCase_ScanAfterTotal(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Ensure that the new item is included in the total
-	expectedTotal1 := 1399.99
-	expectedTotal2 := 1399.99 + 30.00
+	expectedTotal1 := aud("1399.99")
+	expectedTotal2 := aud("1399.99").Add(aud("30.00"))
 
-	assert.Equal(t, expectedTotal1, total1)
-	assert.Equal(t, expectedTotal2, total2)
+	assert.Equal(t, expectedTotal1.String(), total1.String())
+	assert.Equal(t, expectedTotal2.String(), total2.String())
 }
 
 func TestEdgeCase_DiscountedPriceHigherThanOriginal(t *testing.T) {
 	// Pricing rule that sets a new price higher than the original price
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"ipd": &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: 600.00},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("600.00")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -196,15 +205,16 @@ func TestEdgeCase_DiscountedPriceHigherThanOriginal(t *testing.T) {
 
 	// Since the discounted price is higher, the system should decide whether to apply it or not
 	// For this test, we'll assume the system applies the higher price
-	expectedTotal := 5 * 600.00
-	assert.Equal(t, expectedTotal, total)
+	// 5 * $600.00
+	expectedTotal := aud("3000.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestEdgeCase_PricingRuleWithNegativePrice(t *testing.T) {
 	// Pricing rule that sets a negative price
 	catalog := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"vga": &pricingrules.BulkDiscountRule{SKU: "vga", MinQuantity: 1, NewPrice: -10.00},
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "vga", MinQuantity: 1, NewPrice: aud("-10.00")},
 	}
 
 	co := checkout.NewCheckout(pricingRules, catalog)
@@ -213,6 +223,24 @@ func TestEdgeCase_PricingRuleWithNegativePrice(t *testing.T) {
 	total, err := co.Total()
 	assert.NoError(t, err)
 
-	expectedTotal := -10.00
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("-10.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
+}
+
+func TestConfiguredRules(t *testing.T) {
+	// The rules shipped in configs/ reproduce the scenarios above
+	catalog := catalog.NewCatalog()
+	pricingRules, err := pricingrules.NewRegistry().LoadFile("../configs/pricingrules.yaml", catalog)
+	assert.NoError(t, err)
+
+	co := checkout.NewCheckout(pricingRules, catalog)
+	for _, sku := range []string{"atv", "ipd", "ipd", "atv", "ipd", "ipd", "ipd"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	expectedTotal := aud("2718.95")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
@@ -2,20 +2,164 @@ package catalog
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
 
 	"github.com/shopspring/decimal"
 	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/money"
 )
 
+// Unit is the unit of measure a product is sold in
+type Unit string
+
+const (
+	// Each is for products sold as whole items
+	Each Unit = ""
+	// Kilogram is for products sold by weight
+	Kilogram Unit = "kg"
+	// Metre is for products sold by length, such as cable off a reel
+	Metre Unit = "m"
+)
+
+// ParseUnit returns the unit named s, where the empty string means Each
+func ParseUnit(s string) (Unit, error) {
+	switch unit := Unit(s); unit {
+	case Each, Kilogram, Metre:
+		return unit, nil
+	}
+	return "", fmt.Errorf("unknown unit %q", s)
+}
+
+// Measured reports whether products in this unit are sold by measure rather
+// than as whole items
+func (u Unit) Measured() bool {
+	return u != Each
+}
+
+// Status is where a product is in its lifecycle
+type Status string
+
+const (
+	// Draft products are being set up and cannot be sold yet
+	Draft Status = "draft"
+	// Active products are on sale
+	Active Status = "active"
+	// Discontinued products are no longer sold but stay in the catalog, so
+	// they can be brought back
+	Discontinued Status = "discontinued"
+)
+
+// transitions lists the statuses each status can move to
+var transitions = map[Status][]Status{
+	Draft:        {Active, Discontinued},
+	Active:       {Discontinued},
+	Discontinued: {Active},
+}
+
+// ParseStatus returns the status named s
+func ParseStatus(s string) (Status, error) {
+	switch status := Status(s); status {
+	case Draft, Active, Discontinued:
+		return status, nil
+	}
+	return "", fmt.Errorf("unknown product status %q", s)
+}
+
+// Sellable reports whether products in this status can be scanned
+func (s Status) Sellable() bool {
+	return s == Active
+}
+
+// CanMoveTo reports whether a product in this status may move to next
+func (s Status) CanMoveTo(next Status) bool {
+	return s == next || slices.Contains(transitions[s], next)
+}
+
 type Product struct {
 	SKU   string
 	Name  string
 	Price decimal.Decimal
+	// Unit is what Price is charged per. Products sold by measure are scanned
+	// with the measured amount, such as 1.25 kg.
+	Unit Unit
+	// Status is where the product is in its lifecycle. A product added
+	// without one is active.
+	Status Status
+	// Parent is the SKU of the product this is a variant of, such as "ipd"
+	// for a 256GB blue iPad. A variant has its own SKU, price and status and
+	// is scanned by its own SKU; a parent with variants cannot be scanned.
+	Parent string
+	// Barcodes are the EAN-8, UPC-A, EAN-13 or GTIN-14 codes printed on the
+	// product, each with a correct check digit
+	Barcodes []string
+	// Aliases are other codes the product is known by, such as a legacy SKU
+	// or a supplier's code. Barcodes, aliases and SKUs are unique across the
+	// catalog, so each one stands for a single product, see Resolve.
+	Aliases []string
+	// Attributes describe what sets a variant apart, such as
+	// {"storage": "256GB", "colour": "blue"}
+	Attributes map[string]string
+	// Category places the product in the category tree as a path from the
+	// top, such as "accessories/cables"
+	Category string
+	// Brand is who makes the product, such as "Apple"
+	Brand string
+	// Tags are free-form labels, such as "clearance" or "gift-idea"
+	Tags []string
+	// Prices holds the product's past and scheduled prices. At any moment the
+	// period that applies then takes the place of Price, see PriceAt.
+	Prices []PricePeriod
 }
 
+// clone returns a copy of the product that shares no maps or slices with it
+func (p Product) clone() Product {
+	p.Attributes = maps.Clone(p.Attributes)
+	p.Barcodes = slices.Clone(p.Barcodes)
+	p.Aliases = slices.Clone(p.Aliases)
+	p.Tags = slices.Clone(p.Tags)
+	p.Prices = slices.Clone(p.Prices)
+	return p
+}
+
+// Catalog is the set of products on sale, one per SKU. It is safe for
+// concurrent use: readers work from an immutable snapshot and never wait,
+// while writers take turns to build the next snapshot and publish it in one
+// step. Every change is written through to the catalog's store before it is
+// published.
 type Catalog struct {
-	products map[string][]Product
+	// mu serialises writers so no update is lost between copying the
+	// current snapshot and publishing the next one
+	mu       sync.Mutex
+	snapshot atomic.Pointer[Snapshot]
+	store    Store
+	// validators run on every product added or updated
+	validators []Validator
+	// clock dates the price changes recorded in product price histories
+	clock internal.Clock
+}
+
+// Option configures optional catalog behaviour
+type Option func(*Catalog)
+
+// WithValidators replaces the validators the catalog runs on every product
+// added or updated. The default is DefaultValidators.
+func WithValidators(validators ...Validator) Option {
+	return func(c *Catalog) {
+		c.validators = append([]Validator(nil), validators...)
+	}
+}
+
+// WithClock sets the clock the catalog reads when it records a price change
+// in a product's price history. The default is the system clock.
+func WithClock(clock internal.Clock) Option {
+	return func(c *Catalog) {
+		c.clock = clock
+	}
 }
 
 var logger *slog.Logger
@@ -24,63 +168,286 @@ func init() {
 	logger = internal.NewLogger()
 }
 
-func NewCatalog() *Catalog {
-	return &Catalog{
-		products: map[string][]Product{
-			"ipd": {
-				{SKU: "ipd", Name: "Super iPad", Price: decimal.NewFromFloat(549.99)},
-			},
-			"mbp": {
-				{SKU: "mbp", Name: "MacBook Pro", Price: decimal.NewFromFloat(1399.99)},
-			},
-			"atv": {
-				{SKU: "atv", Name: "Apple TV", Price: decimal.NewFromFloat(109.50)},
-			},
-			"vga": {
-				{SKU: "vga", Name: "VGA adapter", Price: decimal.NewFromFloat(30.00)},
-			},
-		},
+// NewCatalog returns a catalog of the seed products, kept in memory only
+func NewCatalog(opts ...Option) *Catalog {
+	return newCatalog(NewMemoryStore(SeedProducts()...), SeedProducts(), opts)
+}
+
+// OpenCatalog returns a catalog of the products in store, which it keeps up
+// to date with every change. Use Seed first to start a new store off with the
+// seed products.
+func OpenCatalog(ctx context.Context, store Store, opts ...Option) (*Catalog, error) {
+	products, err := store.Load(ctx)
+	if err != nil {
+		return nil, err
 	}
+	return newCatalog(store, products, opts), nil
 }
 
-func (c *Catalog) GetProducts(ctx context.Context, sku string) ([]Product, error) {
+// newCatalog builds a catalog of products already in store. They are not
+// validated again, so tightening the validators never locks a catalog out of
+// its own store.
+func newCatalog(store Store, products []Product, opts []Option) *Catalog {
+	snapshot := &Snapshot{products: make(map[string]Product, len(products)), variants: make(map[string][]string), codes: make(map[string]string), currency: money.AUD}
+	for _, product := range products {
+		snapshot.products[product.SKU] = withDefaults(product).clone()
+		snapshot.index(product)
+		if product.Parent != "" {
+			snapshot.variants[product.Parent] = append(snapshot.variants[product.Parent], product.SKU)
+		}
+	}
+	for _, variants := range snapshot.variants {
+		slices.Sort(variants)
+	}
+	c := &Catalog{store: store, validators: DefaultValidators(), clock: internal.SystemClock{}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.snapshot.Store(snapshot)
+	return c
+}
+
+// withDefaults fills in the fields a product may be added without
+func withDefaults(product Product) Product {
+	if product.Status == "" {
+		product.Status = Active
+	}
+	return product
+}
+
+// Snapshot returns the catalog as it is now. Later changes to the catalog do
+// not show in the snapshot.
+func (c *Catalog) Snapshot() *Snapshot {
+	return c.snapshot.Load()
+}
+
+// Currency returns the currency all catalog prices are expressed in
+func (c *Catalog) Currency() money.Currency {
+	return c.Snapshot().Currency()
+}
+
+// ProductsBySKU returns a copy of every product in the catalog, keyed by SKU.
+// Changing the copy does not change the catalog.
+func (c *Catalog) ProductsBySKU() map[string]Product {
+	return c.Snapshot().ProductsBySKU()
+}
+
+// Products returns a copy of every product in the catalog, keyed by SKU, each
+// as a one-element list.
+//
+// Deprecated: a SKU lists exactly one product; use ProductsBySKU.
+func (c *Catalog) Products() map[string][]Product {
+	return c.Snapshot().Products()
+}
+
+// AddProduct adds a product under a SKU that is not yet in use. The product
+// must pass the catalog's validators; every problem found is reported at once
+// in an ErrValidation.
+func (c *Catalog) AddProduct(ctx context.Context, product Product) error {
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return ctx.Err()
+	default:
+		logger := internal.GetLogger(ctx)
+		logger.Info("Adding product", "product", product)
+		product = withDefaults(product)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		snapshot := c.Snapshot()
+		errs := c.check(snapshot, product, "AddProduct")
+		if _, err := snapshot.GetProduct(product.SKU); err == nil {
+			errs = append(errs, internal.NewDuplicateSKUError(product.SKU))
+		}
+		if len(errs) > 0 {
+			err := internal.NewValidationError(errs...)
+			logger.Error("Invalid product", "sku", product.SKU, "error", err)
+			return err
+		}
+		if err := c.store.Add(ctx, product); err != nil {
+			logger.Error("Failed to store product", "sku", product.SKU, "error", err)
+			return err
+		}
+		c.snapshot.Store(snapshot.with(product))
+		return nil
+	}
+}
+
+// AddProducts adds a batch of products, such as a bulk import, deciding by
+// policy what to do with SKUs already in the catalog. The whole batch is
+// validated first, each product against the catalog as the products before it
+// in the batch would leave it, so a variant may follow its parent. If any
+// product fails, none is added and every problem is reported in one
+// ErrValidation.
+func (c *Catalog) AddProducts(ctx context.Context, policy DuplicatePolicy, products ...Product) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		logger := internal.GetLogger(ctx)
+		logger.Info("Adding products", "count", len(products))
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		snapshot := c.Snapshot().clone()
+		var errs []error
+		var adds, updates []Product
+		for _, product := range products {
+			product = withDefaults(product)
+			current, err := snapshot.GetProduct(product.SKU)
+			exists := err == nil
+			if exists && policy == SkipDuplicates {
+				continue
+			}
+			if exists && policy == RejectDuplicates {
+				errs = append(errs, internal.NewDuplicateSKUError(product.SKU))
+				continue
+			}
+			if exists {
+				product = withHistory(current, product, c.clock.Now())
+			}
+			problems := c.check(snapshot, product, "AddProducts")
+			if exists && !current.Status.CanMoveTo(product.Status) {
+				problems = append(problems, internal.NewInvalidStatusTransitionError(product.SKU, string(current.Status), string(product.Status)))
+			}
+			if len(problems) > 0 {
+				errs = append(errs, problems...)
+				continue
+			}
+			if exists {
+				updates = append(updates, product)
+			} else {
+				adds = append(adds, product)
+			}
+			snapshot.put(product)
+		}
+		if len(errs) > 0 {
+			err := internal.NewValidationError(errs...)
+			logger.Error("Invalid products", "error", err)
+			return err
+		}
+		if err := c.store.Commit(ctx, Batch{Adds: adds, Updates: updates}); err != nil {
+			logger.Error("Failed to store products", "error", err)
+			return err
+		}
+		c.snapshot.Store(snapshot)
+		return nil
+	}
+}
+
+// UpdateProduct replaces the product with the same SKU, for example to change
+// its price or name. A product given without a status keeps its current one;
+// otherwise the change of status must be allowed, see Status.CanMoveTo. A
+// product given without Prices keeps the current price history, and a new
+// Price is recorded in it from now on, so PriceAt still finds the old price
+// for earlier times. The product must pass the catalog's validators, as for
+// AddProduct.
+func (c *Catalog) UpdateProduct(ctx context.Context, product Product) error {
+	internal.GetLogger(ctx).Info("Updating product", "product", product)
+	return c.update(ctx, product.SKU, "UpdateProduct", func(Product) Product { return product })
+}
+
+// update replaces the product listed under sku with what change makes of the
+// current one. The product is read, changed and written back while holding
+// the writer lock, so a change made meanwhile by another writer is never lost.
+func (c *Catalog) update(ctx context.Context, sku, op string, change func(current Product) Product) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
 	default:
+		logger := internal.GetLogger(ctx)
 		if sku == "" {
-			logger.Error("SKU cannot be empty")
-			return nil, internal.NewEmptySKUError("GetProducts")
+			logger.Error("Product SKU cannot be empty")
+			return internal.NewEmptySKUError(op)
 		}
-		products, ok := c.products[sku]
-		if !ok || len(products) == 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		snapshot := c.Snapshot()
+		current, err := snapshot.GetProduct(sku)
+		if err != nil {
 			logger.Error("Product with SKU not found", "sku", sku)
-			return nil, internal.NewProductNotFoundError(sku)
+			return err
+		}
+		product := change(current.clone())
+		if product.Status == "" {
+			product.Status = current.Status
 		}
-		return products, nil
+		product = withHistory(current, product, c.clock.Now())
+		errs := c.check(snapshot, product, op)
+		if !current.Status.CanMoveTo(product.Status) {
+			errs = append(errs, internal.NewInvalidStatusTransitionError(product.SKU, string(current.Status), string(product.Status)))
+		}
+		if len(errs) > 0 {
+			err := internal.NewValidationError(errs...)
+			logger.Error("Invalid product", "sku", product.SKU, "error", err)
+			return err
+		}
+		if err := c.store.Update(ctx, product); err != nil {
+			logger.Error("Failed to store product", "sku", product.SKU, "error", err)
+			return err
+		}
+		c.snapshot.Store(snapshot.with(product))
+		return nil
 	}
 }
 
-func (c *Catalog) Products() map[string][]Product {
-	return c.products
+// SetStatus moves the product with the given SKU to another lifecycle status,
+// keeping the rest of the product as it is when the change is made
+func (c *Catalog) SetStatus(ctx context.Context, sku string, status Status) error {
+	internal.GetLogger(ctx).Info("Setting product status", "sku", sku, "status", status)
+	return c.update(ctx, sku, "SetStatus", func(product Product) Product {
+		product.Status = status
+		return product
+	})
 }
 
-func (c *Catalog) AddProduct(ctx context.Context, product Product) error {
+// DeleteProduct removes the product with the given SKU from the catalog
+// altogether. Discontinue a product instead to keep it on record. A parent
+// can only be deleted once it has no variants left.
+func (c *Catalog) DeleteProduct(ctx context.Context, sku string) error {
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
 	default:
 		logger := internal.GetLogger(ctx)
-		logger.Info("Adding product", "product", product)
-		if product.SKU == "" {
-			logger.Error("Product SKU cannot be empty")
-			return internal.NewEmptySKUError("AddProduct")
+		logger.Info("Deleting product", "sku", sku)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, err := c.Snapshot().GetProduct(sku); err != nil {
+			logger.Error("Product with SKU not found", "sku", sku)
+			return err
 		}
-		c.products[product.SKU] = append(c.products[product.SKU], product)
+		if variants := c.Snapshot().variants[sku]; len(variants) > 0 {
+			logger.Error("Product has variants", "sku", sku)
+			return internal.NewInvalidProductError(sku, "delete its variants first")
+		}
+		if err := c.store.Delete(ctx, sku); err != nil {
+			logger.Error("Failed to delete product", "sku", sku, "error", err)
+			return err
+		}
+		c.snapshot.Store(c.Snapshot().without(sku))
 		return nil
 	}
 }
 
+// Variants returns the variants of the product with the given SKU, ordered by
+// SKU. A product without variants has none.
+func (c *Catalog) Variants(ctx context.Context, sku string) ([]Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Snapshot().Variants(sku)
+}
+
+// GetProducts returns the product listed under sku as a one-element list.
+//
+// Deprecated: a SKU lists exactly one product; use GetProduct.
+func (c *Catalog) GetProducts(ctx context.Context, sku string) ([]Product, error) {
+	product, err := c.GetProduct(ctx, sku)
+	if err != nil {
+		return nil, err
+	}
+	return []Product{product}, nil
+}
+
 func (c *Catalog) GetProduct(ctx context.Context, sku string) (Product, error) {
 	select {
 	case <-ctx.Done():
@@ -88,11 +455,16 @@ func (c *Catalog) GetProduct(ctx context.Context, sku string) (Product, error) {
 	default:
 		logger := internal.GetLogger(ctx)
 		logger.Info("Getting product", "sku", sku)
-		products, err := c.GetProducts(ctx, sku)
+		product, err := c.Snapshot().GetProduct(sku)
 		if err != nil {
+			if sku == "" {
+				logger.Error("SKU cannot be empty")
+			} else {
+				logger.Error("Product with SKU not found", "sku", sku)
+			}
 			return Product{}, err
 		}
-		logger.Info("Product found", "product", products[0])
-		return products[0], nil
+		logger.Info("Product found", "product", product)
+		return product, nil
 	}
 }
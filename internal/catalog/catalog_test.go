@@ -2,36 +2,38 @@ package catalog_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
 	"github.com/spa5k/zeller_go/internal/catalog"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGetProducts_Success(t *testing.T) {
+func TestGetProduct_Success(t *testing.T) {
 	c := catalog.NewCatalog()
-	products, err := c.GetProducts(context.Background(), "ipd")
+	product, err := c.GetProduct(context.Background(), "ipd")
 	assert.NoError(t, err)
-	assert.Len(t, products, 1)
-	product := products[0]
 	assert.Equal(t, "ipd", product.SKU)
 	assert.Equal(t, "Super iPad", product.Name)
 	assert.Equal(t, decimal.NewFromFloat(549.99), product.Price)
 }
 
-func TestGetProducts_EmptySKU(t *testing.T) {
+func TestGetProduct_EmptySKU(t *testing.T) {
 	c := catalog.NewCatalog()
-	_, err := c.GetProducts(context.Background(), "")
+	_, err := c.GetProduct(context.Background(), "")
 	assert.Error(t, err)
-	assert.EqualError(t, err, "empty SKU provided: GetProducts")
+	assert.EqualError(t, err, "empty SKU provided: GetProduct")
 }
 
-func TestGetProducts_ProductNotFound(t *testing.T) {
+func TestGetProduct_ProductNotFound(t *testing.T) {
 	c := catalog.NewCatalog()
-	_, err := c.GetProducts(context.Background(), "unknown")
+	_, err := c.GetProduct(context.Background(), "unknown")
 	assert.Error(t, err)
 	assert.EqualError(t, err, "product not found: unknown")
 }
@@ -39,30 +41,29 @@ func TestGetProducts_ProductNotFound(t *testing.T) {
 func TestNewCatalog(t *testing.T) {
 	c := catalog.NewCatalog()
 	assert.NotNil(t, c)
-	assert.NotEmpty(t, c.Products())
+	assert.NotEmpty(t, c.ProductsBySKU())
 }
 
-func TestGetProducts_NegativePrice(t *testing.T) {
+func TestAddProduct_NegativePrice(t *testing.T) {
 	c := catalog.NewCatalog()
 	err := c.AddProduct(context.Background(), catalog.Product{SKU: "negprice", Name: "Negative Price Product", Price: decimal.NewFromFloat(-99.99)})
-	assert.NoError(t, err)
-	products, err := c.GetProducts(context.Background(), "negprice")
-	assert.NoError(t, err)
-	assert.Len(t, products, 1)
-	assert.Equal(t, decimal.NewFromFloat(-99.99), products[0].Price)
+	var negative internal.ErrNegativePrice
+	assert.True(t, errors.As(err, &negative))
+	assert.EqualError(t, err, "negative price not allowed for product negprice: -99.99")
+	_, err = c.GetProduct(context.Background(), "negprice")
+	assert.EqualError(t, err, "product not found: negprice")
 }
 
-func TestGetProducts_ZeroPrice(t *testing.T) {
+func TestGetProduct_ZeroPrice(t *testing.T) {
 	c := catalog.NewCatalog()
 	err := c.AddProduct(context.Background(), catalog.Product{SKU: "zeroprice", Name: "Zero Price Product", Price: decimal.NewFromFloat(0.0)})
 	assert.NoError(t, err)
-	products, err := c.GetProducts(context.Background(), "zeroprice")
+	product, err := c.GetProduct(context.Background(), "zeroprice")
 	assert.NoError(t, err)
-	assert.Len(t, products, 1)
-	assert.Equal(t, decimal.NewFromFloat(0.0), products[0].Price)
+	assert.Equal(t, decimal.NewFromFloat(0.0), product.Price)
 }
 
-func TestGetProducts_LargeCatalog(t *testing.T) {
+func TestGetProduct_LargeCatalog(t *testing.T) {
 	c := catalog.NewCatalog()
 	numProducts := 1000
 	for i := 0; i < numProducts; i++ {
@@ -72,17 +73,16 @@ func TestGetProducts_LargeCatalog(t *testing.T) {
 	}
 	initialProductCount := 4 // Number of initial products
 	expectedProductCount := numProducts + initialProductCount
-	assert.Len(t, c.Products(), expectedProductCount)
+	assert.Len(t, c.ProductsBySKU(), expectedProductCount)
 }
 
-func TestGetProducts_MaxFloatPrice(t *testing.T) {
+func TestGetProduct_MaxFloatPrice(t *testing.T) {
 	c := catalog.NewCatalog()
 	err := c.AddProduct(context.Background(), catalog.Product{SKU: "maxfloat", Name: "Max Float Price Product", Price: decimal.NewFromFloat(math.MaxFloat64)})
 	assert.NoError(t, err)
-	products, err := c.GetProducts(context.Background(), "maxfloat")
+	product, err := c.GetProduct(context.Background(), "maxfloat")
 	assert.NoError(t, err)
-	assert.Len(t, products, 1)
-	assert.Equal(t, decimal.NewFromFloat(math.MaxFloat64), products[0].Price)
+	assert.Equal(t, decimal.NewFromFloat(math.MaxFloat64), product.Price)
 }
 
 func TestAddProduct_EmptySKU(t *testing.T) {
@@ -95,12 +95,9 @@ func TestAddProduct_EmptySKU(t *testing.T) {
 func TestAddProduct_EmptyName(t *testing.T) {
 	c := catalog.NewCatalog()
 	err := c.AddProduct(context.Background(), catalog.Product{SKU: "emptyname", Name: "", Price: decimal.NewFromFloat(100.0)})
-	// Assuming that the catalog allows empty product names
-	assert.NoError(t, err)
-	products, err := c.GetProducts(context.Background(), "emptyname")
-	assert.NoError(t, err)
-	assert.Len(t, products, 1)
-	assert.Equal(t, "", products[0].Name)
+	var invalid internal.ErrInvalidProduct
+	assert.True(t, errors.As(err, &invalid))
+	assert.EqualError(t, err, "invalid product emptyname: name cannot be empty")
 }
 
 func TestAddProduct_DuplicateSKU(t *testing.T) {
@@ -108,34 +105,276 @@ func TestAddProduct_DuplicateSKU(t *testing.T) {
 	err := c.AddProduct(context.Background(), catalog.Product{SKU: "duplicate", Name: "Duplicate SKU Product", Price: decimal.NewFromFloat(100.0)})
 	assert.NoError(t, err)
 	err = c.AddProduct(context.Background(), catalog.Product{SKU: "duplicate", Name: "Duplicate SKU Product 2", Price: decimal.NewFromFloat(200.0)})
+	var duplicate internal.ErrDuplicateSKU
+	assert.True(t, errors.As(err, &duplicate))
+	assert.EqualError(t, err, "product already exists: duplicate")
+
+	// The first product is kept
+	product, err := c.GetProduct(context.Background(), "duplicate")
 	assert.NoError(t, err)
+	assert.Equal(t, "Duplicate SKU Product", product.Name)
+	assert.Equal(t, decimal.NewFromFloat(100.0), product.Price)
+}
 
-	products, err := c.GetProducts(context.Background(), "duplicate")
+func TestUpdateProduct(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.UpdateProduct(context.Background(), catalog.Product{SKU: "ipd", Name: "Super iPad Pro", Price: decimal.NewFromFloat(649.99)})
 	assert.NoError(t, err)
-	assert.Len(t, products, 2)
 
-	// Verify both products are stored
-	assert.Equal(t, "Duplicate SKU Product", products[0].Name)
-	assert.Equal(t, decimal.NewFromFloat(100.0), products[0].Price)
+	product, err := c.GetProduct(context.Background(), "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "Super iPad Pro", product.Name)
+	assert.Equal(t, decimal.NewFromFloat(649.99), product.Price)
+	assert.Equal(t, catalog.Active, product.Status, "an update without a status keeps the current one")
+	assert.Len(t, c.ProductsBySKU(), 4)
+}
 
-	assert.Equal(t, "Duplicate SKU Product 2", products[1].Name)
-	assert.Equal(t, decimal.NewFromFloat(200.0), products[1].Price)
+func TestUpdateProduct_NotFound(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.UpdateProduct(context.Background(), catalog.Product{SKU: "unknown", Name: "Unknown"})
+	assert.EqualError(t, err, "product not found: unknown")
+	err = c.UpdateProduct(context.Background(), catalog.Product{Name: "No SKU"})
+	assert.EqualError(t, err, "empty SKU provided: UpdateProduct")
 }
 
-func TestGetProducts_MultipleProductsPerSKU(t *testing.T) {
+func TestDeleteProduct(t *testing.T) {
 	c := catalog.NewCatalog()
-	// Add multiple products with the same SKU
-	err := c.AddProduct(context.Background(), catalog.Product{SKU: "ipd", Name: "Super iPad Pro", Price: decimal.NewFromFloat(649.99)})
+	assert.NoError(t, c.DeleteProduct(context.Background(), "vga"))
+
+	_, err := c.GetProduct(context.Background(), "vga")
+	assert.EqualError(t, err, "product not found: vga")
+	assert.Len(t, c.ProductsBySKU(), 3)
+	assert.EqualError(t, c.DeleteProduct(context.Background(), "vga"), "product not found: vga")
+
+	// The SKU is free to use again
+	assert.NoError(t, c.AddProduct(context.Background(), catalog.Product{SKU: "vga", Name: "VGA adapter", Price: decimal.NewFromFloat(25.00)}))
+}
+
+func TestProduct_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	err := c.AddProduct(ctx, catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.NewFromFloat(19.99), Status: catalog.Draft})
 	assert.NoError(t, err)
 
-	products, err := c.GetProducts(context.Background(), "ipd")
+	product, err := c.GetProduct(ctx, "hdmi")
 	assert.NoError(t, err)
-	assert.Len(t, products, 2)
+	assert.Equal(t, catalog.Draft, product.Status)
+	assert.False(t, product.Status.Sellable())
 
-	// Verify both products are retrieved
+	assert.NoError(t, c.SetStatus(ctx, "hdmi", catalog.Active))
+	assert.NoError(t, c.SetStatus(ctx, "hdmi", catalog.Discontinued))
+
+	var transition internal.ErrInvalidStatusTransition
+	err = c.SetStatus(ctx, "hdmi", catalog.Draft)
+	assert.True(t, errors.As(err, &transition))
+	assert.EqualError(t, err, "product hdmi cannot move from discontinued to draft")
+
+	// A discontinued product can be brought back
+	assert.NoError(t, c.SetStatus(ctx, "hdmi", catalog.Active))
+
+	var invalid internal.ErrInvalidProduct
+	err = c.SetStatus(ctx, "hdmi", catalog.Status("retired"))
+	assert.True(t, errors.As(err, &invalid))
+	err = c.AddProduct(ctx, catalog.Product{SKU: "dvi", Name: "DVI cable", Status: catalog.Status("retired")})
+	assert.True(t, errors.As(err, &invalid))
+}
+
+func TestDeprecatedProductLists(t *testing.T) {
+	c := catalog.NewCatalog()
+	products, err := c.GetProducts(context.Background(), "ipd")
+	assert.NoError(t, err)
+	assert.Len(t, products, 1)
 	assert.Equal(t, "Super iPad", products[0].Name)
-	assert.Equal(t, decimal.NewFromFloat(549.99), products[0].Price)
+	_, err = c.GetProducts(context.Background(), "unknown")
+	var notFound internal.ErrProductNotFound
+	assert.True(t, errors.As(err, &notFound))
+
+	lists := c.Products()
+	assert.Len(t, lists, len(c.ProductsBySKU()))
+	assert.Equal(t, []catalog.Product{c.ProductsBySKU()["atv"]}, lists["atv"])
+}
+
+func TestProducts_ReturnsCopy(t *testing.T) {
+	c := catalog.NewCatalog()
+	products := c.ProductsBySKU()
+	ipd := products["ipd"]
+	ipd.Price = decimal.Zero
+	products["ipd"] = ipd
+	delete(products, "mbp")
+
+	product, err := c.GetProduct(context.Background(), "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, decimal.NewFromFloat(549.99), product.Price)
+	_, err = c.GetProduct(context.Background(), "mbp")
+	assert.NoError(t, err)
+}
+
+func TestSnapshot_Immutable(t *testing.T) {
+	c := catalog.NewCatalog()
+	snapshot := c.Snapshot()
+
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.NewFromFloat(19.99)})
+	assert.NoError(t, err)
+	err = c.UpdateProduct(context.Background(), catalog.Product{SKU: "ipd", Name: "Super iPad", Price: decimal.NewFromFloat(499.99)})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 4, snapshot.Len())
+	_, err = snapshot.GetProduct("hdmi")
+	assert.EqualError(t, err, "product not found: hdmi")
+	product, err := snapshot.GetProduct("ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, decimal.NewFromFloat(549.99), product.Price)
+
+	assert.Equal(t, 5, c.Snapshot().Len())
+	product, err = c.GetProduct(context.Background(), "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, decimal.NewFromFloat(499.99), product.Price)
+}
+
+func TestCatalog_ConcurrentAccess(t *testing.T) {
+	c := catalog.NewCatalog()
+	const writers, readers, perWorker = 8, 8, 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				sku := fmt.Sprintf("sku-%d-%d", w, i)
+				err := c.AddProduct(context.Background(), catalog.Product{SKU: sku, Name: sku, Price: decimal.NewFromInt(int64(i))})
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				product, err := c.GetProduct(context.Background(), "ipd")
+				assert.NoError(t, err)
+				assert.Equal(t, "Super iPad", product.Name)
+				snapshot := c.Snapshot()
+				assert.Len(t, snapshot.ProductsBySKU(), snapshot.Len())
+			}
+		}()
+	}
+	wg.Wait()
+
+	// No write is lost
+	assert.Len(t, c.ProductsBySKU(), 4+writers*perWorker)
+	for w := 0; w < writers; w++ {
+		_, err := c.GetProduct(context.Background(), fmt.Sprintf("sku-%d-%d", w, perWorker-1))
+		assert.NoError(t, err)
+	}
+}
+
+// slowStore holds up the first update it records until release is closed
+type slowStore struct {
+	*catalog.MemoryStore
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStore) Update(ctx context.Context, product catalog.Product) error {
+	s.once.Do(func() {
+		close(s.started)
+		<-s.release
+	})
+	return s.MemoryStore.Update(ctx, product)
+}
+
+// raceUpdate runs change while an update of vga's price to 99.00 is held up
+// in the store, and returns the catalog once both are done
+func raceUpdate(t *testing.T, change func(*catalog.Catalog) error) *catalog.Catalog {
+	t.Helper()
+	ctx := context.Background()
+	store := &slowStore{MemoryStore: catalog.NewMemoryStore(catalog.SeedProducts()...), started: make(chan struct{}), release: make(chan struct{})}
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "vga", Name: "VGA adapter", Price: decimal.RequireFromString("99.00")}))
+	}()
+	<-store.started
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, change(c))
+	}()
+	// Give the change time to read the product before the price is stored
+	time.Sleep(20 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+	return c
+}
+
+func TestSetStatus_KeepsConcurrentUpdate(t *testing.T) {
+	c := raceUpdate(t, func(c *catalog.Catalog) error {
+		return c.SetStatus(context.Background(), "vga", catalog.Discontinued)
+	})
+	product, err := c.GetProduct(context.Background(), "vga")
+	assert.NoError(t, err)
+	assert.Equal(t, "99", product.Price.String())
+	assert.Equal(t, catalog.Discontinued, product.Status)
+}
+
+func addVariants(t *testing.T, c *catalog.Catalog) {
+	for _, variant := range []catalog.Product{
+		{SKU: "ipd-256-blue", Name: "Super iPad 256GB Blue", Price: decimal.NewFromFloat(699.99), Parent: "ipd", Attributes: map[string]string{"storage": "256GB", "colour": "blue"}},
+		{SKU: "ipd-128-grey", Name: "Super iPad 128GB Grey", Price: decimal.NewFromFloat(549.99), Parent: "ipd", Attributes: map[string]string{"storage": "128GB", "colour": "grey"}},
+	} {
+		assert.NoError(t, c.AddProduct(context.Background(), variant))
+	}
+}
+
+func TestVariants(t *testing.T) {
+	c := catalog.NewCatalog()
+	addVariants(t, c)
+
+	variants, err := c.Variants(context.Background(), "ipd")
+	assert.NoError(t, err)
+	assert.Len(t, variants, 2)
+	assert.Equal(t, "ipd-128-grey", variants[0].SKU)
+	assert.Equal(t, "ipd-256-blue", variants[1].SKU)
+	assert.Equal(t, "256GB", variants[1].Attributes["storage"])
+	assert.Equal(t, decimal.NewFromFloat(699.99), variants[1].Price)
+
+	variants, err = c.Variants(context.Background(), "mbp")
+	assert.NoError(t, err)
+	assert.Empty(t, variants)
+	_, err = c.Variants(context.Background(), "unknown")
+	assert.EqualError(t, err, "product not found: unknown")
+
+	// Attributes handed out are copies
+	variant, err := c.GetProduct(context.Background(), "ipd-256-blue")
+	assert.NoError(t, err)
+	variant.Attributes["colour"] = "red"
+	variant, err = c.GetProduct(context.Background(), "ipd-256-blue")
+	assert.NoError(t, err)
+	assert.Equal(t, "blue", variant.Attributes["colour"])
+}
+
+func TestVariants_Validation(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	addVariants(t, c)
+
+	err := c.AddProduct(ctx, catalog.Product{SKU: "tv-4k", Name: "4K TV", Parent: "tv"})
+	assert.EqualError(t, err, "invalid product tv-4k: parent tv not found")
+	err = c.AddProduct(ctx, catalog.Product{SKU: "ipd-256-blue-case", Name: "Case", Parent: "ipd-256-blue"})
+	assert.EqualError(t, err, "invalid product ipd-256-blue-case: parent ipd-256-blue is itself a variant")
+	err = c.UpdateProduct(ctx, catalog.Product{SKU: "ipd", Name: "Super iPad", Price: decimal.NewFromFloat(549.99), Parent: "mbp"})
+	assert.EqualError(t, err, "invalid product ipd: a product with variants cannot be a variant")
+	err = c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: "Apple TV", Parent: "atv"})
+	assert.EqualError(t, err, "invalid product atv: a product cannot be a variant of itself")
 
-	assert.Equal(t, "Super iPad Pro", products[1].Name)
-	assert.Equal(t, decimal.NewFromFloat(649.99), products[1].Price)
+	assert.EqualError(t, c.DeleteProduct(ctx, "ipd"), "invalid product ipd: delete its variants first")
+	assert.NoError(t, c.DeleteProduct(ctx, "ipd-256-blue"))
+	assert.NoError(t, c.DeleteProduct(ctx, "ipd-128-grey"))
+	assert.NoError(t, c.DeleteProduct(ctx, "ipd"))
 }
@@ -0,0 +1,84 @@
+package catalog
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// CategorySeparator separates the levels of a category path, such as
+// "accessories/cables"
+const CategorySeparator = "/"
+
+// WithinCategory reports whether category is ancestor or one of its
+// subcategories, so "accessories/cables" is within "accessories". Category
+// names are compared without regard to case.
+func WithinCategory(category, ancestor string) bool {
+	if ancestor == "" || len(category) < len(ancestor) {
+		return false
+	}
+	if !strings.EqualFold(category[:len(ancestor)], ancestor) {
+		return false
+	}
+	return len(category) == len(ancestor) || strings.HasPrefix(category[len(ancestor):], CategorySeparator)
+}
+
+// InCategory reports whether the product is in category or one of its
+// subcategories
+func (p Product) InCategory(category string) bool {
+	return WithinCategory(p.Category, category)
+}
+
+// HasTag reports whether the product carries tag, ignoring case
+func (p Product) HasTag(tag string) bool {
+	return slices.ContainsFunc(p.Tags, func(t string) bool {
+		return strings.EqualFold(t, tag)
+	})
+}
+
+// Categories returns every category the snapshot's products are in, together
+// with the categories above them, sorted so each category comes just before
+// its subcategories
+func (s *Snapshot) Categories() []string {
+	categories := make(map[string]bool)
+	for _, product := range s.products {
+		path := product.Category
+		for path != "" {
+			categories[path] = true
+			i := strings.LastIndex(path, CategorySeparator)
+			if i < 0 {
+				break
+			}
+			path = path[:i]
+		}
+	}
+	return slices.SortedFunc(maps.Keys(categories), func(a, b string) int {
+		return slices.Compare(strings.Split(a, CategorySeparator), strings.Split(b, CategorySeparator))
+	})
+}
+
+// Categories returns the category tree of the catalog's products, see
+// Snapshot.Categories
+func (c *Catalog) Categories() []string {
+	return c.Snapshot().Categories()
+}
+
+// validateClassification checks that the product's category path has no
+// empty levels and that its tags are neither empty nor repeated
+func validateClassification(product Product) []error {
+	var errs []error
+	if product.Category != "" && slices.Contains(strings.Split(product.Category, CategorySeparator), "") {
+		errs = append(errs, internal.NewInvalidProductError(product.SKU, "category "+product.Category+" has an empty level"))
+	}
+	for i, tag := range product.Tags {
+		switch {
+		case tag == "":
+			errs = append(errs, internal.NewInvalidProductError(product.SKU, "tags cannot be empty"))
+		case Product{Tags: product.Tags[:i]}.HasTag(tag):
+			errs = append(errs, internal.NewInvalidProductError(product.SKU, "tag "+tag+" appears twice"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,77 @@
+package catalog_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithinCategory(t *testing.T) {
+	assert.True(t, catalog.WithinCategory("accessories", "accessories"))
+	assert.True(t, catalog.WithinCategory("accessories/cables", "accessories"))
+	assert.True(t, catalog.WithinCategory("Accessories/Cables", "accessories/cables"))
+	assert.False(t, catalog.WithinCategory("accessories-old", "accessories"))
+	assert.False(t, catalog.WithinCategory("accessories", "accessories/cables"))
+	assert.False(t, catalog.WithinCategory("", ""))
+}
+
+func TestCategories(t *testing.T) {
+	c := catalog.NewCatalog()
+	assert.NoError(t, c.AddProduct(context.Background(), catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.NewFromInt(20), Category: "accessories/cables/video"}))
+
+	assert.Equal(t, []string{
+		"accessories",
+		"accessories/adapters",
+		"accessories/cables",
+		"accessories/cables/video",
+		"computers",
+		"computers/laptops",
+		"computers/tablets",
+		"home-entertainment",
+	}, c.Categories())
+
+	product, err := c.GetProduct(context.Background(), "hdmi")
+	assert.NoError(t, err)
+	assert.True(t, product.InCategory("accessories/cables"))
+	assert.False(t, product.InCategory("computers"))
+}
+
+func TestClassification_Validation(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{
+		SKU:      "hdmi",
+		Name:     "HDMI cable",
+		Price:    decimal.NewFromInt(20),
+		Category: "accessories//cables",
+		Tags:     []string{"clearance", "", "Clearance"},
+	})
+	assert.EqualError(t, err, "3 validation errors: "+
+		"invalid product hdmi: category accessories//cables has an empty level; "+
+		"invalid product hdmi: tags cannot be empty; "+
+		"invalid product hdmi: tag Clearance appears twice")
+}
+
+func TestClassification_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+	hdmi := catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.NewFromInt(20), Category: "accessories/cables", Brand: "Belkin", Tags: []string{"clearance"}}
+	assert.NoError(t, c.AddProduct(ctx, hdmi))
+	assert.NoError(t, store.Close())
+
+	c, err = catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	product, err := c.GetProduct(ctx, "hdmi")
+	assert.NoError(t, err)
+	assert.Equal(t, "accessories/cables", product.Category)
+	assert.Equal(t, "Belkin", product.Brand)
+	assert.Equal(t, []string{"clearance"}, product.Tags)
+	assert.True(t, product.HasTag("Clearance"))
+}
@@ -0,0 +1,142 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// barcodeLengths lists the lengths of the barcodes the catalog checks: EAN-8,
+// UPC-A, EAN-13 and GTIN-14
+var barcodeLengths = map[int]string{8: "EAN-8", 12: "UPC-A", 13: "EAN-13", 14: "GTIN-14"}
+
+// ValidateBarcode checks that code is an EAN-8, UPC-A, EAN-13 or GTIN-14
+// barcode with a correct check digit
+func ValidateBarcode(code string) error {
+	if !digits(code) {
+		return internal.NewInvalidBarcodeError(code, "must be digits only")
+	}
+	kind, ok := barcodeLengths[len(code)]
+	if !ok {
+		return internal.NewInvalidBarcodeError(code, fmt.Sprintf("has %d digits, expected 8, 12, 13 or 14", len(code)))
+	}
+	if want := checkDigit(code[:len(code)-1]); code[len(code)-1] != want {
+		return internal.NewInvalidBarcodeError(code, fmt.Sprintf("%s check digit should be %c", kind, want))
+	}
+	return nil
+}
+
+// checkDigit returns the GS1 check digit for the digits before it: from the
+// right, digits are weighted 3 and 1 in turn, and the check digit brings the
+// sum up to a multiple of ten
+func checkDigit(code string) byte {
+	sum := 0
+	for i := 0; i < len(code); i++ {
+		weight := 1
+		if i%2 == 0 {
+			weight = 3
+		}
+		sum += int(code[len(code)-1-i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(code string) bool {
+	if code == "" {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if code[i] < '0' || code[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// codeKey returns the form a code is indexed under. Barcodes are padded to 14
+// digits, so a UPC-A barcode and the EAN-13 a scanner may read it as are the
+// same code.
+func codeKey(code string) string {
+	if _, ok := barcodeLengths[len(code)]; ok && digits(code) {
+		return strings.Repeat("0", 14-len(code)) + code
+	}
+	return code
+}
+
+// codes returns the barcodes and aliases of the product
+func (p Product) codes() []string {
+	return append(append([]string(nil), p.Barcodes...), p.Aliases...)
+}
+
+// Resolve returns the product a scanned or typed code stands for: its SKU,
+// one of its barcodes or one of its aliases. A code no product has fails
+// with ErrUnknownCode, or with ErrInvalidBarcode if it looks like a barcode
+// but its check digit is wrong, which usually means it was misread.
+func (s *Snapshot) Resolve(code string) (Product, error) {
+	if code == "" {
+		return Product{}, internal.NewEmptySKUError("Resolve")
+	}
+	if product, ok := s.products[code]; ok {
+		return product.clone(), nil
+	}
+	if sku, ok := s.codes[codeKey(code)]; ok {
+		return s.products[sku].clone(), nil
+	}
+	if _, ok := barcodeLengths[len(code)]; ok && digits(code) {
+		if err := ValidateBarcode(code); err != nil {
+			return Product{}, err
+		}
+	}
+	return Product{}, internal.NewUnknownCodeError(code)
+}
+
+// Resolve returns the product with the given SKU, barcode or alias, see
+// Snapshot.Resolve
+func (c *Catalog) Resolve(ctx context.Context, code string) (Product, error) {
+	if err := ctx.Err(); err != nil {
+		return Product{}, err
+	}
+	logger := internal.GetLogger(ctx)
+	product, err := c.Snapshot().Resolve(code)
+	if err != nil {
+		logger.Error("Cannot resolve code", "code", code, "error", err)
+		return Product{}, err
+	}
+	return product, nil
+}
+
+// validateCodes checks the product's barcodes and aliases: barcodes must have
+// a correct check digit, and no code may be empty or used twice, whether by
+// this product or as the SKU, barcode or alias of another one in snapshot
+func (s *Snapshot) validateCodes(product Product) []error {
+	var errs []error
+	for _, barcode := range product.Barcodes {
+		if err := ValidateBarcode(barcode); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if owner, ok := s.codes[codeKey(product.SKU)]; ok && owner != product.SKU {
+		errs = append(errs, internal.NewDuplicateCodeError(product.SKU, owner))
+	}
+	seen := map[string]bool{codeKey(product.SKU): true}
+	for _, code := range product.codes() {
+		key := codeKey(code)
+		if code == "" {
+			errs = append(errs, internal.NewInvalidProductError(product.SKU, "barcodes and aliases cannot be empty"))
+			continue
+		}
+		if seen[key] {
+			errs = append(errs, internal.NewDuplicateCodeError(code, product.SKU))
+			continue
+		}
+		seen[key] = true
+		if owner, ok := s.codes[key]; ok && owner != product.SKU {
+			errs = append(errs, internal.NewDuplicateCodeError(code, owner))
+		} else if _, ok := s.products[code]; ok && code != product.SKU {
+			errs = append(errs, internal.NewDuplicateCodeError(code, code))
+		}
+	}
+	return errs
+}
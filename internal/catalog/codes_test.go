@@ -0,0 +1,140 @@
+package catalog_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBarcode(t *testing.T) {
+	for _, code := range []string{"96385074", "036000291452", "4006381333931", "10012345678902"} {
+		assert.NoError(t, catalog.ValidateBarcode(code), code)
+	}
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"4006381333932", "invalid barcode 4006381333932: EAN-13 check digit should be 1"},
+		{"036000291453", "invalid barcode 036000291453: UPC-A check digit should be 2"},
+		{"400638133393", "invalid barcode 400638133393: UPC-A check digit should be 0"},
+		{"40063813339", "invalid barcode 40063813339: has 11 digits, expected 8, 12, 13 or 14"},
+		{"4006-381333931", "invalid barcode 4006-381333931: must be digits only"},
+	}
+	for _, test := range tests {
+		err := catalog.ValidateBarcode(test.code)
+		assert.EqualError(t, err, test.expected)
+		var invalid internal.ErrInvalidBarcode
+		assert.True(t, errors.As(err, &invalid))
+	}
+}
+
+func addCodes(t *testing.T, c *catalog.Catalog) {
+	t.Helper()
+	hdmi := catalog.Product{
+		SKU:      "hdmi",
+		Name:     "HDMI cable",
+		Price:    decimal.NewFromInt(20),
+		Barcodes: []string{"4006381333931", "036000291452"},
+		Aliases:  []string{"HDMI-01", "BELKIN-F3Y021"},
+	}
+	assert.NoError(t, c.AddProduct(context.Background(), hdmi))
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	addCodes(t, c)
+
+	// A UPC-A barcode read as an EAN-13 is the same code
+	for _, code := range []string{"hdmi", "4006381333931", "036000291452", "0036000291452", "HDMI-01", "BELKIN-F3Y021"} {
+		product, err := c.Resolve(ctx, code)
+		assert.NoError(t, err, code)
+		assert.Equal(t, "hdmi", product.SKU, code)
+	}
+
+	_, err := c.Resolve(ctx, "hdmi-02")
+	assert.EqualError(t, err, "unknown code hdmi-02: no product has it as SKU, barcode or alias")
+	var unknown internal.ErrUnknownCode
+	assert.True(t, errors.As(err, &unknown))
+	var notFound internal.ErrProductNotFound
+	assert.True(t, errors.As(err, &notFound))
+
+	_, err = c.Resolve(ctx, "9300000000002")
+	assert.True(t, errors.As(err, &unknown))
+
+	// A misread barcode says so
+	_, err = c.Resolve(ctx, "4006381333932")
+	assert.EqualError(t, err, "invalid barcode 4006381333932: EAN-13 check digit should be 1")
+
+	_, err = c.Resolve(ctx, "")
+	var empty internal.ErrEmptySKU
+	assert.True(t, errors.As(err, &empty))
+}
+
+func TestCodes_Unique(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	addCodes(t, c)
+
+	err := c.AddProduct(ctx, catalog.Product{
+		SKU:      "dvi",
+		Name:     "DVI cable",
+		Price:    decimal.NewFromInt(15),
+		Barcodes: []string{"0036000291452", "96385075"},
+		Aliases:  []string{"HDMI-01", "vga", "DVI-01", "DVI-01", ""},
+	})
+	assert.EqualError(t, err, "6 validation errors: "+
+		"invalid barcode 96385075: EAN-8 check digit should be 4; "+
+		"code 0036000291452 is already used by product hdmi; "+
+		"code HDMI-01 is already used by product hdmi; "+
+		"code vga is already used by product vga; "+
+		"code DVI-01 is already used by product dvi; "+
+		"invalid product dvi: barcodes and aliases cannot be empty")
+	var duplicate internal.ErrDuplicateCode
+	assert.True(t, errors.As(err, &duplicate))
+
+	// A new SKU cannot be another product's code either
+	err = c.AddProduct(ctx, catalog.Product{SKU: "HDMI-01", Name: "HDMI cable", Price: decimal.NewFromInt(20)})
+	assert.EqualError(t, err, "code HDMI-01 is already used by product hdmi")
+
+	// Once a product drops a code, another one can take it
+	hdmi, err := c.GetProduct(ctx, "hdmi")
+	assert.NoError(t, err)
+	hdmi.Aliases = []string{"BELKIN-F3Y021"}
+	assert.NoError(t, c.UpdateProduct(ctx, hdmi))
+	assert.NoError(t, c.AddProduct(ctx, catalog.Product{SKU: "hdmi-2", Name: "HDMI cable", Price: decimal.NewFromInt(20), Aliases: []string{"HDMI-01"}}))
+	product, err := c.Resolve(ctx, "HDMI-01")
+	assert.NoError(t, err)
+	assert.Equal(t, "hdmi-2", product.SKU)
+
+	// Deleting a product frees its codes
+	assert.NoError(t, c.DeleteProduct(ctx, "hdmi"))
+	_, err = c.Resolve(ctx, "4006381333931")
+	var unknown internal.ErrUnknownCode
+	assert.True(t, errors.As(err, &unknown))
+}
+
+func TestCodes_SurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+	addCodes(t, c)
+	assert.NoError(t, store.Close())
+
+	c, err = catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	product, err := c.Resolve(ctx, "BELKIN-F3Y021")
+	assert.NoError(t, err)
+	assert.Equal(t, "hdmi", product.SKU)
+	assert.Equal(t, []string{"4006381333931", "036000291452"}, product.Barcodes)
+}
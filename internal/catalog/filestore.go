@@ -0,0 +1,437 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// compactMinRecords is how long the log must grow before it is compacted
+// automatically, so small logs are never rewritten
+const compactMinRecords = 1000
+
+// Log operations. The records of a batch sit between a begin and a commit
+// record, and a batch without its commit record is ignored.
+const (
+	opAdd    = "add"
+	opUpdate = "update"
+	opDelete = "delete"
+	opBegin  = "begin"
+	opCommit = "commit"
+)
+
+// record is one line of a FileStore log
+type record struct {
+	Op         string            `json:"op"`
+	SKU        string            `json:"sku,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Price      decimal.Decimal   `json:"price"`
+	Unit       Unit              `json:"unit,omitempty"`
+	Status     Status            `json:"status,omitempty"`
+	Parent     string            `json:"parent,omitempty"`
+	Barcodes   []string          `json:"barcodes,omitempty"`
+	Aliases    []string          `json:"aliases,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Category   string            `json:"category,omitempty"`
+	Brand      string            `json:"brand,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Prices     []PricePeriod     `json:"prices,omitempty"`
+}
+
+func (r record) product() Product {
+	return Product{
+		SKU:        r.SKU,
+		Name:       r.Name,
+		Price:      r.Price,
+		Unit:       r.Unit,
+		Status:     r.Status,
+		Parent:     r.Parent,
+		Barcodes:   r.Barcodes,
+		Aliases:    r.Aliases,
+		Attributes: r.Attributes,
+		Category:   r.Category,
+		Brand:      r.Brand,
+		Tags:       r.Tags,
+		Prices:     r.Prices,
+	}
+}
+
+func recordOf(op string, product Product) record {
+	return record{
+		Op:         op,
+		SKU:        product.SKU,
+		Name:       product.Name,
+		Price:      product.Price,
+		Unit:       product.Unit,
+		Status:     product.Status,
+		Parent:     product.Parent,
+		Barcodes:   product.Barcodes,
+		Aliases:    product.Aliases,
+		Attributes: product.Attributes,
+		Category:   product.Category,
+		Brand:      product.Brand,
+		Tags:       product.Tags,
+		Prices:     product.Prices,
+	}
+}
+
+// FileStore is a Store that keeps products in an append-only log file, one
+// JSON record per line. Every change is synced to disk before it is
+// acknowledged. Once the log holds more than twice as many records as the
+// products it describes, it is compacted: rewritten with one record per
+// product and swapped in place of the old log in one rename.
+//
+// A record left half-written by a crash is dropped when the store is opened.
+// FileStore is safe for concurrent use, but only one FileStore may have a
+// given log open at a time.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	// records counts the records in the log, and live the products they
+	// add up to
+	records int
+	live    int
+	// failed is set once the log cannot be written safely any more, and is
+	// returned for every later change
+	failed error
+}
+
+// OpenFileStore opens the log at path, creating it if it does not exist
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	records, size, err := readLog(file, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Drop a torn record at the end so the next record starts on a new line
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &FileStore{
+		path:    path,
+		file:    file,
+		records: len(records),
+		live:    len(replay(records)),
+	}, nil
+}
+
+// readLog reads every complete record in the log, leaving out a batch that
+// was never committed. It returns the size of the log up to the end of the
+// last complete record outside a batch.
+func readLog(file *os.File, path string) ([]record, int64, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	var records, batch []record
+	var size, committed int64
+	inBatch := false
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Whatever follows the last newline or commit is a torn write
+			return records, committed, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(data))
+		data = bytes.TrimSpace(data)
+		if len(data) == 0 {
+			if !inBatch {
+				committed = size
+			}
+			continue
+		}
+		var r record
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, 0, internal.NewCorruptStoreError(path, line, err.Error())
+		}
+		switch r.Op {
+		case opAdd, opUpdate, opDelete:
+			if inBatch {
+				batch = append(batch, r)
+				continue
+			}
+			records = append(records, r)
+		case opBegin:
+			if inBatch {
+				return nil, 0, internal.NewCorruptStoreError(path, line, "batch begins inside another batch")
+			}
+			inBatch = true
+		case opCommit:
+			if !inBatch {
+				return nil, 0, internal.NewCorruptStoreError(path, line, "commit outside a batch")
+			}
+			records = append(records, batch...)
+			batch = batch[:0]
+			inBatch = false
+		default:
+			return nil, 0, internal.NewCorruptStoreError(path, line, "unknown operation "+r.Op)
+		}
+		if !inBatch {
+			committed = size
+		}
+	}
+}
+
+// replay returns the products a sequence of records adds up to, in the order
+// they were first added. Logs written before SKUs were unique may add a SKU
+// twice; the first product added is kept, as the catalog always returned it.
+func replay(records []record) []Product {
+	var products []Product
+	index := make(map[string]int)
+	for _, r := range records {
+		i, ok := index[r.SKU]
+		switch {
+		case r.Op == opAdd && !ok:
+			index[r.SKU] = len(products)
+			products = append(products, r.product())
+		case r.Op == opUpdate && ok:
+			products[i] = r.product()
+		case r.Op == opDelete && ok:
+			products = slices.Delete(products, i, i+1)
+			delete(index, r.SKU)
+			for sku, j := range index {
+				if j > i {
+					index[sku] = j - 1
+				}
+			}
+		}
+	}
+	return products
+}
+
+func (s *FileStore) Load(ctx context.Context) ([]Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *FileStore) load() ([]Product, error) {
+	records, _, err := readLog(s.file, s.path)
+	if err != nil {
+		return nil, err
+	}
+	return replay(records), nil
+}
+
+func (s *FileStore) Add(ctx context.Context, product Product) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(recordOf(opAdd, product)); err != nil {
+		return err
+	}
+	s.live++
+	s.maybeCompact(ctx)
+	return nil
+}
+
+func (s *FileStore) Update(ctx context.Context, product Product) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(recordOf(opUpdate, product)); err != nil {
+		return err
+	}
+	s.maybeCompact(ctx)
+	return nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, sku string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(record{Op: opDelete, SKU: sku}); err != nil {
+		return err
+	}
+	s.live--
+	s.maybeCompact(ctx)
+	return nil
+}
+
+// Commit writes the batch between a begin and a commit record with a single
+// write, so a crash part way through leaves a batch that is ignored
+func (s *FileStore) Commit(ctx context.Context, batch Batch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if batch.Empty() {
+		return nil
+	}
+	records := []record{{Op: opBegin}}
+	for _, product := range batch.Adds {
+		records = append(records, recordOf(opAdd, product))
+	}
+	for _, product := range batch.Updates {
+		records = append(records, recordOf(opUpdate, product))
+	}
+	for _, sku := range batch.Deletes {
+		records = append(records, record{Op: opDelete, SKU: sku})
+	}
+	records = append(records, record{Op: opCommit})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(records...); err != nil {
+		return err
+	}
+	s.live += len(batch.Adds) - len(batch.Deletes)
+	s.maybeCompact(ctx)
+	return nil
+}
+
+// append writes records to the end of the log and syncs them to disk. If that
+// fails part way, the log is cut back to where it ended, so the next record
+// does not land behind half of one and leave the log unreadable.
+func (s *FileStore) append(records ...record) error {
+	if s.failed != nil {
+		return s.failed
+	}
+	var data []byte
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.write(data); err != nil {
+		if cut := s.file.Truncate(info.Size()); cut != nil {
+			s.failed = internal.NewStoreFailedError(s.path, errors.Join(err, cut))
+		}
+		return err
+	}
+	s.records += len(records)
+	return nil
+}
+
+func (s *FileStore) write(data []byte) error {
+	if _, err := s.file.Write(data); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// maybeCompact compacts the log once it has grown too long. It runs after a
+// change has been synced, so a failure is only logged: the change is already
+// durable, and reporting it as failed would have the caller retry a change
+// that is in the log.
+func (s *FileStore) maybeCompact(ctx context.Context) {
+	if s.records < compactMinRecords || s.records <= 2*s.live {
+		return
+	}
+	if err := s.compact(); err != nil {
+		internal.GetLogger(ctx).Error("Cannot compact catalog log", "path", s.path, "error", err)
+	}
+}
+
+// Compact rewrites the log with one record per product
+func (s *FileStore) Compact(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *FileStore) compact() error {
+	if s.failed != nil {
+		return s.failed
+	}
+	products, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	// The new log is written beside the old one and renamed over it, so a
+	// crash at any point leaves one complete log or the other
+	temp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	writer := bufio.NewWriter(temp)
+	encoder := json.NewEncoder(writer)
+	for _, product := range products {
+		if err := encoder.Encode(recordOf(opAdd, product)); err != nil {
+			temp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), s.path); err != nil {
+		return err
+	}
+
+	// The old log is gone from the directory now, so writing on to it would
+	// lose every change. The store moves to the new log or refuses changes.
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		s.failed = internal.NewStoreFailedError(s.path, err)
+		return s.failed
+	}
+	s.file.Close()
+	s.file = file
+	s.records = len(products)
+	s.live = len(products)
+	return syncDir(filepath.Dir(s.path))
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Close closes the log file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,714 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// Format is a file format the catalog can be imported from and exported to
+type Format string
+
+const (
+	// CSV files have a header row naming the columns, in any order, out of
+	// sku, name, price, unit, status, parent, barcodes, aliases, attributes,
+	// category, brand, tags and prices. Attributes are written as key=value
+	// pairs separated by semicolons, barcodes, aliases and tags separated by
+	// semicolons, and price periods as
+	// from..to=price entries separated by semicolons, with RFC 3339 times and
+	// open ends left empty.
+	CSV Format = "csv"
+	// JSON files hold an array of objects with the same fields as CSV
+	// columns, attributes being an object, barcodes, aliases and tags arrays
+	// of strings and prices an array of objects
+	JSON Format = "json"
+)
+
+// ParseFormat returns the format named s
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(strings.ToLower(s)); format {
+	case CSV, JSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown catalog file format %q", s)
+}
+
+// columns lists the CSV columns in the order Export writes them
+var columns = []string{"sku", "name", "price", "unit", "status", "parent", "barcodes", "aliases", "attributes", "category", "brand", "tags", "prices"}
+
+// ImportOptions configures how an import file is read and compared with the
+// catalog
+type ImportOptions struct {
+	Format Format
+	// DeleteMissing deletes every product the file does not list, so the
+	// catalog ends up matching the file exactly. Otherwise such products are
+	// left alone.
+	DeleteMissing bool
+}
+
+// Change is one product an import adds, updates or deletes
+type Change struct {
+	// Line is where the product starts in the import file, or zero for a
+	// product deleted because the file does not list it
+	Line    int
+	Product Product
+	// Previous is the product as the catalog holds it now, for updates and
+	// deletes
+	Previous Product
+}
+
+// ImportPlan is what an import would change in the catalog. Products the file
+// lists unchanged appear nowhere in it.
+type ImportPlan struct {
+	Adds    []Change
+	Updates []Change
+	Deletes []Change
+	// base is the snapshot the plan was made against, and result the
+	// snapshot applying it gives
+	base   *Snapshot
+	result *Snapshot
+}
+
+// Empty reports whether the import would change nothing
+func (p *ImportPlan) Empty() bool {
+	return len(p.Adds) == 0 && len(p.Updates) == 0 && len(p.Deletes) == 0
+}
+
+// String lists the changes, one per line, followed by a count of each kind
+func (p *ImportPlan) String() string {
+	var b strings.Builder
+	for _, change := range p.Adds {
+		fmt.Fprintf(&b, "+ %s: %q at %s (line %d)\n", change.Product.SKU, change.Product.Name, change.Product.Price, change.Line)
+	}
+	for _, change := range p.Updates {
+		fmt.Fprintf(&b, "~ %s: %s (line %d)\n", change.Product.SKU, strings.Join(differences(change.Previous, change.Product), ", "), change.Line)
+	}
+	for _, change := range p.Deletes {
+		fmt.Fprintf(&b, "- %s: %q\n", change.Previous.SKU, change.Previous.Name)
+	}
+	fmt.Fprintf(&b, "%d to add, %d to update, %d to delete\n", len(p.Adds), len(p.Updates), len(p.Deletes))
+	return b.String()
+}
+
+// differences describes each field that differs between two versions of a
+// product
+func differences(from, to Product) []string {
+	var changes []string
+	if from.Name != to.Name {
+		changes = append(changes, fmt.Sprintf("name %q -> %q", from.Name, to.Name))
+	}
+	if !from.Price.Equal(to.Price) {
+		changes = append(changes, fmt.Sprintf("price %s -> %s", from.Price, to.Price))
+	}
+	if from.Unit != to.Unit {
+		changes = append(changes, fmt.Sprintf("unit %q -> %q", from.Unit, to.Unit))
+	}
+	if from.Status != to.Status {
+		changes = append(changes, fmt.Sprintf("status %s -> %s", from.Status, to.Status))
+	}
+	if from.Parent != to.Parent {
+		changes = append(changes, fmt.Sprintf("parent %q -> %q", from.Parent, to.Parent))
+	}
+	if !slices.Equal(from.Barcodes, to.Barcodes) {
+		changes = append(changes, fmt.Sprintf("barcodes %q -> %q", strings.Join(from.Barcodes, ";"), strings.Join(to.Barcodes, ";")))
+	}
+	if !slices.Equal(from.Aliases, to.Aliases) {
+		changes = append(changes, fmt.Sprintf("aliases %q -> %q", strings.Join(from.Aliases, ";"), strings.Join(to.Aliases, ";")))
+	}
+	if !maps.Equal(from.Attributes, to.Attributes) {
+		changes = append(changes, fmt.Sprintf("attributes %q -> %q", formatAttributes(from.Attributes), formatAttributes(to.Attributes)))
+	}
+	if from.Category != to.Category {
+		changes = append(changes, fmt.Sprintf("category %q -> %q", from.Category, to.Category))
+	}
+	if from.Brand != to.Brand {
+		changes = append(changes, fmt.Sprintf("brand %q -> %q", from.Brand, to.Brand))
+	}
+	if !slices.Equal(from.Tags, to.Tags) {
+		changes = append(changes, fmt.Sprintf("tags %q -> %q", strings.Join(from.Tags, ";"), strings.Join(to.Tags, ";")))
+	}
+	// The old price recorded in the history by a price change goes without
+	// saying
+	prices := to.Prices
+	if n := len(prices); n > len(from.Prices) && !from.Price.Equal(to.Price) && prices[n-1].Price.Equal(from.Price) && prices[n-1].From.IsZero() {
+		prices = prices[:n-1]
+	}
+	if formatPrices(from.Prices) != formatPrices(prices) {
+		changes = append(changes, fmt.Sprintf("prices %q -> %q", formatPrices(from.Prices), formatPrices(prices)))
+	}
+	return changes
+}
+
+// unitEach is how an import row asks for Each by name, since a row that
+// leaves the unit empty keeps the current one
+const unitEach Unit = "each"
+
+// keepUnset fills in the fields an import row left empty from current, the
+// product it updates, so a file with only some columns changes only those.
+// For a new product current is the zero Product.
+func keepUnset(current, product Product) Product {
+	switch product.Unit {
+	case Each:
+		product.Unit = current.Unit
+	case unitEach:
+		product.Unit = Each
+	}
+	if product.Parent == "" {
+		product.Parent = current.Parent
+	}
+	if product.Attributes == nil {
+		product.Attributes = current.Attributes
+	}
+	if product.Status == "" {
+		product.Status = current.Status
+	}
+	if product.Category == "" {
+		product.Category = current.Category
+	}
+	if product.Brand == "" {
+		product.Brand = current.Brand
+	}
+	if product.Barcodes == nil {
+		product.Barcodes = current.Barcodes
+	}
+	if product.Aliases == nil {
+		product.Aliases = current.Aliases
+	}
+	if product.Tags == nil {
+		product.Tags = current.Tags
+	}
+	return product
+}
+
+// Import reads a catalog file and applies it, all or nothing. It returns the
+// plan it applied.
+func (c *Catalog) Import(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportPlan, error) {
+	plan, err := c.PlanImport(ctx, r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ApplyImport(ctx, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanImport reads a catalog file and works out what importing it would
+// change, without changing anything: a dry run. The file is read one row at a
+// time, so it never has to fit in memory.
+//
+// Every row is parsed and run through the catalog's validators as if the rows
+// before it had been imported, so a variant must come after its parent, and a
+// barcode or alias can only move to another product once a row before has
+// taken it off the first. Any field a row leaves empty, or that the file has
+// no column for, keeps the current value of the product it updates, so a
+// file of just sku, name and price changes names and prices only; a row
+// without prices keeps the price history, as for UpdateProduct. A unit of
+// "each" turns a product sold by measure back into one sold whole.
+// If any row has problems, they are all reported in one ErrValidation, each
+// wrapped in an ErrImportRow giving its line.
+func (c *Catalog) PlanImport(ctx context.Context, r io.Reader, opts ImportOptions) (*ImportPlan, error) {
+	base := c.Snapshot()
+	working := base.clone()
+	plan := &ImportPlan{base: base}
+	lines := make(map[string]int)
+	var errs []error
+	rowError := func(line int, err error) {
+		errs = append(errs, internal.NewImportRowError(line, err))
+	}
+
+	err := readRows(r, opts.Format, func(line int, product Product, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			for _, err := range unjoin(err) {
+				rowError(line, err)
+			}
+			return nil
+		}
+		if _, ok := lines[product.SKU]; ok && product.SKU != "" {
+			rowError(line, internal.NewDuplicateSKUError(product.SKU))
+			return nil
+		}
+		lines[product.SKU] = line
+
+		current, err := base.GetProduct(product.SKU)
+		exists := err == nil
+		product = keepUnset(current, product)
+		if exists {
+			product = withHistory(current, product, c.clock.Now())
+		}
+		product = withDefaults(product)
+		if exists && len(differences(current, product)) == 0 {
+			return nil
+		}
+		problems := c.check(working, product, "Import")
+		if exists && !current.Status.CanMoveTo(product.Status) {
+			problems = append(problems, internal.NewInvalidStatusTransitionError(product.SKU, string(current.Status), string(product.Status)))
+		}
+		for _, problem := range problems {
+			rowError(line, problem)
+		}
+		if len(problems) > 0 {
+			return nil
+		}
+		working.put(product)
+		if exists {
+			plan.Updates = append(plan.Updates, Change{Line: line, Product: product, Previous: current})
+		} else {
+			plan.Adds = append(plan.Adds, Change{Line: line, Product: product})
+		}
+		return nil
+	})
+	if err != nil {
+		var row internal.ErrImportRow
+		if !errors.As(err, &row) {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+
+	if opts.DeleteMissing {
+		for _, sku := range slices.Sorted(maps.Keys(base.products)) {
+			if _, ok := lines[sku]; !ok {
+				plan.Deletes = append(plan.Deletes, Change{Previous: base.products[sku].clone()})
+				working.remove(sku)
+			}
+		}
+		// Variants the file keeps must not lose their parent
+		for _, change := range plan.Deletes {
+			for _, variant := range working.variants[change.Previous.SKU] {
+				rowError(lines[variant], internal.NewInvalidProductError(variant, "parent "+change.Previous.SKU+" is not in the import"))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, internal.NewValidationError(errs...)
+	}
+	plan.result = working
+	return plan, nil
+}
+
+// ApplyImport makes the changes in plan, all or nothing. It fails with
+// ErrStaleImport if the catalog has changed since the plan was made.
+func (c *Catalog) ApplyImport(ctx context.Context, plan *ImportPlan) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		logger := internal.GetLogger(ctx)
+		logger.Info("Importing products", "adds", len(plan.Adds), "updates", len(plan.Updates), "deletes", len(plan.Deletes))
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.Snapshot() != plan.base {
+			logger.Error("Catalog changed since the import was planned")
+			return internal.NewStaleImportError()
+		}
+		var batch Batch
+		for _, change := range plan.Adds {
+			batch.Adds = append(batch.Adds, change.Product)
+		}
+		for _, change := range plan.Updates {
+			batch.Updates = append(batch.Updates, change.Product)
+		}
+		for _, change := range plan.Deletes {
+			batch.Deletes = append(batch.Deletes, change.Previous.SKU)
+		}
+		if err := c.store.Commit(ctx, batch); err != nil {
+			logger.Error("Failed to store import", "error", err)
+			return err
+		}
+		c.snapshot.Store(plan.result)
+		return nil
+	}
+}
+
+// readRows calls each for every product in r, with the line it starts on or
+// the error parsing it. A row error is reported through each and reading goes
+// on; an error that leaves the rest of the file unreadable is returned as an
+// ErrImportRow. Reading stops at the first error each returns.
+func readRows(r io.Reader, format Format, each func(line int, product Product, err error) error) error {
+	switch format {
+	case CSV:
+		return readCSV(r, each)
+	case JSON:
+		return readJSON(r, each)
+	}
+	_, err := ParseFormat(string(format))
+	return err
+}
+
+func readCSV(r io.Reader, each func(line int, product Product, err error) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return internal.NewImportRowError(1, err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(columns, name) {
+			return internal.NewImportRowError(1, fmt.Errorf("unknown column %q", name))
+		}
+		if _, ok := index[name]; ok {
+			return internal.NewImportRowError(1, fmt.Errorf("column %q appears twice", name))
+		}
+		index[name] = i
+	}
+	for _, name := range []string{"sku", "name", "price"} {
+		if _, ok := index[name]; !ok {
+			return internal.NewImportRowError(1, fmt.Errorf("missing column %q", name))
+		}
+	}
+	width := len(header)
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return internal.NewImportRowError(parseErr.StartLine, parseErr.Err)
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != width {
+			err = fmt.Errorf("has %d fields, the header has %d", len(record), width)
+		}
+		var product Product
+		if err == nil {
+			product, err = parseProduct(func(name string) string {
+				if i, ok := index[name]; ok {
+					return strings.TrimSpace(record[i])
+				}
+				return ""
+			})
+		}
+		if err := each(line, product, err); err != nil {
+			return err
+		}
+	}
+}
+
+// productJSON is a product as it appears in a JSON catalog file
+type productJSON struct {
+	SKU        string            `json:"sku"`
+	Name       string            `json:"name"`
+	Price      *decimal.Decimal  `json:"price"`
+	Unit       string            `json:"unit,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Parent     string            `json:"parent,omitempty"`
+	Barcodes   []string          `json:"barcodes,omitempty"`
+	Aliases    []string          `json:"aliases,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Category   string            `json:"category,omitempty"`
+	Brand      string            `json:"brand,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Prices     []PricePeriod     `json:"prices,omitempty"`
+}
+
+func readJSON(r io.Reader, each func(line int, product Product, err error) error) error {
+	counter := &lineCounter{r: r}
+	decoder := json.NewDecoder(counter)
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return internal.NewImportRowError(counter.lineAt(decoder.InputOffset()), errors.New("catalog file must hold a JSON array"))
+	}
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return internal.NewImportRowError(counter.lineAt(decoder.InputOffset()), err)
+		}
+		line := counter.lineAt(decoder.InputOffset() - int64(len(raw)))
+
+		var fields productJSON
+		strict := json.NewDecoder(bytes.NewReader(raw))
+		strict.DisallowUnknownFields()
+		var product Product
+		err := strict.Decode(&fields)
+		if err == nil {
+			product, err = parseProduct(func(name string) string {
+				switch name {
+				case "sku":
+					return fields.SKU
+				case "name":
+					return fields.Name
+				case "price":
+					if fields.Price == nil {
+						return ""
+					}
+					return fields.Price.String()
+				case "unit":
+					return fields.Unit
+				case "status":
+					return fields.Status
+				case "parent":
+					return fields.Parent
+				case "category":
+					return fields.Category
+				case "brand":
+					return fields.Brand
+				}
+				return ""
+			})
+			product.Barcodes = fields.Barcodes
+			product.Aliases = fields.Aliases
+			product.Attributes = fields.Attributes
+			product.Tags = fields.Tags
+			product.Prices = fields.Prices
+		}
+		if err := each(line, product, err); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return internal.NewImportRowError(counter.lineAt(decoder.InputOffset()), err)
+	}
+	return nil
+}
+
+// parseProduct builds a product from the text of its fields
+func parseProduct(field func(name string) string) (Product, error) {
+	product := Product{
+		SKU:      field("sku"),
+		Name:     field("name"),
+		Parent:   field("parent"),
+		Category: field("category"),
+		Brand:    field("brand"),
+		Barcodes: parseList(field("barcodes")),
+		Aliases:  parseList(field("aliases")),
+		Tags:     parseList(field("tags")),
+	}
+	var errs []error
+	if text := field("price"); text == "" {
+		errs = append(errs, errors.New("price is required"))
+	} else if price, err := decimal.NewFromString(text); err != nil {
+		errs = append(errs, fmt.Errorf("invalid price %q", text))
+	} else {
+		product.Price = price
+	}
+	var err error
+	if text := field("unit"); text == string(unitEach) {
+		product.Unit = unitEach
+	} else if product.Unit, err = ParseUnit(text); err != nil {
+		errs = append(errs, err)
+	}
+	if status := field("status"); status != "" {
+		if product.Status, err = ParseStatus(status); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if product.Attributes, err = parseAttributes(field("attributes")); err != nil {
+		errs = append(errs, err)
+	}
+	if product.Prices, err = parsePrices(field("prices")); err != nil {
+		errs = append(errs, err)
+	}
+	return product, errors.Join(errs...)
+}
+
+// parseList reads values separated by semicolons, such as tags
+func parseList(text string) []string {
+	if text == "" {
+		return nil
+	}
+	values := strings.Split(text, ";")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// parseAttributes reads attributes written as key=value pairs separated by
+// semicolons
+func parseAttributes(text string) (map[string]string, error) {
+	if text == "" {
+		return nil, nil
+	}
+	attributes := make(map[string]string)
+	for _, pair := range strings.Split(text, ";") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid attribute %q, expected key=value", pair)
+		}
+		attributes[key] = strings.TrimSpace(value)
+	}
+	return attributes, nil
+}
+
+// formatAttributes writes attributes as key=value pairs separated by
+// semicolons, in key order
+func formatAttributes(attributes map[string]string) string {
+	pairs := make([]string, 0, len(attributes))
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		pairs = append(pairs, key+"="+attributes[key])
+	}
+	return strings.Join(pairs, ";")
+}
+
+// lineCounter tracks the lines of what is read through it, so a position in
+// the stream can be turned into a line number
+type lineCounter struct {
+	r    io.Reader
+	read int64
+	// newlines holds the offsets of the newlines read but not yet passed by
+	// lineAt
+	newlines []int64
+	line     int
+}
+
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == '\n' {
+			l.newlines = append(l.newlines, l.read+int64(i))
+		}
+	}
+	l.read += int64(n)
+	return n, err
+}
+
+// lineAt returns the line of the byte at offset. Offsets must not decrease
+// from one call to the next.
+func (l *lineCounter) lineAt(offset int64) int {
+	for len(l.newlines) > 0 && l.newlines[0] < offset {
+		l.newlines = l.newlines[1:]
+		l.line++
+	}
+	return l.line + 1
+}
+
+// Export writes every product in the catalog to w, parents before their
+// variants, in a form Import reads back
+func (c *Catalog) Export(ctx context.Context, w io.Writer, format Format) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	snapshot := c.Snapshot()
+	skus := slices.SortedFunc(maps.Keys(snapshot.products), func(a, b string) int {
+		// Products without a parent sort first
+		aVariant, bVariant := snapshot.products[a].Parent != "", snapshot.products[b].Parent != ""
+		if aVariant != bVariant {
+			if aVariant {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a, b)
+	})
+	writer := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case CSV:
+		err = writeCSV(writer, snapshot, skus)
+	case JSON:
+		err = writeJSON(writer, snapshot, skus)
+	default:
+		_, err = ParseFormat(string(format))
+	}
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func writeCSV(w io.Writer, snapshot *Snapshot, skus []string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, sku := range skus {
+		product := snapshot.products[sku]
+		err := writer.Write([]string{
+			product.SKU,
+			product.Name,
+			formatPrice(product.Price),
+			string(product.Unit),
+			string(product.Status),
+			product.Parent,
+			strings.Join(product.Barcodes, ";"),
+			strings.Join(product.Aliases, ";"),
+			formatAttributes(product.Attributes),
+			product.Category,
+			product.Brand,
+			strings.Join(product.Tags, ";"),
+			formatPrices(product.Prices),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSON(w io.Writer, snapshot *Snapshot, skus []string) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, sku := range skus {
+		product := snapshot.products[sku]
+		data, err := json.Marshal(productJSON{
+			SKU:        product.SKU,
+			Name:       product.Name,
+			Price:      &product.Price,
+			Unit:       string(product.Unit),
+			Status:     string(product.Status),
+			Parent:     product.Parent,
+			Barcodes:   product.Barcodes,
+			Aliases:    product.Aliases,
+			Attributes: product.Attributes,
+			Category:   product.Category,
+			Brand:      product.Brand,
+			Tags:       product.Tags,
+			Prices:     product.Prices,
+		})
+		if err != nil {
+			return err
+		}
+		separator := ",\n  "
+		if i == 0 {
+			separator = "\n  "
+		}
+		if _, err := io.WriteString(w, separator); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
+
+// formatPrice writes a price with at least two decimal places, as
+// spreadsheets usually show them
+func formatPrice(price decimal.Decimal) string {
+	if price.Exponent() < -2 {
+		return price.String()
+	}
+	return price.StringFixed(2)
+}
@@ -0,0 +1,237 @@
+package catalog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport_CSV(t *testing.T) {
+	c := catalog.NewCatalog()
+	addVariants(t, c)
+
+	var out bytes.Buffer
+	assert.NoError(t, c.Export(context.Background(), &out, catalog.CSV))
+	assert.Equal(t, `sku,name,price,unit,status,parent,barcodes,aliases,attributes,category,brand,tags,prices
+atv,Apple TV,109.50,,active,,,,,home-entertainment,Apple,,
+ipd,Super iPad,549.99,,active,,,,,computers/tablets,Apple,,
+mbp,MacBook Pro,1399.99,,active,,,,,computers/laptops,Apple,,
+vga,VGA adapter,30.00,,active,,,,,accessories/adapters,,,
+ipd-128-grey,Super iPad 128GB Grey,549.99,,active,ipd,,,colour=grey;storage=128GB,,,,
+ipd-256-blue,Super iPad 256GB Blue,699.99,,active,ipd,,,colour=blue;storage=256GB,,,,
+`, out.String())
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	for _, format := range []catalog.Format{catalog.CSV, catalog.JSON} {
+		t.Run(string(format), func(t *testing.T) {
+			ctx := context.Background()
+			c := catalog.NewCatalog()
+			addVariants(t, c)
+			assert.NoError(t, c.AddProduct(ctx, catalog.Product{SKU: "apl", Name: "Apples", Price: decimal.RequireFromString("4.40"), Unit: catalog.Kilogram, Status: catalog.Draft}))
+
+			var out bytes.Buffer
+			assert.NoError(t, c.Export(ctx, &out, format))
+
+			// Importing an export into the same catalog changes nothing
+			plan, err := c.PlanImport(ctx, bytes.NewReader(out.Bytes()), catalog.ImportOptions{Format: format, DeleteMissing: true})
+			assert.NoError(t, err)
+			assert.True(t, plan.Empty(), plan.String())
+
+			// and rebuilds it in an empty one
+			empty, err := catalog.OpenCatalog(ctx, catalog.NewMemoryStore())
+			assert.NoError(t, err)
+			plan, err = empty.Import(ctx, bytes.NewReader(out.Bytes()), catalog.ImportOptions{Format: format})
+			assert.NoError(t, err)
+			assert.Len(t, plan.Adds, 7)
+			var again bytes.Buffer
+			assert.NoError(t, empty.Export(ctx, &again, format))
+			assert.Equal(t, out.String(), again.String())
+		})
+	}
+}
+
+func TestPlanImport_DryRun(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	file := `sku,name,price
+ipd,Super iPad,499.99
+atv,Apple TV,109.50
+hdmi,HDMI cable,19.99
+`
+	plan, err := c.PlanImport(ctx, strings.NewReader(file), catalog.ImportOptions{Format: catalog.CSV, DeleteMissing: true})
+	assert.NoError(t, err)
+	assert.Equal(t, `+ hdmi: "HDMI cable" at 19.99 (line 4)
+~ ipd: price 549.99 -> 499.99 (line 2)
+- mbp: "MacBook Pro"
+- vga: "VGA adapter"
+1 to add, 1 to update, 2 to delete
+`, plan.String())
+
+	// Nothing changes until the plan is applied
+	assert.Len(t, c.ProductsBySKU(), 4)
+	assert.NoError(t, c.ApplyImport(ctx, plan))
+	assert.Len(t, c.ProductsBySKU(), 3)
+	product, err := c.GetProduct(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "499.99", product.Price.String())
+
+	// A plan cannot be applied once the catalog has moved on
+	var stale internal.ErrStaleImport
+	assert.True(t, errors.As(c.ApplyImport(ctx, plan), &stale))
+}
+
+func TestPlanImport_KeepsMissingByDefault(t *testing.T) {
+	c := catalog.NewCatalog()
+	plan, err := c.Import(context.Background(), strings.NewReader("sku,price,name\nvga,25,VGA adapter\n"), catalog.ImportOptions{Format: catalog.CSV})
+	assert.NoError(t, err)
+	assert.Len(t, plan.Updates, 1)
+	assert.Empty(t, plan.Deletes)
+	assert.Len(t, c.ProductsBySKU(), 4)
+}
+
+func TestPlanImport_RowErrors(t *testing.T) {
+	c := catalog.NewCatalog()
+	file := `sku,name,price,status
+hdmi,HDMI cable,19.99,
+dvi,,abc,
+atv,Apple TV,-5,retired
+hdmi,HDMI cable,18.99,
+vga,VGA adapter
+`
+	_, err := c.Import(context.Background(), strings.NewReader(file), catalog.ImportOptions{Format: catalog.CSV})
+
+	var validation internal.ErrValidation
+	assert.True(t, errors.As(err, &validation))
+	var lines []int
+	for _, err := range validation.Errors {
+		var row internal.ErrImportRow
+		assert.True(t, errors.As(err, &row))
+		lines = append(lines, row.Line)
+	}
+	assert.Equal(t, []int{3, 4, 5, 6}, lines)
+	assert.Equal(t, "line 3: invalid price \"abc\"", validation.Errors[0].Error())
+	assert.Equal(t, "line 4: unknown product status \"retired\"", validation.Errors[1].Error())
+	assert.Equal(t, "line 5: product already exists: hdmi", validation.Errors[2].Error())
+	assert.Equal(t, "line 6: has 2 fields, the header has 4", validation.Errors[3].Error())
+
+	// Nothing was imported
+	_, err = c.GetProduct(context.Background(), "hdmi")
+	assert.Error(t, err)
+}
+
+func TestPlanImport_ValidatorsRunPerRow(t *testing.T) {
+	c := catalog.NewCatalog()
+	file := `[
+  {"sku": "hdmi", "name": "HDMI cable", "price": "19.999"},
+  {
+    "sku": "dvi",
+    "name": "DVI cable",
+    "price": -1
+  },
+  {"sku": "ipd-64", "name": "Super iPad 64GB", "price": "449.99", "parent": "tv"},
+  {"sku": "usb", "name": "USB cable", "price": "9.99", "colour": "red"}
+]`
+	_, err := c.PlanImport(context.Background(), strings.NewReader(file), catalog.ImportOptions{Format: catalog.JSON})
+
+	var validation internal.ErrValidation
+	assert.True(t, errors.As(err, &validation))
+	assert.Len(t, validation.Errors, 4)
+	assert.Equal(t, "line 2: invalid product hdmi: price 19.999 has more than 2 decimal places", validation.Errors[0].Error())
+	assert.Equal(t, "line 3: negative price not allowed for product dvi: -1", validation.Errors[1].Error())
+	assert.Equal(t, "line 8: invalid product ipd-64: parent tv not found", validation.Errors[2].Error())
+	assert.Equal(t, `line 9: json: unknown field "colour"`, validation.Errors[3].Error())
+	var negative internal.ErrNegativePrice
+	assert.True(t, errors.As(err, &negative))
+}
+
+func TestImport_PriceOnlyKeepsOtherFields(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	addVariants(t, c)
+	assert.NoError(t, c.AddProduct(ctx, catalog.Product{SKU: "cbl", Name: "Cable", Price: decimal.RequireFromString("2.50"), Unit: catalog.Metre, Category: "accessories/cables", Tags: []string{"bulk"}}))
+
+	file := "sku,name,price\nipd-256-blue,Super iPad 256GB Blue,649.99\ncbl,Cable,2.00\n"
+	plan, err := c.Import(ctx, strings.NewReader(file), catalog.ImportOptions{Format: catalog.CSV})
+	assert.NoError(t, err)
+	assert.Equal(t, "~ ipd-256-blue: price 699.99 -> 649.99 (line 2)\n"+
+		"~ cbl: price 2.5 -> 2 (line 3)\n"+
+		"0 to add, 2 to update, 0 to delete\n", plan.String())
+
+	variant, err := c.GetProduct(ctx, "ipd-256-blue")
+	assert.NoError(t, err)
+	assert.Equal(t, "ipd", variant.Parent)
+	assert.Equal(t, map[string]string{"storage": "256GB", "colour": "blue"}, variant.Attributes)
+	assert.Equal(t, "649.99", variant.Price.String())
+	cable, err := c.GetProduct(ctx, "cbl")
+	assert.NoError(t, err)
+	assert.Equal(t, catalog.Metre, cable.Unit)
+	assert.Equal(t, "accessories/cables", cable.Category)
+	assert.Equal(t, []string{"bulk"}, cable.Tags)
+
+	// A unit has to be named to sell a measured product whole again
+	_, err = c.Import(ctx, strings.NewReader("sku,name,price,unit\ncbl,Cable,2.00,each\n"), catalog.ImportOptions{Format: catalog.CSV})
+	assert.NoError(t, err)
+	cable, err = c.GetProduct(ctx, "cbl")
+	assert.NoError(t, err)
+	assert.Equal(t, catalog.Each, cable.Unit)
+}
+
+func TestPlanImport_DeleteParentKeepsVariant(t *testing.T) {
+	c := catalog.NewCatalog()
+	addVariants(t, c)
+	var out bytes.Buffer
+	assert.NoError(t, c.Export(context.Background(), &out, catalog.CSV))
+	file := strings.Replace(out.String(), "ipd,Super iPad,549.99,,active,,,,,computers/tablets,Apple,,\n", "", 1)
+
+	_, err := c.PlanImport(context.Background(), strings.NewReader(file), catalog.ImportOptions{Format: catalog.CSV, DeleteMissing: true})
+	assert.EqualError(t, err, "2 validation errors: "+
+		"line 5: invalid product ipd-128-grey: parent ipd is not in the import; "+
+		"line 6: invalid product ipd-256-blue: parent ipd is not in the import")
+}
+
+func TestImport_BadFile(t *testing.T) {
+	c := catalog.NewCatalog()
+	_, err := c.PlanImport(context.Background(), strings.NewReader("sku,name,cost\n"), catalog.ImportOptions{Format: catalog.CSV})
+	assert.EqualError(t, err, `line 1: unknown column "cost"`)
+	_, err = c.PlanImport(context.Background(), strings.NewReader(`{"sku": "ipd"}`), catalog.ImportOptions{Format: catalog.JSON})
+	assert.EqualError(t, err, "line 1: catalog file must hold a JSON array")
+	_, err = c.PlanImport(context.Background(), strings.NewReader(""), catalog.ImportOptions{Format: "xml"})
+	assert.EqualError(t, err, `unknown catalog file format "xml"`)
+}
+
+func TestImport_FileStoreAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+
+	_, err = c.Import(ctx, strings.NewReader("sku,name,price\nipd,Super iPad,499.99\nhdmi,HDMI cable,19.99\n"), catalog.ImportOptions{Format: catalog.CSV})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	// A batch cut off before its commit record is ignored
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	torn := string(data) + `{"op":"begin"}` + "\n" + `{"op":"delete","sku":"ipd"}` + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(torn), 0o644))
+
+	c, err = catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	assert.Len(t, c.ProductsBySKU(), 5)
+	product, err := c.GetProduct(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "499.99", product.Price.String())
+}
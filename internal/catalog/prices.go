@@ -0,0 +1,221 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// PricePeriod is a price that applies from From until To, such as a price
+// change scheduled for next Monday or a price that has since been replaced.
+// A zero From means since the product was first listed, and a zero To means
+// until further notice.
+type PricePeriod struct {
+	Price decimal.Decimal
+	From  time.Time
+	To    time.Time
+}
+
+// Covers reports whether the period applies at t. From is inclusive and To
+// exclusive.
+func (p PricePeriod) Covers(t time.Time) bool {
+	return !t.Before(p.From) && (p.To.IsZero() || t.Before(p.To))
+}
+
+// overrides reports whether p takes precedence over q where both apply: the
+// period that started later wins, then the one that ends sooner, and p wins a
+// tie
+func (p PricePeriod) overrides(q PricePeriod) bool {
+	switch {
+	case !p.From.Equal(q.From):
+		return p.From.After(q.From)
+	case p.To.IsZero():
+		return q.To.IsZero()
+	case q.To.IsZero():
+		return true
+	}
+	return !p.To.After(q.To)
+}
+
+// pricePeriodJSON is a PricePeriod as it is written to logs and catalog
+// files, leaving out open ends
+type pricePeriodJSON struct {
+	Price decimal.Decimal `json:"price"`
+	From  *time.Time      `json:"from,omitempty"`
+	To    *time.Time      `json:"to,omitempty"`
+}
+
+func (p PricePeriod) MarshalJSON() ([]byte, error) {
+	out := pricePeriodJSON{Price: p.Price}
+	if !p.From.IsZero() {
+		out.From = &p.From
+	}
+	if !p.To.IsZero() {
+		out.To = &p.To
+	}
+	return json.Marshal(out)
+}
+
+func (p *PricePeriod) UnmarshalJSON(data []byte) error {
+	var in pricePeriodJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*p = PricePeriod{Price: in.Price}
+	if in.From != nil {
+		p.From = *in.From
+	}
+	if in.To != nil {
+		p.To = *in.To
+	}
+	return nil
+}
+
+// PriceAt returns the price of the product at t: the price of the period in
+// Prices that applies then, or Price if none does. Where periods overlap the
+// one that started last applies, so a week-long promotion inside a longer
+// period wins for its week; of two identical periods the one listed later
+// applies.
+func (p Product) PriceAt(t time.Time) decimal.Decimal {
+	var current *PricePeriod
+	for i, period := range p.Prices {
+		if period.Covers(t) && (current == nil || period.overrides(*current)) {
+			current = &p.Prices[i]
+		}
+	}
+	if current == nil {
+		return p.Price
+	}
+	return current.Price
+}
+
+// validatePrices checks that every period of the product ends after it starts
+func validatePrices(product Product) []error {
+	var errs []error
+	for _, period := range product.Prices {
+		if !period.From.IsZero() && !period.To.IsZero() && !period.To.After(period.From) {
+			errs = append(errs, internal.NewInvalidProductError(product.SKU, fmt.Sprintf("price %s must end after it starts at %s", period.Price, period.From.Format(time.RFC3339))))
+		}
+	}
+	return errs
+}
+
+// withHistory carries the price history of current over to product, which is
+// replacing it, unless product brings its own. A change of Price is recorded
+// in the history as the old price ending at now, so lookups before now still
+// find it, and ends any open-ended period in force now, which would otherwise
+// go on overriding the new price. Periods with an end, such as a promotion
+// running this week, and periods starting later are kept.
+func withHistory(current, product Product, now time.Time) Product {
+	if product.Prices == nil {
+		product.Prices = slices.Clone(current.Prices)
+	}
+	if !current.Price.Equal(product.Price) {
+		prices := make([]PricePeriod, 0, len(product.Prices)+1)
+		for _, period := range product.Prices {
+			if period.To.IsZero() && period.Covers(now) {
+				if period.From.Equal(now) {
+					continue
+				}
+				period.To = now
+			}
+			prices = append(prices, period)
+		}
+		product.Prices = append(prices, PricePeriod{Price: current.Price, To: now})
+	}
+	return product
+}
+
+// PriceAt returns the price of the product listed under sku at t
+func (s *Snapshot) PriceAt(sku string, t time.Time) (decimal.Decimal, error) {
+	product, err := s.GetProduct(sku)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return product.PriceAt(t), nil
+}
+
+// PriceAt returns the price of the product with the given SKU at t, such as
+// what it cost when a past sale was made
+func (c *Catalog) PriceAt(ctx context.Context, sku string, t time.Time) (decimal.Decimal, error) {
+	if err := ctx.Err(); err != nil {
+		return decimal.Decimal{}, err
+	}
+	return c.Snapshot().PriceAt(sku, t)
+}
+
+// SchedulePrice adds a price period to the product with the given SKU, for
+// example to change its price from next Monday or run a price for one week.
+// An open-ended period already in place that started earlier is ended where
+// the new one starts, so the new price is not itself cut short.
+func (c *Catalog) SchedulePrice(ctx context.Context, sku string, period PricePeriod) error {
+	internal.GetLogger(ctx).Info("Scheduling price", "sku", sku, "period", period)
+	return c.update(ctx, sku, "SchedulePrice", func(product Product) Product {
+		prices := slices.Clone(product.Prices)
+		for i := range prices {
+			if prices[i].To.IsZero() && prices[i].From.Before(period.From) {
+				prices[i].To = period.From
+			}
+		}
+		product.Prices = append(prices, period)
+		return product
+	})
+}
+
+// formatPrices writes price periods as from..to=price entries separated by
+// semicolons, with times in RFC 3339 and open ends left empty
+func formatPrices(prices []PricePeriod) string {
+	entries := make([]string, len(prices))
+	for i, period := range prices {
+		entries[i] = formatTime(period.From) + ".." + formatTime(period.To) + "=" + formatPrice(period.Price)
+	}
+	return strings.Join(entries, ";")
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// parsePrices reads price periods written by formatPrices
+func parsePrices(text string) ([]PricePeriod, error) {
+	if text == "" {
+		return nil, nil
+	}
+	var prices []PricePeriod
+	for _, entry := range strings.Split(text, ";") {
+		span, price, ok := strings.Cut(entry, "=")
+		from, to, ranged := strings.Cut(span, "..")
+		if !ok || !ranged {
+			return nil, fmt.Errorf("invalid price period %q, expected from..to=price", entry)
+		}
+		var period PricePeriod
+		var err error
+		if period.Price, err = decimal.NewFromString(strings.TrimSpace(price)); err != nil {
+			return nil, fmt.Errorf("invalid price in price period %q", entry)
+		}
+		if period.From, err = parseTime(from); err != nil {
+			return nil, fmt.Errorf("invalid start of price period %q: %w", entry, err)
+		}
+		if period.To, err = parseTime(to); err != nil {
+			return nil, fmt.Errorf("invalid end of price period %q: %w", entry, err)
+		}
+		prices = append(prices, period)
+	}
+	return prices, nil
+}
+
+func parseTime(text string) (time.Time, error) {
+	if text = strings.TrimSpace(text); text == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, text)
+}
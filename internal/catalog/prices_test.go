@@ -0,0 +1,214 @@
+package catalog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func day(d int) time.Time {
+	return time.Date(2026, time.March, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestProduct_PriceAt(t *testing.T) {
+	product := catalog.Product{
+		SKU:   "atv",
+		Price: decimal.RequireFromString("109.50"),
+		Prices: []catalog.PricePeriod{
+			{Price: decimal.RequireFromString("99.50"), From: day(9)},
+			{Price: decimal.RequireFromString("89.50"), From: day(12), To: day(14)},
+			{Price: decimal.RequireFromString("119.50"), To: day(2)},
+		},
+	}
+	tests := []struct {
+		at       time.Time
+		expected string
+	}{
+		{day(1), "119.5"},
+		{day(2), "109.5"},
+		{day(8).Add(23 * time.Hour), "109.5"},
+		{day(9), "99.5"},
+		// A period inside a longer one wins for its duration
+		{day(12), "89.5"},
+		{day(14), "99.5"},
+		{day(30), "99.5"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, product.PriceAt(test.at).String(), test.at)
+	}
+}
+
+func TestUpdateProduct_RecordsPriceHistory(t *testing.T) {
+	ctx := context.Background()
+	now := day(3)
+	c := catalog.NewCatalog(catalog.WithClock(internal.ClockFunc(func() time.Time { return now })))
+
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: "Apple TV", Price: decimal.RequireFromString("99.50")}))
+	now = day(10)
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: "Apple TV", Price: decimal.RequireFromString("89.50")}))
+	// Changing something other than the price records nothing
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: "Apple TV 4K", Price: decimal.RequireFromString("89.50")}))
+
+	for at, expected := range map[time.Time]string{day(1): "109.5", day(3): "99.5", day(9): "99.5", day(10): "89.5"} {
+		price, err := c.PriceAt(ctx, "atv", at)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, price.String(), at)
+	}
+	product, err := c.GetProduct(ctx, "atv")
+	assert.NoError(t, err)
+	assert.Len(t, product.Prices, 2)
+
+	_, err = c.PriceAt(ctx, "tv", day(1))
+	var notFound internal.ErrProductNotFound
+	assert.True(t, errors.As(err, &notFound))
+}
+
+func TestSchedulePrice(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+
+	assert.NoError(t, c.SchedulePrice(ctx, "ipd", catalog.PricePeriod{Price: decimal.RequireFromString("499.99"), From: day(9)}))
+	// A later open-ended price ends the earlier one
+	assert.NoError(t, c.SchedulePrice(ctx, "ipd", catalog.PricePeriod{Price: decimal.RequireFromString("479.99"), From: day(16)}))
+
+	product, err := c.GetProduct(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, []catalog.PricePeriod{
+		{Price: decimal.RequireFromString("499.99"), From: day(9), To: day(16)},
+		{Price: decimal.RequireFromString("479.99"), From: day(16)},
+	}, product.Prices)
+	assert.Equal(t, "549.99", product.PriceAt(day(8)).String())
+	assert.Equal(t, "499.99", product.PriceAt(day(15)).String())
+	assert.Equal(t, "479.99", product.PriceAt(day(16)).String())
+	// Price itself is unchanged
+	assert.Equal(t, "549.99", product.Price.String())
+}
+
+func TestUpdateProduct_PriceChangeEndsScheduledPrice(t *testing.T) {
+	ctx := context.Background()
+	now := day(7)
+	c := catalog.NewCatalog(catalog.WithClock(internal.ClockFunc(func() time.Time { return now })))
+	assert.NoError(t, c.SchedulePrice(ctx, "atv", catalog.PricePeriod{Price: decimal.RequireFromString("99.00"), From: day(9)}))
+	assert.NoError(t, c.SchedulePrice(ctx, "ipd", catalog.PricePeriod{Price: decimal.RequireFromString("499.99"), From: day(12), To: day(20)}))
+
+	// On Saturday the base prices change while both periods are running
+	now = day(14)
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: "Apple TV", Price: decimal.RequireFromString("89.00")}))
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "ipd", Name: "Super iPad", Price: decimal.RequireFromString("599.99")}))
+
+	tests := []struct {
+		sku      string
+		at       time.Time
+		expected string
+	}{
+		{"atv", day(8), "109.5"},
+		{"atv", day(10), "99"},
+		// The new price replaces the open-ended one from now on
+		{"atv", now.Add(time.Hour), "89"},
+		{"atv", day(30), "89"},
+		// A promotion with an end still runs its course
+		{"ipd", day(11), "549.99"},
+		{"ipd", now.Add(time.Hour), "499.99"},
+		{"ipd", day(20), "599.99"},
+	}
+	for _, test := range tests {
+		price, err := c.PriceAt(ctx, test.sku, test.at)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, price.String(), test.sku, test.at)
+	}
+
+	// A period starting at the very moment of the change is dropped
+	assert.NoError(t, c.SchedulePrice(ctx, "mbp", catalog.PricePeriod{Price: decimal.RequireFromString("1299.99"), From: now}))
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "mbp", Name: "MacBook Pro", Price: decimal.RequireFromString("1199.99")}))
+	price, err := c.PriceAt(ctx, "mbp", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "1199.99", price.String())
+}
+
+func TestSchedulePrice_Validation(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+
+	err := c.SchedulePrice(ctx, "ipd", catalog.PricePeriod{Price: decimal.RequireFromString("-1"), From: day(9), To: day(9)})
+	var validation internal.ErrValidation
+	assert.True(t, errors.As(err, &validation))
+	assert.Len(t, validation.Errors, 2)
+	var negative internal.ErrNegativePrice
+	assert.True(t, errors.As(err, &negative))
+	assert.Equal(t, "invalid product ipd: price -1 must end after it starts at 2026-03-09T00:00:00Z", validation.Errors[0].Error())
+
+	err = c.SchedulePrice(ctx, "ipd", catalog.PricePeriod{Price: decimal.RequireFromString("499.999"), From: day(9)})
+	assert.EqualError(t, err, "invalid product ipd: price 499.999 has more than 2 decimal places")
+}
+
+func TestPriceHistory_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+	assert.NoError(t, c.SchedulePrice(ctx, "atv", catalog.PricePeriod{Price: decimal.RequireFromString("99.50"), From: day(9), To: day(16)}))
+	assert.NoError(t, store.Close())
+
+	c, err = catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	price, err := c.PriceAt(ctx, "atv", day(10))
+	assert.NoError(t, err)
+	assert.Equal(t, "99.5", price.String())
+	price, err = c.PriceAt(ctx, "atv", day(16))
+	assert.NoError(t, err)
+	assert.Equal(t, "109.5", price.String())
+}
+
+func TestImport_PriceHistory(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	file := `sku,name,price,prices
+atv,Apple TV,109.50,2026-03-09T00:00:00Z..2026-03-16T00:00:00Z=99.50;2026-03-16T00:00:00Z..=104.50
+vga,VGA adapter,30.00,..2026-03-01T00:00:00Z
+`
+	_, err := c.PlanImport(ctx, strings.NewReader(file), catalog.ImportOptions{Format: catalog.CSV})
+	assert.EqualError(t, err, `line 3: invalid price period "..2026-03-01T00:00:00Z", expected from..to=price`)
+
+	file = strings.Replace(file, "..2026-03-01T00:00:00Z\n", "..2026-03-01T00:00:00Z=35\n", 1)
+	plan, err := c.Import(ctx, strings.NewReader(file), catalog.ImportOptions{Format: catalog.CSV})
+	assert.NoError(t, err)
+	assert.Equal(t, `~ atv: prices "" -> "2026-03-09T00:00:00Z..2026-03-16T00:00:00Z=99.50;2026-03-16T00:00:00Z..=104.50" (line 2)
+~ vga: prices "" -> "..2026-03-01T00:00:00Z=35.00" (line 3)
+0 to add, 2 to update, 0 to delete
+`, plan.String())
+	price, err := c.PriceAt(ctx, "atv", day(20))
+	assert.NoError(t, err)
+	assert.Equal(t, "104.5", price.String())
+
+	// Price periods survive a JSON export and import
+	var out bytes.Buffer
+	assert.NoError(t, c.Export(ctx, &out, catalog.JSON))
+	assert.Contains(t, out.String(), `"prices":[{"price":"99.5","from":"2026-03-09T00:00:00Z","to":"2026-03-16T00:00:00Z"},{"price":"104.5","from":"2026-03-16T00:00:00Z"}]`)
+	plan, err = c.PlanImport(ctx, &out, catalog.ImportOptions{Format: catalog.JSON, DeleteMissing: true})
+	assert.NoError(t, err)
+	assert.True(t, plan.Empty(), plan.String())
+}
+
+func TestSchedulePrice_KeepsConcurrentUpdate(t *testing.T) {
+	later := time.Now().AddDate(1, 0, 0)
+	c := raceUpdate(t, func(c *catalog.Catalog) error {
+		return c.SchedulePrice(context.Background(), "vga", catalog.PricePeriod{Price: decimal.RequireFromString("25.00"), From: later})
+	})
+	for at, expected := range map[time.Time]string{time.Now(): "99", later: "25"} {
+		price, err := c.PriceAt(context.Background(), "vga", at)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, price.String(), at)
+	}
+}
@@ -0,0 +1,175 @@
+package catalog
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+// Snapshot is an immutable view of the catalog at one moment. It is never
+// changed once taken, so it can be read from any number of goroutines without
+// locking, and a checkout reading one sees consistent prices while the catalog
+// is being updated.
+type Snapshot struct {
+	products map[string]Product
+	// variants lists the SKUs of each parent's variants, in order
+	variants map[string][]string
+	// codes maps each barcode and alias, in codeKey form, to the SKU of its
+	// product
+	codes    map[string]string
+	currency money.Currency
+}
+
+// GetProduct returns the product listed under sku
+func (s *Snapshot) GetProduct(sku string) (Product, error) {
+	if sku == "" {
+		return Product{}, internal.NewEmptySKUError("GetProduct")
+	}
+	product, ok := s.products[sku]
+	if !ok {
+		return Product{}, internal.NewProductNotFoundError(sku)
+	}
+	return product.clone(), nil
+}
+
+// Variants returns the variants of the product with the given SKU, ordered by
+// SKU
+func (s *Snapshot) Variants(sku string) ([]Product, error) {
+	if _, err := s.GetProduct(sku); err != nil {
+		return nil, err
+	}
+	variants := make([]Product, 0, len(s.variants[sku]))
+	for _, variant := range s.variants[sku] {
+		variants = append(variants, s.products[variant].clone())
+	}
+	return variants, nil
+}
+
+// GetProducts returns the product listed under sku as a one-element list.
+//
+// Deprecated: a SKU lists exactly one product; use GetProduct.
+func (s *Snapshot) GetProducts(sku string) ([]Product, error) {
+	product, err := s.GetProduct(sku)
+	if err != nil {
+		return nil, err
+	}
+	return []Product{product}, nil
+}
+
+// ProductsBySKU returns a copy of every product in the snapshot, keyed by SKU
+func (s *Snapshot) ProductsBySKU() map[string]Product {
+	products := make(map[string]Product, len(s.products))
+	for sku, product := range s.products {
+		products[sku] = product.clone()
+	}
+	return products
+}
+
+// Products returns a copy of every product in the snapshot, keyed by SKU, each
+// as a one-element list.
+//
+// Deprecated: a SKU lists exactly one product; use ProductsBySKU.
+func (s *Snapshot) Products() map[string][]Product {
+	products := make(map[string][]Product, len(s.products))
+	for sku, product := range s.products {
+		products[sku] = []Product{product.clone()}
+	}
+	return products
+}
+
+// Len returns the number of products in the snapshot
+func (s *Snapshot) Len() int {
+	return len(s.products)
+}
+
+// Currency returns the currency all prices in the snapshot are expressed in
+func (s *Snapshot) Currency() money.Currency {
+	return s.currency
+}
+
+// validateParent checks that the parent product names, if any, can take
+// variants in this snapshot. Variants only go one level deep.
+func (s *Snapshot) validateParent(product Product) error {
+	if product.Parent == "" {
+		return nil
+	}
+	if product.Parent == product.SKU {
+		return internal.NewInvalidProductError(product.SKU, "a product cannot be a variant of itself")
+	}
+	parent, ok := s.products[product.Parent]
+	if !ok {
+		return internal.NewInvalidProductError(product.SKU, "parent "+product.Parent+" not found")
+	}
+	if parent.Parent != "" {
+		return internal.NewInvalidProductError(product.SKU, "parent "+product.Parent+" is itself a variant")
+	}
+	if len(s.variants[product.SKU]) > 0 {
+		return internal.NewInvalidProductError(product.SKU, "a product with variants cannot be a variant")
+	}
+	return nil
+}
+
+// with returns a new snapshot that lists product in place of any product with
+// the same SKU, leaving s unchanged
+func (s *Snapshot) with(product Product) *Snapshot {
+	next := s.clone()
+	next.put(product)
+	return next
+}
+
+// without returns a new snapshot that no longer lists sku, leaving s unchanged
+func (s *Snapshot) without(sku string) *Snapshot {
+	next := s.clone()
+	next.remove(sku)
+	return next
+}
+
+// clone returns a copy of s for a writer to change with put and remove before
+// it is published. The copy shares the variant lists of s, which put and
+// remove replace rather than change.
+func (s *Snapshot) clone() *Snapshot {
+	return &Snapshot{products: maps.Clone(s.products), variants: maps.Clone(s.variants), codes: maps.Clone(s.codes), currency: s.currency}
+}
+
+// put lists product in place of any product with the same SKU. It changes s,
+// so it is only for snapshots that have not been published yet.
+func (s *Snapshot) put(product Product) {
+	s.remove(product.SKU)
+	s.products[product.SKU] = product.clone()
+	if product.Parent != "" {
+		variants := s.variants[product.Parent]
+		i, _ := slices.BinarySearch(variants, product.SKU)
+		s.variants[product.Parent] = slices.Insert(slices.Clone(variants), i, product.SKU)
+	}
+	s.index(product)
+}
+
+// index maps the product's barcodes and aliases to its SKU
+func (s *Snapshot) index(product Product) {
+	for _, code := range product.codes() {
+		s.codes[codeKey(code)] = product.SKU
+	}
+}
+
+// remove stops listing sku. It changes s, so it is only for snapshots that
+// have not been published yet.
+func (s *Snapshot) remove(sku string) {
+	for _, code := range s.products[sku].codes() {
+		if s.codes[codeKey(code)] == sku {
+			delete(s.codes, codeKey(code))
+		}
+	}
+	if product, ok := s.products[sku]; ok && product.Parent != "" {
+		variants := slices.DeleteFunc(slices.Clone(s.variants[product.Parent]), func(variant string) bool {
+			return variant == sku
+		})
+		if len(variants) == 0 {
+			delete(s.variants, product.Parent)
+		} else {
+			s.variants[product.Parent] = variants
+		}
+	}
+	delete(s.products, sku)
+}
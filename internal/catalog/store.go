@@ -0,0 +1,148 @@
+package catalog
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// Store keeps the products of a catalog. The catalog reads the store once
+// when it is opened and writes every change through to it, so a durable store
+// keeps the catalog across restarts.
+type Store interface {
+	// Load returns every product in the store, in the order they were added
+	Load(ctx context.Context) ([]Product, error)
+	// Add records a new product
+	Add(ctx context.Context, product Product) error
+	// Update records a new version of the product with the same SKU
+	Update(ctx context.Context, product Product) error
+	// Delete records that the product with the given SKU was removed
+	Delete(ctx context.Context, sku string) error
+	// Commit records every change in batch, or none of them
+	Commit(ctx context.Context, batch Batch) error
+}
+
+// Batch is a set of changes a store records all together or not at all.
+// Adds are recorded first, then updates, then deletes.
+type Batch struct {
+	Adds    []Product
+	Updates []Product
+	Deletes []string
+}
+
+// Empty reports whether the batch holds no changes
+func (b Batch) Empty() bool {
+	return len(b.Adds) == 0 && len(b.Updates) == 0 && len(b.Deletes) == 0
+}
+
+// SeedProducts returns the products the store opened with before catalogs
+// had a store of their own. Pass them to Seed to carry them over into a new
+// store.
+func SeedProducts() []Product {
+	return []Product{
+		{SKU: "ipd", Name: "Super iPad", Price: decimal.NewFromFloat(549.99), Category: "computers/tablets", Brand: "Apple"},
+		{SKU: "mbp", Name: "MacBook Pro", Price: decimal.NewFromFloat(1399.99), Category: "computers/laptops", Brand: "Apple"},
+		{SKU: "atv", Name: "Apple TV", Price: decimal.NewFromFloat(109.50), Category: "home-entertainment", Brand: "Apple"},
+		{SKU: "vga", Name: "VGA adapter", Price: decimal.NewFromFloat(30.00), Category: "accessories/adapters"},
+	}
+}
+
+// Seed adds products to store if the store is empty. A store that already
+// holds products is left alone, so Seed is safe to run on every start-up.
+func Seed(ctx context.Context, store Store, products []Product) error {
+	existing, err := store.Load(ctx)
+	if err != nil || len(existing) > 0 {
+		return err
+	}
+	for _, product := range products {
+		if err := store.Add(ctx, product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MemoryStore is a Store that keeps products in memory only. It is safe for
+// concurrent use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	products []Product
+}
+
+// NewMemoryStore returns a memory store holding the given products
+func NewMemoryStore(products ...Product) *MemoryStore {
+	return &MemoryStore{products: slices.Clone(products)}
+}
+
+func (s *MemoryStore) Load(ctx context.Context) ([]Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.products), nil
+}
+
+func (s *MemoryStore) Add(ctx context.Context, product Product) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.products = append(s.products, product.clone())
+	return nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, product Product) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.products {
+		if s.products[i].SKU == product.SKU {
+			s.products[i] = product.clone()
+			return nil
+		}
+	}
+	return internal.NewProductNotFoundError(product.SKU)
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, sku string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.products = slices.DeleteFunc(s.products, func(product Product) bool {
+		return product.SKU == sku
+	})
+	return nil
+}
+
+func (s *MemoryStore) Commit(ctx context.Context, batch Batch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	products := slices.Clone(s.products)
+	for _, product := range batch.Adds {
+		products = append(products, product.clone())
+	}
+	for _, product := range batch.Updates {
+		i := slices.IndexFunc(products, func(p Product) bool { return p.SKU == product.SKU })
+		if i < 0 {
+			return internal.NewProductNotFoundError(product.SKU)
+		}
+		products[i] = product.clone()
+	}
+	for _, sku := range batch.Deletes {
+		products = slices.DeleteFunc(products, func(p Product) bool { return p.SKU == sku })
+	}
+	s.products = products
+	return nil
+}
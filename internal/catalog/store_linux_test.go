@@ -0,0 +1,43 @@
+package catalog_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore_PartialWriteIsCutBack(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	// A file size limit just past the log lets only part of the next record
+	// be written, as a full disk would
+	var limit syscall.Rlimit
+	assert.NoError(t, syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit))
+	short := limit
+	short.Cur = uint64(info.Size()) + 20
+	assert.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &short))
+	err = store.Update(ctx, catalog.Product{SKU: "atv", Name: "Apple TV 4K", Price: decimal.NewFromInt(109)})
+	assert.NoError(t, syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit))
+	assert.Error(t, err)
+
+	assert.NoError(t, store.Update(ctx, catalog.Product{SKU: "atv", Name: "Apple TV HD", Price: decimal.NewFromInt(99)}))
+	assert.NoError(t, store.Close())
+
+	c, err := catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	product, err := c.GetProduct(ctx, "atv")
+	assert.NoError(t, err)
+	assert.Equal(t, "Apple TV HD", product.Name)
+}
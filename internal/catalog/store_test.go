@@ -0,0 +1,230 @@
+package catalog_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func openFileStore(t *testing.T, path string) *catalog.FileStore {
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSeed_OnlyFillsEmptyStore(t *testing.T) {
+	ctx := context.Background()
+	store := catalog.NewMemoryStore()
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+
+	products, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, products, 4)
+}
+
+func TestOpenCatalog_MemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := catalog.NewMemoryStore(catalog.SeedProducts()...)
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+
+	err = c.AddProduct(ctx, catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.NewFromFloat(19.99)})
+	assert.NoError(t, err)
+
+	products, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, products, 5)
+	assert.Equal(t, "hdmi", products[4].SKU)
+}
+
+func TestFileStore_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+	err = c.AddProduct(ctx, catalog.Product{SKU: "apl", Name: "Apples", Price: decimal.RequireFromString("4.40"), Unit: catalog.Kilogram})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	c, err = catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	assert.Len(t, c.ProductsBySKU(), 5)
+	product, err := c.GetProduct(ctx, "apl")
+	assert.NoError(t, err)
+	assert.Equal(t, "4.4", product.Price.String())
+	assert.Equal(t, catalog.Kilogram, product.Unit)
+	product, err = c.GetProduct(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "549.99", product.Price.String())
+}
+
+func TestFileStore_DropsTornRecord(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	log := `{"op":"add","sku":"ipd","name":"Super iPad","price":"549.99"}` + "\n" + `{"op":"add","sku":"mb`
+	assert.NoError(t, os.WriteFile(path, []byte(log), 0o644))
+
+	store := openFileStore(t, path)
+	assert.NoError(t, store.Add(ctx, catalog.Product{SKU: "vga", Name: "VGA adapter", Price: decimal.NewFromInt(30)}))
+
+	products, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, products, 2)
+	assert.Equal(t, "ipd", products[0].SKU)
+	assert.Equal(t, "vga", products[1].SKU)
+}
+
+func TestFileStore_CorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	log := `{"op":"add","sku":"ipd","name":"Super iPad","price":"549.99"}` + "\nnot json\n"
+	assert.NoError(t, os.WriteFile(path, []byte(log), 0o644))
+
+	_, err := catalog.OpenFileStore(path)
+	var corrupt internal.ErrCorruptStore
+	assert.True(t, errors.As(err, &corrupt))
+	assert.Equal(t, 2, corrupt.Line)
+}
+
+func TestFileStore_Compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store := openFileStore(t, path)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+
+	assert.NoError(t, store.Compact(ctx))
+	assert.NoError(t, store.Add(ctx, catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.NewFromFloat(19.99)}))
+
+	products, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, products, 5)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+
+	reopened, err := catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	assert.Len(t, reopened.ProductsBySKU(), 5)
+}
+
+func TestFileStore_UpdateAndDeleteSurviveRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "ipd", Name: "Super iPad", Price: decimal.NewFromFloat(499.99)}))
+	assert.NoError(t, c.SetStatus(ctx, "mbp", catalog.Discontinued))
+	assert.NoError(t, c.DeleteProduct(ctx, "vga"))
+	assert.NoError(t, store.Close())
+
+	c, err = catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	assert.Len(t, c.ProductsBySKU(), 3)
+	product, err := c.GetProduct(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "499.99", product.Price.String())
+	product, err = c.GetProduct(ctx, "mbp")
+	assert.NoError(t, err)
+	assert.Equal(t, catalog.Discontinued, product.Status)
+	_, err = c.GetProduct(ctx, "vga")
+	assert.Error(t, err)
+}
+
+func TestFileStore_CompactsAutomatically(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store := openFileStore(t, path)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+
+	// Names rather than prices change, so no price history builds up
+	for i := 0; i < 1500; i++ {
+		err := c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: fmt.Sprintf("Apple TV %d", i%10), Price: decimal.NewFromInt(109)})
+		assert.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Less(t, bytes.Count(data, []byte("\n")), 1000)
+
+	products, err := store.Load(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, products, 4)
+	assert.Equal(t, "atv", products[2].SKU)
+	assert.Equal(t, "Apple TV 9", products[2].Name)
+	assert.Len(t, products[2].Prices, 1)
+}
+
+func TestFileStore_CompactionFailureKeepsChange(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "catalog")
+	assert.NoError(t, os.Mkdir(dir, 0o755))
+	store := openFileStore(t, filepath.Join(dir, "catalog.log"))
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+
+	// Without its directory the log can still be appended to but not
+	// compacted, which must not fail changes that were written
+	assert.NoError(t, os.RemoveAll(dir))
+	for i := 0; i < 1100; i++ {
+		err := c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: fmt.Sprintf("Apple TV %d", i), Price: decimal.NewFromInt(109)})
+		assert.NoError(t, err)
+	}
+	product, err := c.GetProduct(ctx, "atv")
+	assert.NoError(t, err)
+	assert.Equal(t, "Apple TV 1099", product.Name)
+}
+
+func TestFileStore_LegacyDuplicateKeepsFirst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	log := `{"op":"add","sku":"ipd","name":"Super iPad","price":"549.99"}` + "\n" +
+		`{"op":"add","sku":"ipd","name":"Super iPad Pro","price":"649.99"}` + "\n"
+	assert.NoError(t, os.WriteFile(path, []byte(log), 0o644))
+
+	c, err := catalog.OpenCatalog(context.Background(), openFileStore(t, path))
+	assert.NoError(t, err)
+	product, err := c.GetProduct(context.Background(), "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "Super iPad", product.Name)
+	assert.Equal(t, catalog.Active, product.Status)
+}
+
+func TestFileStore_Variants(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "catalog.log")
+	store, err := catalog.OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.NoError(t, catalog.Seed(ctx, store, catalog.SeedProducts()))
+	c, err := catalog.OpenCatalog(ctx, store)
+	assert.NoError(t, err)
+	addVariants(t, c)
+	assert.NoError(t, store.Close())
+
+	c, err = catalog.OpenCatalog(ctx, openFileStore(t, path))
+	assert.NoError(t, err)
+	variants, err := c.Variants(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Len(t, variants, 2)
+	assert.Equal(t, "ipd", variants[0].Parent)
+	assert.Equal(t, map[string]string{"storage": "128GB", "colour": "grey"}, variants[0].Attributes)
+}
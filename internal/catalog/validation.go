@@ -0,0 +1,141 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// DefaultSKUPattern is the SKU format the default validators accept: up to 64
+// letters, digits, dots, dashes and underscores, starting with a letter or digit
+var DefaultSKUPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Validator checks one product before it is added to or updated in the
+// catalog. Returning errors.Join of several errors reports them all.
+type Validator interface {
+	Validate(product Product) error
+}
+
+// ValidatorFunc adapts a function to a Validator
+type ValidatorFunc func(product Product) error
+
+func (f ValidatorFunc) Validate(product Product) error {
+	return f(product)
+}
+
+// DefaultValidators returns the validators a catalog runs unless told
+// otherwise: a non-negative price with at most two decimal places, a name, and
+// a SKU matching DefaultSKUPattern
+func DefaultValidators() []Validator {
+	return []Validator{
+		NonNegativePrice(),
+		MaxPricePlaces(2),
+		NonEmptyName(),
+		SKUPattern(DefaultSKUPattern),
+	}
+}
+
+// NonNegativePrice rejects products priced below zero at any time
+func NonNegativePrice() Validator {
+	return ValidatorFunc(func(product Product) error {
+		var errs []error
+		for _, price := range prices(product) {
+			if price.IsNegative() {
+				errs = append(errs, internal.NewNegativePriceError(product.SKU, price))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// MaxPricePlaces rejects prices with more than places decimal places at any
+// time
+func MaxPricePlaces(places int32) Validator {
+	return ValidatorFunc(func(product Product) error {
+		var errs []error
+		for _, price := range prices(product) {
+			if !price.Equal(price.Truncate(places)) {
+				errs = append(errs, internal.NewInvalidProductError(product.SKU, fmt.Sprintf("price %s has more than %d decimal places", price, places)))
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// prices lists every price the product has at one time or another
+func prices(product Product) []decimal.Decimal {
+	prices := []decimal.Decimal{product.Price}
+	for _, period := range product.Prices {
+		prices = append(prices, period.Price)
+	}
+	return prices
+}
+
+// NonEmptyName rejects products without a name
+func NonEmptyName() Validator {
+	return ValidatorFunc(func(product Product) error {
+		if product.Name == "" {
+			return internal.NewInvalidProductError(product.SKU, "name cannot be empty")
+		}
+		return nil
+	})
+}
+
+// SKUPattern rejects SKUs that do not match pattern
+func SKUPattern(pattern *regexp.Regexp) Validator {
+	return ValidatorFunc(func(product Product) error {
+		if product.SKU != "" && !pattern.MatchString(product.SKU) {
+			return internal.NewInvalidProductError(product.SKU, "SKU must match "+pattern.String())
+		}
+		return nil
+	})
+}
+
+// DuplicatePolicy decides what a bulk add does with a product whose SKU is
+// already in the catalog
+type DuplicatePolicy int
+
+const (
+	// RejectDuplicates fails the whole batch with ErrDuplicateSKU
+	RejectDuplicates DuplicatePolicy = iota
+	// ReplaceDuplicates updates the existing product
+	ReplaceDuplicates
+	// SkipDuplicates keeps the existing product and drops the new one
+	SkipDuplicates
+)
+
+// check runs every check a product must pass to be stored in snapshot under
+// operation, and returns all the problems found at once
+func (c *Catalog) check(snapshot *Snapshot, product Product, operation string) []error {
+	var errs []error
+	if product.SKU == "" {
+		errs = append(errs, internal.NewEmptySKUError(operation))
+	}
+	if _, err := ParseStatus(string(product.Status)); err != nil {
+		errs = append(errs, internal.NewInvalidProductError(product.SKU, err.Error()))
+	}
+	if err := snapshot.validateParent(product); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, snapshot.validateCodes(product)...)
+	errs = append(errs, validateClassification(product)...)
+	errs = append(errs, validatePrices(product)...)
+	for _, validator := range c.validators {
+		if err := validator.Validate(product); err != nil {
+			errs = append(errs, unjoin(err)...)
+		}
+	}
+	return errs
+}
+
+// unjoin splits an errors.Join result back into its errors, so an aggregated
+// error lists each problem once
+func unjoin(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
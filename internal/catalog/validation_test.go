@@ -0,0 +1,105 @@
+package catalog_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddProduct_ReportsEveryProblem(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "ipd!", Price: decimal.RequireFromString("-1.999")})
+
+	var validation internal.ErrValidation
+	assert.True(t, errors.As(err, &validation))
+	assert.Len(t, validation.Errors, 4)
+	var negative internal.ErrNegativePrice
+	assert.True(t, errors.As(err, &negative))
+	assert.EqualError(t, err, "4 validation errors: "+
+		"negative price not allowed for product ipd!: -1.999; "+
+		"invalid product ipd!: price -1.999 has more than 2 decimal places; "+
+		"invalid product ipd!: name cannot be empty; "+
+		"invalid product ipd!: SKU must match "+catalog.DefaultSKUPattern.String())
+}
+
+func TestAddProduct_DuplicateIsValidationError(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "ipd", Price: decimal.NewFromInt(1)})
+	var duplicate internal.ErrDuplicateSKU
+	assert.True(t, errors.As(err, &duplicate))
+	assert.EqualError(t, err, "2 validation errors: invalid product ipd: name cannot be empty; product already exists: ipd")
+}
+
+func TestUpdateProduct_Validates(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.UpdateProduct(context.Background(), catalog.Product{SKU: "ipd", Name: "Super iPad", Price: decimal.RequireFromString("549.995")})
+	var invalid internal.ErrInvalidProduct
+	assert.True(t, errors.As(err, &invalid))
+
+	product, err := c.GetProduct(context.Background(), "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "549.99", product.Price.String())
+}
+
+func TestWithValidators(t *testing.T) {
+	upper := regexp.MustCompile(`^[A-Z]{3}[0-9]{3}$`)
+	noFree := catalog.ValidatorFunc(func(product catalog.Product) error {
+		if product.Price.IsZero() {
+			return internal.NewInvalidProductError(product.SKU, "price cannot be zero")
+		}
+		return nil
+	})
+	c := catalog.NewCatalog(catalog.WithValidators(catalog.SKUPattern(upper), noFree))
+
+	assert.NoError(t, c.AddProduct(context.Background(), catalog.Product{SKU: "HDM001", Price: decimal.NewFromInt(20)}))
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "hdm002", Name: "HDMI cable"})
+	assert.EqualError(t, err, "2 validation errors: invalid product hdm002: SKU must match ^[A-Z]{3}[0-9]{3}$; invalid product hdm002: price cannot be zero")
+}
+
+func TestAddProducts_AllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	err := c.AddProducts(ctx, catalog.RejectDuplicates,
+		catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.RequireFromString("19.99")},
+		catalog.Product{SKU: "dvi", Name: "DVI cable", Price: decimal.RequireFromString("-5")},
+		catalog.Product{SKU: "vga", Name: "VGA adapter", Price: decimal.RequireFromString("30")},
+	)
+	var validation internal.ErrValidation
+	assert.True(t, errors.As(err, &validation))
+	assert.Len(t, validation.Errors, 2)
+	var duplicate internal.ErrDuplicateSKU
+	assert.True(t, errors.As(err, &duplicate))
+	_, err = c.GetProduct(ctx, "hdmi")
+	assert.Error(t, err, "nothing is added when any product fails")
+}
+
+func TestAddProducts_DuplicatePolicy(t *testing.T) {
+	ctx := context.Background()
+	batch := []catalog.Product{
+		{SKU: "ipd", Name: "Super iPad", Price: decimal.RequireFromString("499.99")},
+		{SKU: "ipd-256", Name: "Super iPad 256GB", Price: decimal.RequireFromString("649.99"), Parent: "ipd"},
+	}
+
+	c := catalog.NewCatalog()
+	assert.NoError(t, c.AddProducts(ctx, catalog.SkipDuplicates, batch...))
+	product, err := c.GetProduct(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "549.99", product.Price.String())
+	_, err = c.GetProduct(ctx, "ipd-256")
+	assert.NoError(t, err)
+
+	c = catalog.NewCatalog()
+	assert.NoError(t, c.AddProducts(ctx, catalog.ReplaceDuplicates, batch...))
+	product, err = c.GetProduct(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Equal(t, "499.99", product.Price.String())
+	variants, err := c.Variants(ctx, "ipd")
+	assert.NoError(t, err)
+	assert.Len(t, variants, 1)
+}
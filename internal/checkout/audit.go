@@ -0,0 +1,87 @@
+package checkout
+
+import (
+	"time"
+
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+// Action is a kind of change made to a checkout
+type Action string
+
+const (
+	ActionScan         Action = "scan"
+	ActionRemove       Action = "remove"
+	ActionSetQuantity  Action = "set-quantity"
+	ActionVoid         Action = "void"
+	ActionApplyCoupon  Action = "apply-coupon"
+	ActionRemoveCoupon Action = "remove-coupon"
+	ActionAbandon      Action = "abandon"
+)
+
+// VoidReason records why a cashier voided a line
+type VoidReason string
+
+const (
+	VoidScanError     VoidReason = "scan-error"
+	VoidChangedMind   VoidReason = "changed-mind"
+	VoidPriceDispute  VoidReason = "price-dispute"
+	VoidDamagedItem   VoidReason = "damaged-item"
+	VoidManagerAction VoidReason = "manager-action"
+)
+
+// Valid reports whether r is one of the defined reason codes
+func (r VoidReason) Valid() bool {
+	switch r {
+	case VoidScanError, VoidChangedMind, VoidPriceDispute, VoidDamagedItem, VoidManagerAction:
+		return true
+	}
+	return false
+}
+
+// AuditEntry records one change made to a checkout
+type AuditEntry struct {
+	Time   time.Time
+	Action Action
+	// SKU is the item changed, the coupon code for coupon actions, or empty
+	// when the checkout is abandoned
+	SKU string
+	// Quantity is how many items of the SKU the checkout held afterwards, and
+	// zero for coupon actions
+	Quantity int
+	// Price is the catalog price of the SKU at Time, and zero for coupon
+	// actions, abandoning or a product no longer in the catalog
+	Price  money.Money
+	Reason VoidReason
+}
+
+// record appends an entry for a change that has just been made
+func (c *Checkout) record(action Action, sku string, reason VoidReason) {
+	now := c.clock.Now()
+	c.audit = append(c.audit, AuditEntry{
+		Time:     now,
+		Action:   action,
+		SKU:      sku,
+		Quantity: c.quantity(sku),
+		Price:    c.priceAt(action, sku, now),
+		Reason:   reason,
+	})
+}
+
+// priceAt returns the catalog price of sku at t for an audit entry
+func (c *Checkout) priceAt(action Action, sku string, t time.Time) money.Money {
+	if action == ActionApplyCoupon || action == ActionRemoveCoupon {
+		return money.Money{}
+	}
+	products := c.catalog.Snapshot()
+	price, err := products.PriceAt(sku, t)
+	if err != nil {
+		return money.Money{}
+	}
+	return money.New(price, products.Currency())
+}
+
+// AuditTrail returns every change made to the checkout, oldest first
+func (c *Checkout) AuditTrail() []AuditEntry {
+	return append([]AuditEntry(nil), c.audit...)
+}
@@ -3,63 +3,294 @@ package checkout
 import (
 	"context"
 	"fmt"
+	"slices"
 
 	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
 	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/coupons"
+	"github.com/spa5k/zeller_go/internal/inventory"
+	"github.com/spa5k/zeller_go/internal/money"
 	"github.com/spa5k/zeller_go/internal/pricingrules"
 )
 
+// Item is what a cashier scans: a number of whole items of one SKU, or one
+// measured amount of a product sold by measure
 type Item struct {
+	// SKU is the code scanned or typed in: the product's SKU or one of its
+	// barcodes or aliases. The checkout keeps the item under the product's
+	// SKU.
 	SKU string
+	// Quantity is the number of whole items. Zero means one.
+	Quantity int
+	// Measure is the amount of a product sold by measure, such as 1.25 for
+	// 1.25 kg, in the product's unit. It must be zero for other products.
+	Measure decimal.Decimal
 }
 
 type Checkout struct {
-	pricingRules map[string]pricingrules.PricingRule
+	pricingRules []pricingrules.PricingRule
 	items        []Item
 	catalog      *catalog.Catalog
+	rounding     money.Rounding
+	clock        internal.Clock
+	basketRules  []pricingrules.BasketRule
+	// coupons is where coupon codes are looked up; appliedCoupons holds the
+	// normalised codes applied so far, in order
+	coupons        *coupons.Store
+	customer       string
+	appliedCoupons []string
+	optimise       bool
+	searchBudget   int
+	audit          []AuditEntry
+	// inventory is where stock is reserved, if the checkout sells from
+	// stock; reservation identifies the stock this checkout holds
+	inventory   *inventory.Store
+	location    string
+	reservation string
+	// finalised is set once the sale has gone through, after which the
+	// checkout cannot be changed; redeemed holds the coupons the sale used
+	finalised bool
+	redeemed  []coupons.Coupon
 }
 
-func NewCheckout(pricingRules map[string]pricingrules.PricingRule, catalog *catalog.Catalog) *Checkout {
-	return &Checkout{
-		pricingRules: pricingRules,
+// Option configures optional checkout behaviour
+type Option func(*Checkout)
+
+// WithRounding sets how and where the checkout rounds amounts. The default is
+// money.DefaultRounding.
+func WithRounding(rounding money.Rounding) Option {
+	return func(c *Checkout) {
+		c.rounding = rounding
+	}
+}
+
+// WithClock sets the clock the checkout reads when it prices the basket, which
+// decides whether time-limited promotions apply. The default is the system clock.
+func WithClock(clock internal.Clock) Option {
+	return func(c *Checkout) {
+		c.clock = clock
+	}
+}
+
+// WithBasketRules sets the rules that price the order as a whole once every
+// item rule has run, such as spend thresholds and free gifts. They run in order.
+func WithBasketRules(rules ...pricingrules.BasketRule) Option {
+	return func(c *Checkout) {
+		c.basketRules = append([]pricingrules.BasketRule(nil), rules...)
+	}
+}
+
+// WithOptimiser makes the checkout search for the assignment of its rules to
+// the scanned items that gives the lowest total, instead of letting rules
+// claim items in list order. budget bounds the search, see
+// pricingrules.Optimise; zero means pricingrules.DefaultSearchBudget.
+func WithOptimiser(budget int) Option {
+	return func(c *Checkout) {
+		c.optimise = true
+		c.searchBudget = budget
+	}
+}
+
+// NewCheckout creates a checkout that applies pricingRules in order. Each rule
+// sees the whole basket minus the items claimed by the rules before it. The
+// checkout keeps its own copy of the list, so it prices with the same rules
+// for its whole lifetime even if the caller later changes or reloads them.
+func NewCheckout(pricingRules []pricingrules.PricingRule, catalog *catalog.Catalog, opts ...Option) *Checkout {
+	c := &Checkout{
+		pricingRules: append([]pricingrules.PricingRule(nil), pricingRules...),
 		catalog:      catalog,
+		rounding:     money.DefaultRounding,
+		clock:        internal.SystemClock{},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
+// Scan adds an item to the checkout, looking its product up once however many
+// whole items it stands for. A product that comes in variants is scanned by the
+// SKU of the variant. The item may be scanned by a barcode or alias of the
+// product instead of its SKU.
 func (c *Checkout) Scan(item Item) error {
+	if c.finalised {
+		return internal.NewCheckoutFinalisedError()
+	}
 	if item.SKU == "" {
 		return fmt.Errorf("Item SKU cannot be empty")
 	}
-	_, err := c.catalog.GetProduct(context.Background(), item.SKU)
+	product, err := c.catalog.Resolve(context.Background(), item.SKU)
 	if err != nil {
 		return err
 	}
+	item.SKU = product.SKU
+	if !product.Status.Sellable() {
+		return internal.NewProductUnavailableError(item.SKU, string(product.Status))
+	}
+	if err := c.requireVariant(product); err != nil {
+		return err
+	}
+	switch {
+	case product.Unit.Measured() && !item.Measure.IsPositive():
+		return internal.NewInvalidMeasureError(item.SKU, item.Measure, "must be a positive amount in "+string(product.Unit))
+	case !product.Unit.Measured() && !item.Measure.IsZero():
+		return internal.NewInvalidMeasureError(item.SKU, item.Measure, "product is not sold by measure")
+	case item.Quantity < 0 || (product.Unit.Measured() && item.Quantity > 1):
+		return internal.NewInvalidQuantityError(item.SKU, item.Quantity)
+	}
+	if item.Quantity == 0 {
+		item.Quantity = 1
+	}
+	if err := c.reserve(append(slices.Clone(c.items), item)); err != nil {
+		return err
+	}
 	c.items = append(c.items, item)
+	c.record(ActionScan, item.SKU, "")
+	return nil
+}
+
+// skuOf returns the SKU of the product code stands for, or code itself if no
+// product has it
+func (c *Checkout) skuOf(code string) string {
+	if product, err := c.catalog.Snapshot().Resolve(code); err == nil {
+		return product.SKU
+	}
+	return code
+}
+
+// requireVariant returns an error if product comes in variants, since the
+// cashier must scan the variant the customer picked
+func (c *Checkout) requireVariant(product catalog.Product) error {
+	variants, err := c.catalog.Variants(context.Background(), product.SKU)
+	if err != nil || len(variants) == 0 {
+		return err
+	}
+	skus := make([]string, len(variants))
+	for i, variant := range variants {
+		skus[i] = variant.SKU
+	}
+	return internal.NewVariantRequiredError(product.SKU, skus)
+}
+
+// quantity returns how many whole items, or measured amounts, of sku have
+// been scanned
+func (c *Checkout) quantity(sku string) int {
+	n := 0
+	for _, item := range c.items {
+		if item.SKU == sku {
+			n += item.Quantity
+		}
+	}
+	return n
+}
+
+// removeLast takes n whole items or measured amounts of sku out of the
+// checkout, most recently scanned first
+func (c *Checkout) removeLast(sku string, n int) {
+	for i := len(c.items) - 1; i >= 0 && n > 0; i-- {
+		if c.items[i].SKU != sku {
+			continue
+		}
+		if c.items[i].Quantity > n {
+			c.items[i].Quantity -= n
+			return
+		}
+		n -= c.items[i].Quantity
+		c.items = append(c.items[:i], c.items[i+1:]...)
+	}
+}
+
+// Remove takes the most recently scanned item of sku out of the checkout, for
+// example after a double scan. For a product sold by measure the most recent
+// measured amount is removed.
+func (c *Checkout) Remove(sku string) error {
+	if c.finalised {
+		return internal.NewCheckoutFinalisedError()
+	}
+	sku = c.skuOf(sku)
+	if c.quantity(sku) == 0 {
+		return internal.NewItemNotScannedError(sku)
+	}
+	c.removeLast(sku, 1)
+	c.unreserve()
+	c.record(ActionRemove, sku, "")
+	return nil
+}
+
+// SetQuantity changes how many whole items of sku the checkout holds. Extra
+// items are added as if scanned and surplus items are removed, most recent
+// first. Products sold by measure are scanned with their measured amount
+// instead.
+func (c *Checkout) SetQuantity(sku string, n int) error {
+	if c.finalised {
+		return internal.NewCheckoutFinalisedError()
+	}
+	if n < 0 {
+		return internal.NewInvalidQuantityError(sku, n)
+	}
+	if sku == "" {
+		return fmt.Errorf("Item SKU cannot be empty")
+	}
+	product, err := c.catalog.Resolve(context.Background(), sku)
+	if err != nil {
+		return err
+	}
+	sku = product.SKU
+	if product.Unit.Measured() {
+		return internal.NewInvalidMeasureError(sku, decimal.Zero, "product is sold by measure, scan the measured amount instead")
+	}
+	if have := c.quantity(sku); have > n {
+		c.removeLast(sku, have-n)
+		c.unreserve()
+	} else if have < n {
+		if !product.Status.Sellable() {
+			return internal.NewProductUnavailableError(sku, string(product.Status))
+		}
+		if err := c.requireVariant(product); err != nil {
+			return err
+		}
+		item := Item{SKU: sku, Quantity: n - have}
+		if err := c.reserve(append(slices.Clone(c.items), item)); err != nil {
+			return err
+		}
+		c.items = append(c.items, item)
+	}
+	c.record(ActionSetQuantity, sku, "")
 	return nil
 }
 
-func (c *Checkout) Total() (float64, error) {
-	skuItems := make(map[string][]pricingrules.Item)
+// Void takes every item of sku out of the checkout, recording why. The reason
+// must be one of the VoidReason codes.
+func (c *Checkout) Void(sku string, reason VoidReason) error {
+	if c.finalised {
+		return internal.NewCheckoutFinalisedError()
+	}
+	if !reason.Valid() {
+		return internal.NewInvalidVoidReasonError(string(reason))
+	}
+	sku = c.skuOf(sku)
+	if c.quantity(sku) == 0 {
+		return internal.NewItemNotScannedError(sku)
+	}
+	kept := c.items[:0]
 	for _, item := range c.items {
-		skuItems[item.SKU] = append(skuItems[item.SKU], pricingrules.Item{SKU: item.SKU})
-	}
-
-	var total float64
-	for sku, items := range skuItems {
-		if rule, ok := c.pricingRules[sku]; ok {
-			price, err := rule.Apply(items, c.catalog)
-			if err != nil {
-				return 0, err
-			}
-			total += price
-		} else {
-			product, err := c.catalog.GetProduct(context.Background(), sku)
-			if err != nil {
-				return 0, err
-			}
-			itemCount := decimal.NewFromInt(int64(len(items)))
-			total += itemCount.Mul(product.Price).InexactFloat64()
+		if item.SKU != sku {
+			kept = append(kept, item)
 		}
 	}
-	return total, nil
+	c.items = kept
+	c.unreserve()
+	c.record(ActionVoid, sku, reason)
+	return nil
+}
+
+// Total prices the basket and rounds the result according to the checkout's
+// rounding settings. Use Receipt for a breakdown of how the total was reached.
+func (c *Checkout) Total() (money.Money, error) {
+	receipt, err := c.Receipt()
+	if err != nil {
+		return money.Money{}, err
+	}
+	return receipt.Total, nil
 }
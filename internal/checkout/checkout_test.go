@@ -2,25 +2,34 @@ package checkout_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
 	"github.com/spa5k/zeller_go/internal/catalog"
 	"github.com/spa5k/zeller_go/internal/checkout"
+	"github.com/spa5k/zeller_go/internal/money"
 	"github.com/spa5k/zeller_go/internal/pricingrules"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/exp/rand"
 )
 
+func aud(amount string) money.Money {
+	return money.MustParse(amount, money.AUD)
+}
+
 func TestCheckout_Scenario1(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{
 			SKU:         "ipd",
 			MinQuantity: 5,
-			NewPrice:    499.99,
+			NewPrice:    aud("499.99"),
 		},
 	}
 	co := checkout.NewCheckout(pricingRules, c)
@@ -35,18 +44,18 @@ func TestCheckout_Scenario1(t *testing.T) {
 	total, err := co.Total()
 	assert.NoError(t, err)
 
-	expectedTotal := 249.00
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("249.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestCheckout_Scenario2(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{
 			SKU:         "ipd",
 			MinQuantity: 5,
-			NewPrice:    499.99,
+			NewPrice:    aud("499.99"),
 		},
 	}
 	co := checkout.NewCheckout(pricingRules, c)
@@ -61,13 +70,13 @@ func TestCheckout_Scenario2(t *testing.T) {
 	total, err := co.Total()
 	assert.NoError(t, err)
 
-	expectedTotal := 2718.95
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("2718.95")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestCheckout_InvalidSKU(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{}
+	pricingRules := []pricingrules.PricingRule{}
 	co := checkout.NewCheckout(pricingRules, c)
 
 	err := co.Scan(checkout.Item{SKU: "unknown"})
@@ -76,7 +85,7 @@ func TestCheckout_InvalidSKU(t *testing.T) {
 
 func TestCheckout_EmptySKU(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{}
+	pricingRules := []pricingrules.PricingRule{}
 	co := checkout.NewCheckout(pricingRules, c)
 
 	err := co.Scan(checkout.Item{SKU: ""})
@@ -85,19 +94,19 @@ func TestCheckout_EmptySKU(t *testing.T) {
 
 func TestCheckout_NoItemsScanned(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{}
+	pricingRules := []pricingrules.PricingRule{}
 	co := checkout.NewCheckout(pricingRules, c)
 
 	total, err := co.Total()
 	assert.NoError(t, err)
 
-	expectedTotal := 0.0
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("0.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestCheckout_MixedSKUs_NoPricingRules(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{}
+	pricingRules := []pricingrules.PricingRule{}
 	co := checkout.NewCheckout(pricingRules, c)
 
 	items := []string{"ipd", "mbp", "vga"}
@@ -110,18 +119,20 @@ func TestCheckout_MixedSKUs_NoPricingRules(t *testing.T) {
 	total, err := co.Total()
 	assert.NoError(t, err)
 
-	expectedTotal := 549.99 + 1399.99 + 30.00
-	assert.Equal(t, expectedTotal, total)
+	// $549.99 + $1399.99 + $30.00
+
+	expectedTotal := aud("1979.98")
+	assert.Equal(t, expectedTotal.String(), total.String())
 }
 
 func TestCheckout_RandomBaskets(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{
 			SKU:         "ipd",
 			MinQuantity: 5,
-			NewPrice:    499.99,
+			NewPrice:    aud("499.99"),
 		},
 	}
 
@@ -131,7 +142,7 @@ func TestCheckout_RandomBaskets(t *testing.T) {
 	for i := 0; i < 5; i++ {
 		co := checkout.NewCheckout(pricingRules, c)
 		basketSize := rand.Intn(20) // Random basket size between 0 and 19
-		expectedTotal := 0.0
+		expectedTotal := money.Zero(money.AUD)
 		itemCounts := make(map[string]int)
 
 		// Build basket
@@ -146,36 +157,37 @@ func TestCheckout_RandomBaskets(t *testing.T) {
 		// Apply pricing rules manually for validation
 		for sku, count := range itemCounts {
 			product, _ := c.GetProduct(context.Background(), sku)
+			price := money.New(product.Price, money.AUD)
 			switch sku {
 			case "atv":
 				freeItems := count / 3
 				chargeable := count - freeItems
-				expectedTotal += product.Price.Mul(decimal.NewFromInt(int64(chargeable))).InexactFloat64()
+				expectedTotal = expectedTotal.Add(price.MulInt(int64(chargeable)))
 			case "ipd":
 				if count >= 5 {
-					expectedTotal += float64(count) * 499.99
+					expectedTotal = expectedTotal.Add(aud("499.99").MulInt(int64(count)))
 				} else {
-					expectedTotal += product.Price.Mul(decimal.NewFromInt(int64(count))).InexactFloat64()
+					expectedTotal = expectedTotal.Add(price.MulInt(int64(count)))
 				}
 			default:
-				expectedTotal += product.Price.Mul(decimal.NewFromInt(int64(count))).InexactFloat64()
+				expectedTotal = expectedTotal.Add(price.MulInt(int64(count)))
 			}
 		}
 
 		total, err := co.Total()
 		assert.NoError(t, err)
-		assert.Equal(t, expectedTotal, total, "Failed for random basket %d", i+1)
+		assert.Equal(t, expectedTotal.String(), total.String(), "Failed for random basket %d", i+1)
 	}
 }
 
 func TestCheckout_BoundaryQuantities(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"atv": &pricingrules.ThreeForTwoRule{SKU: "atv"},
-		"ipd": &pricingrules.BulkDiscountRule{
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{
 			SKU:         "ipd",
 			MinQuantity: 5,
-			NewPrice:    499.99,
+			NewPrice:    aud("499.99"),
 		},
 	}
 
@@ -212,7 +224,7 @@ func TestCheckout_BoundaryQuantities(t *testing.T) {
 		assert.NoError(t, err)
 
 		// Manually calculate expected total
-		expectedTotal := 0.0
+		expectedTotal := money.Zero(money.AUD)
 		itemCounts := make(map[string]int)
 		for _, sku := range tc.items {
 			itemCounts[sku]++
@@ -220,33 +232,34 @@ func TestCheckout_BoundaryQuantities(t *testing.T) {
 
 		for sku, count := range itemCounts {
 			product, _ := c.GetProduct(context.Background(), sku)
+			price := money.New(product.Price, money.AUD)
 			switch sku {
 			case "atv":
 				freeItems := count / 3
 				chargeable := count - freeItems
-				expectedTotal += product.Price.Mul(decimal.NewFromInt(int64(chargeable))).InexactFloat64()
+				expectedTotal = expectedTotal.Add(price.MulInt(int64(chargeable)))
 			case "ipd":
 				if count >= 5 {
-					expectedTotal += float64(count) * 499.99
+					expectedTotal = expectedTotal.Add(aud("499.99").MulInt(int64(count)))
 				} else {
-					expectedTotal += product.Price.Mul(decimal.NewFromInt(int64(count))).InexactFloat64()
+					expectedTotal = expectedTotal.Add(price.MulInt(int64(count)))
 				}
 			default:
-				expectedTotal += product.Price.Mul(decimal.NewFromInt(int64(count))).InexactFloat64()
+				expectedTotal = expectedTotal.Add(price.MulInt(int64(count)))
 			}
 		}
 
-		assert.Equal(t, expectedTotal, total, tc.description)
+		assert.Equal(t, expectedTotal.String(), total.String(), tc.description)
 	}
 }
 
 func TestCheckout_LargeQuantities(t *testing.T) {
 	c := catalog.NewCatalog()
-	pricingRules := map[string]pricingrules.PricingRule{
-		"ipd": &pricingrules.BulkDiscountRule{
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{
 			SKU:         "ipd",
 			MinQuantity: 5,
-			NewPrice:    499.99,
+			NewPrice:    aud("499.99"),
 		},
 	}
 	co := checkout.NewCheckout(pricingRules, c)
@@ -260,6 +273,538 @@ func TestCheckout_LargeQuantities(t *testing.T) {
 	total, err := co.Total()
 	assert.NoError(t, err)
 
-	expectedTotal := float64(quantity) * 499.99
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("499.99").MulInt(int64(quantity))
+	assert.Equal(t, expectedTotal.String(), total.String())
+}
+
+func TestCheckout_CrossSKUBundle(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+		&pricingrules.FreeWithPurchaseRule{SKU: "mbp", FreeSKU: "vga"},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for _, sku := range []string{"atv", "mbp", "ipd", "vga", "vga", "ipd"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+
+	// One iPad + Apple TV bundle, one MacBook with a free adapter, and a second
+	// iPad and adapter at list price
+	// $599.00 + $1399.99 + $549.99 + $30.00
+	expectedTotal := aud("2578.98")
+	assert.Equal(t, expectedTotal.String(), total.String())
+}
+
+func TestCheckout_ClaimedItemsAreNotRepriced(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for _, sku := range []string{"atv", "atv", "atv", "ipd"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+
+	// The bundle takes one Apple TV first, leaving only two for the 3 for 2 deal
+	// $599.00 + 2*$109.50
+	expectedTotal := aud("818.00")
+	assert.Equal(t, expectedTotal.String(), total.String())
+}
+
+func TestCheckout_StackedRulesOnOneSKU(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.RuleSet{
+			Policy: pricingrules.Sequential,
+			Rules: []pricingrules.PricingRule{
+				&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+				&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+			},
+		},
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for _, sku := range []string{"atv", "ipd", "ipd", "atv", "ipd", "ipd", "ipd", "ipd", "atv"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+
+	// 4*$499.99 + 2*$109.50
+
+	expectedTotal := aud("2218.96")
+	assert.Equal(t, expectedTotal.String(), total.String())
+}
+
+func TestCheckout_NoFloatDrift(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "cbl", Name: "Cable tie", Price: decimal.RequireFromString("0.10")})
+	assert.NoError(t, err)
+	co := checkout.NewCheckout([]pricingrules.PricingRule{}, c)
+
+	for i := 0; i < 1000; i++ {
+		err := co.Scan(checkout.Item{SKU: "cbl"})
+		assert.NoError(t, err)
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "100.00 AUD", total.String())
+}
+
+func TestCheckout_RoundingPoint(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "atv", MinQuantity: 1, NewPrice: aud("33.335")},
+		&pricingrules.BulkDiscountRule{SKU: "vga", MinQuantity: 1, NewPrice: aud("10.005")},
+	}
+
+	testCases := []struct {
+		description   string
+		rounding      money.Rounding
+		expectedTotal string
+	}{
+		{"Default rounds the basket once", money.DefaultRounding, "43.34 AUD"},
+		{"Half-up per line", money.Rounding{Mode: money.HalfUp, Point: money.PerLine}, "43.35 AUD"},
+		{"Banker's per line", money.Rounding{Mode: money.HalfEven, Point: money.PerLine}, "43.34 AUD"},
+		{"Truncate per basket", money.Rounding{Mode: money.Down, Point: money.PerBasket}, "43.34 AUD"},
+	}
+
+	for _, tc := range testCases {
+		co := checkout.NewCheckout(pricingRules, c, checkout.WithRounding(tc.rounding))
+		for _, sku := range []string{"atv", "vga"} {
+			err := co.Scan(checkout.Item{SKU: sku})
+			assert.NoError(t, err)
+		}
+
+		total, err := co.Total()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedTotal, total.String(), tc.description)
+	}
+}
+
+func TestCheckout_KeepsRuleSnapshot(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	// Changing the caller's list after opening the checkout does not affect it
+	pricingRules[0] = &pricingrules.BulkDiscountRule{SKU: "atv", MinQuantity: 1, NewPrice: aud("1.00")}
+
+	for _, sku := range []string{"atv", "atv", "atv"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "219.00 AUD", total.String())
+}
+
+func TestCheckout_TimeWindowedPromotion(t *testing.T) {
+	c := catalog.NewCatalog()
+	start := time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC)
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.TimeWindowRule{
+			Window: pricingrules.Window{Start: start, End: start.AddDate(0, 0, 4)},
+			Rule:   &pricingrules.ThreeForTwoRule{SKU: "atv"},
+		},
+	}
+
+	testCases := []struct {
+		description   string
+		now           time.Time
+		expectedTotal string
+	}{
+		{"before the promotion", start.Add(-time.Minute), "328.50 AUD"},
+		{"during the promotion", start.Add(36 * time.Hour), "219.00 AUD"},
+		{"after the promotion", start.AddDate(0, 0, 4), "328.50 AUD"},
+	}
+
+	for _, tc := range testCases {
+		co := checkout.NewCheckout(pricingRules, c, checkout.WithClock(internal.FixedClock(tc.now)))
+		for _, sku := range []string{"atv", "atv", "atv"} {
+			err := co.Scan(checkout.Item{SKU: sku})
+			assert.NoError(t, err)
+		}
+
+		receipt, err := co.Receipt()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedTotal, receipt.Total.String(), tc.description)
+		assert.True(t, tc.now.Equal(receipt.Time), tc.description)
+	}
+}
+
+func TestCheckout_WithOptimiser(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+	}
+	skus := []string{"ipd", "ipd", "ipd", "ipd", "ipd", "atv"}
+
+	ordered := checkout.NewCheckout(pricingRules, c)
+	optimised := checkout.NewCheckout(pricingRules, c, checkout.WithOptimiser(0))
+	for _, sku := range skus {
+		assert.NoError(t, ordered.Scan(checkout.Item{SKU: sku}))
+		assert.NoError(t, optimised.Scan(checkout.Item{SKU: sku}))
+	}
+
+	receipt, err := ordered.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, "2798.96 AUD", receipt.Total.String())
+	assert.Nil(t, receipt.Assignment)
+
+	// Bulk pricing every iPad beats bundling one of them with the Apple TV
+	receipt, err = optimised.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, "2609.45 AUD", receipt.Total.String())
+	assert.NotNil(t, receipt.Assignment)
+	assert.Contains(t, receipt.Assignment.String(), "Bulk discount on ipd (rule 2) claimed items 0, 1, 2, 3, 4")
+}
+
+func TestCheckout_Remove_RetriggersPricing(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+	for _, sku := range []string{"ipd", "ipd", "ipd", "ipd", "ipd"} {
+		assert.NoError(t, co.Scan(checkout.Item{SKU: sku}))
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "2499.95 AUD", total.String())
+
+	// Dropping below the bulk threshold brings back the list price
+	assert.NoError(t, co.Remove("ipd"))
+	total, err = co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "2199.96 AUD", total.String())
+
+	err = co.Remove("atv")
+	var notScanned internal.ErrItemNotScanned
+	assert.True(t, errors.As(err, &notScanned))
+	assert.Equal(t, "atv", notScanned.SKU)
+}
+
+func TestCheckout_SetQuantity(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}
+	co := checkout.NewCheckout(pricingRules, c)
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "atv"}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "vga"}))
+
+	testCases := []struct {
+		quantity      int
+		expectedTotal string
+	}{
+		{3, "249.00 AUD"},
+		{4, "358.50 AUD"},
+		{2, "249.00 AUD"},
+		{0, "30.00 AUD"},
+	}
+
+	for _, tc := range testCases {
+		assert.NoError(t, co.SetQuantity("atv", tc.quantity))
+		total, err := co.Total()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedTotal, total.String(), "quantity %d", tc.quantity)
+	}
+
+	var invalid internal.ErrInvalidQuantity
+	assert.True(t, errors.As(co.SetQuantity("atv", -1), &invalid))
+	var notFound internal.ErrProductNotFound
+	assert.True(t, errors.As(co.SetQuantity("hdmi", 1), &notFound))
+}
+
+func TestCheckout_Void(t *testing.T) {
+	c := catalog.NewCatalog()
+	co := checkout.NewCheckout(nil, c)
+	for _, sku := range []string{"mbp", "vga", "mbp"} {
+		assert.NoError(t, co.Scan(checkout.Item{SKU: sku}))
+	}
+
+	assert.EqualError(t, co.Void("mbp", ""), `invalid void reason ""`)
+	var invalid internal.ErrInvalidVoidReason
+	assert.True(t, errors.As(co.Void("mbp", "customer-said-so"), &invalid))
+	assert.Equal(t, "customer-said-so", invalid.Reason)
+	assert.NoError(t, co.Void("mbp", checkout.VoidPriceDispute))
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "30.00 AUD", total.String())
+
+	var notScanned internal.ErrItemNotScanned
+	assert.True(t, errors.As(co.Void("mbp", checkout.VoidScanError), &notScanned))
+}
+
+func TestCheckout_AuditTrail(t *testing.T) {
+	c := catalog.NewCatalog()
+	now := time.Date(2024, 11, 29, 12, 0, 0, 0, time.UTC)
+	co := checkout.NewCheckout(nil, c, checkout.WithClock(internal.FixedClock(now)))
+
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "ipd"}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "ipd"}))
+	assert.NoError(t, co.Remove("ipd"))
+	assert.NoError(t, co.SetQuantity("atv", 3))
+	assert.NoError(t, co.Void("atv", checkout.VoidChangedMind))
+	assert.Error(t, co.Remove("atv"))
+
+	expected := []checkout.AuditEntry{
+		{Time: now, Action: checkout.ActionScan, SKU: "ipd", Quantity: 1, Price: aud("549.99")},
+		{Time: now, Action: checkout.ActionScan, SKU: "ipd", Quantity: 2, Price: aud("549.99")},
+		{Time: now, Action: checkout.ActionRemove, SKU: "ipd", Quantity: 1, Price: aud("549.99")},
+		{Time: now, Action: checkout.ActionSetQuantity, SKU: "atv", Quantity: 3, Price: aud("109.5")},
+		{Time: now, Action: checkout.ActionVoid, SKU: "atv", Quantity: 0, Price: aud("109.5"), Reason: checkout.VoidChangedMind},
+	}
+	trail := co.AuditTrail()
+	assert.Equal(t, expected, trail)
+
+	// The trail handed out is a copy
+	trail[0].SKU = "mbp"
+	assert.Equal(t, "ipd", co.AuditTrail()[0].SKU)
+}
+
+func TestCheckout_ScanQuantity(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "vga", Quantity: 50}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "ipd", Quantity: 4}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "ipd"}))
+	assert.NoError(t, co.Remove("vga"))
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, 49, receipt.Lines[0].Quantity)
+	assert.Equal(t, 5, receipt.Lines[1].Quantity)
+	assert.Equal(t, "3969.95 AUD", receipt.Total.String())
+
+	var invalid internal.ErrInvalidQuantity
+	assert.True(t, errors.As(co.Scan(checkout.Item{SKU: "vga", Quantity: -1}), &invalid))
+
+	assert.NoError(t, co.SetQuantity("vga", 2))
+	receipt, err = co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, receipt.Lines[0].Quantity)
+}
+
+func TestCheckout_RulesSplitScannedQuantity(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "atv", Quantity: 4}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "ipd"}))
+
+	// The bundle takes one Apple TV off the scan of four, leaving three for
+	// the 3 for 2 deal: $599.00 + 2*$109.50
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, receipt.Lines[0].Quantity)
+	assert.Equal(t, "818.00 AUD", receipt.Total.String())
+
+	// A quantity far too large to price item by item, such as a barcode keyed
+	// into the quantity field, is priced as one basket item
+	co = checkout.NewCheckout(pricingRules[1:], c)
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "atv", Quantity: 9300000000002}))
+	receipt, err = co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, 9300000000002, receipt.Lines[0].Quantity)
+	assert.Equal(t, "678900000000219.00 AUD", receipt.Total.String())
+}
+
+func TestCheckout_ScanMeasured(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "cbl", Name: "Cable", Price: decimal.RequireFromString("2.50"), Unit: catalog.Metre})
+	assert.NoError(t, err)
+	co := checkout.NewCheckout(nil, c)
+
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "cbl", Measure: decimal.RequireFromString("1.5")}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "cbl", Measure: decimal.RequireFromString("0.25")}))
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	line := receipt.Lines[0]
+	assert.Equal(t, catalog.Metre, line.Unit)
+	assert.Equal(t, "1.75", line.Measure.String())
+	assert.Equal(t, "4.375 AUD", line.Gross.String())
+	assert.Equal(t, "4.38 AUD", receipt.Total.String())
+	assert.Contains(t, receipt.String(), "1.75m")
+
+	var invalid internal.ErrInvalidMeasure
+	assert.True(t, errors.As(co.Scan(checkout.Item{SKU: "cbl"}), &invalid))
+	assert.True(t, errors.As(co.Scan(checkout.Item{SKU: "ipd", Measure: decimal.NewFromInt(1)}), &invalid))
+	assert.True(t, errors.As(co.SetQuantity("cbl", 2), &invalid))
+
+	var quantity internal.ErrInvalidQuantity
+	assert.True(t, errors.As(co.Scan(checkout.Item{SKU: "cbl", Quantity: 2, Measure: decimal.NewFromInt(1)}), &quantity))
+
+	// Removing takes off the last measured amount
+	assert.NoError(t, co.Remove("cbl"))
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "3.75 AUD", total.String())
+}
+
+func TestCheckout_ParallelCheckoutsShareCatalog(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			sku := fmt.Sprintf("new-%d", i)
+			assert.NoError(t, c.AddProduct(context.Background(), catalog.Product{SKU: sku, Name: sku, Price: decimal.NewFromInt(1)}))
+		}
+	}()
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			co := checkout.NewCheckout(pricingRules, c)
+			for _, sku := range []string{"atv", "atv", "atv", "vga"} {
+				assert.NoError(t, co.Scan(checkout.Item{SKU: sku}))
+			}
+			total, err := co.Total()
+			assert.NoError(t, err)
+			assert.Equal(t, "249.00 AUD", total.String())
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCheckout_ScanUnavailableProduct(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	assert.NoError(t, c.AddProduct(ctx, catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.NewFromFloat(19.99), Status: catalog.Draft}))
+	co := checkout.NewCheckout(nil, c)
+
+	var unavailable internal.ErrProductUnavailable
+	err := co.Scan(checkout.Item{SKU: "hdmi"})
+	assert.True(t, errors.As(err, &unavailable))
+	assert.EqualError(t, err, "product hdmi is draft and cannot be sold")
+
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "atv"}))
+	assert.NoError(t, c.SetStatus(ctx, "atv", catalog.Discontinued))
+	err = co.Scan(checkout.Item{SKU: "atv"})
+	assert.EqualError(t, err, "product atv is discontinued and cannot be sold")
+	assert.True(t, errors.As(co.SetQuantity("atv", 2), &unavailable))
+
+	// Items scanned before the product was discontinued stay in the basket
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "109.50 AUD", total.String())
+}
+
+func TestCheckout_ScanVariants(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	for _, variant := range []catalog.Product{
+		{SKU: "ipd-256", Name: "Super iPad 256GB", Price: decimal.NewFromFloat(699.99), Parent: "ipd", Attributes: map[string]string{"storage": "256GB"}},
+		{SKU: "ipd-128", Name: "Super iPad 128GB", Price: decimal.NewFromFloat(549.99), Parent: "ipd", Attributes: map[string]string{"storage": "128GB"}},
+	} {
+		assert.NoError(t, c.AddProduct(ctx, variant))
+	}
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 3, NewPrice: aud("499.99")},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	var required internal.ErrVariantRequired
+	err := co.Scan(checkout.Item{SKU: "ipd"})
+	assert.True(t, errors.As(err, &required))
+	assert.EqualError(t, err, "product ipd comes in variants, scan one of: ipd-128, ipd-256")
+
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "ipd-256"}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "ipd-128", Quantity: 2}))
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines, 2)
+	assert.Equal(t, "Super iPad 256GB", receipt.Lines[0].Name)
+	assert.Equal(t, "1499.97 AUD", receipt.Total.String())
+}
+
+func TestCheckout_CategoryPromotion(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.PercentOffRule{Name: "10% off accessories", SKU: "category:accessories", Percent: decimal.NewFromInt(10)},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	// A product added to the category after the rule was set up is covered
+	hdmi := catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.RequireFromString("20.00"), Category: "accessories/cables"}
+	assert.NoError(t, c.AddProduct(context.Background(), hdmi))
+	for _, sku := range []string{"vga", "hdmi", "atv"} {
+		assert.NoError(t, co.Scan(checkout.Item{SKU: sku}))
+	}
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "154.50 AUD", total.String())
+}
+
+func TestCheckout_ScanBarcodesAndAliases(t *testing.T) {
+	c := catalog.NewCatalog()
+	hdmi := catalog.Product{
+		SKU:      "hdmi",
+		Name:     "HDMI cable",
+		Price:    decimal.RequireFromString("20.00"),
+		Barcodes: []string{"4006381333931"},
+		Aliases:  []string{"BELKIN-F3Y021"},
+	}
+	assert.NoError(t, c.AddProduct(context.Background(), hdmi))
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "hdmi"},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	// Every code lands on the product's SKU, so rules see one product
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "4006381333931"}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "BELKIN-F3Y021"}))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "hdmi"}))
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "40.00 AUD", total.String())
+	assert.Equal(t, "hdmi", co.AuditTrail()[0].SKU)
+
+	assert.NoError(t, co.Remove("4006381333931"))
+	assert.NoError(t, co.SetQuantity("BELKIN-F3Y021", 4))
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines, 1)
+	assert.Equal(t, 4, receipt.Lines[0].Quantity)
+
+	var invalid internal.ErrInvalidBarcode
+	err = co.Scan(checkout.Item{SKU: "4006381333932"})
+	assert.True(t, errors.As(err, &invalid))
+	var unknown internal.ErrUnknownCode
+	err = co.Scan(checkout.Item{SKU: "9300000000002"})
+	assert.True(t, errors.As(err, &unknown))
 }
@@ -0,0 +1,150 @@
+package checkout
+
+import (
+	"slices"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/coupons"
+	"github.com/spa5k/zeller_go/internal/money"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+// WithCoupons sets the store the checkout looks coupon codes up in
+func WithCoupons(store *coupons.Store) Option {
+	return func(c *Checkout) {
+		c.coupons = store
+	}
+}
+
+// WithCustomer identifies the customer, for coupons limited per customer
+func WithCustomer(customer string) Option {
+	return func(c *Checkout) {
+		c.customer = customer
+	}
+}
+
+// ApplyCoupon adds a coupon to the checkout. The coupon must exist, must not
+// have expired or reached its use limits, and the basket must already reach
+// its minimum spend after the checkout's own rules. The coupon is only
+// redeemed when the checkout is finalised.
+func (c *Checkout) ApplyCoupon(code string) error {
+	if c.finalised {
+		return internal.NewCheckoutFinalisedError()
+	}
+	code = coupons.NormalizeCode(code)
+	if slices.Contains(c.appliedCoupons, code) {
+		return internal.NewCouponAlreadyAppliedError(code)
+	}
+	if c.coupons == nil {
+		return internal.NewCouponNotFoundError(code)
+	}
+	basket, claims, err := c.price()
+	if err != nil {
+		return err
+	}
+	coupon, err := c.coupons.Check(code, c.customer, basket.Time)
+	if err != nil {
+		return err
+	}
+	if err := c.checkCurrency(coupon); err != nil {
+		return err
+	}
+	if subtotal := basket.Total(claims); len(basket.Items) == 0 || subtotal.LessThan(coupon.MinSpend) {
+		return internal.NewCouponMinSpendError(code, coupon.MinSpend.String(), subtotal.String())
+	}
+	c.appliedCoupons = append(c.appliedCoupons, code)
+	c.record(ActionApplyCoupon, code, "")
+	return nil
+}
+
+// RemoveCoupon takes a previously applied coupon off the checkout
+func (c *Checkout) RemoveCoupon(code string) error {
+	if c.finalised {
+		return internal.NewCheckoutFinalisedError()
+	}
+	code = coupons.NormalizeCode(code)
+	index := slices.Index(c.appliedCoupons, code)
+	if index < 0 {
+		return internal.NewCouponNotAppliedError(code)
+	}
+	c.appliedCoupons = slices.Delete(c.appliedCoupons, index, index+1)
+	c.record(ActionRemoveCoupon, code, "")
+	return nil
+}
+
+// checkCurrency returns ErrCurrencyMismatch if the coupon's minimum spend is
+// in another currency than the catalog's, so it cannot be compared with the
+// basket
+func (c *Checkout) checkCurrency(coupon coupons.Coupon) error {
+	currency := c.catalog.Currency()
+	if !coupon.MinSpend.SameCurrency(money.Zero(currency)) {
+		return internal.NewCurrencyMismatchError(string(currency), string(coupon.MinSpend.Currency))
+	}
+	return nil
+}
+
+// usableCoupons returns the applied coupons that can still be used with the
+// basket as priced by the checkout's own rules. A coupon that has expired,
+// whose minimum spend is no longer met since it was applied, or that was
+// replaced by one with a minimum spend in another currency is left out. A
+// finalised checkout uses the coupons it redeemed, without checking them
+// again against limits they have since reached.
+func (c *Checkout) usableCoupons(basket pricingrules.Basket, claims []pricingrules.Claim) []coupons.Coupon {
+	if c.finalised {
+		return c.redeemed
+	}
+	if c.coupons == nil {
+		return nil
+	}
+	subtotal := basket.Total(claims)
+	var usable []coupons.Coupon
+	for _, code := range c.appliedCoupons {
+		coupon, err := c.coupons.Check(code, c.customer, basket.Time)
+		if err != nil || c.checkCurrency(coupon) != nil || len(basket.Items) == 0 || subtotal.LessThan(coupon.MinSpend) {
+			continue
+		}
+		usable = append(usable, coupon)
+	}
+	return usable
+}
+
+// Finalise prices the basket one last time, redeems every coupon that took
+// effect and, for a checkout selling from stock, takes the items and any free
+// gifts off hand; a gift that is out of stock is left off the receipt.
+// If any coupon can no longer be redeemed, none is and the error is returned.
+// A stock reservation that expired while the checkout was open is made again
+// first, failing with ErrOutOfStock if the stock has gone meanwhile; if the
+// stock cannot be taken off hand, the coupons are given back. Once the sale
+// has gone through the checkout cannot be changed or finalised again, and
+// trying fails with ErrCheckoutFinalised.
+func (c *Checkout) Finalise() (Receipt, error) {
+	if c.finalised {
+		return Receipt{}, internal.NewCheckoutFinalisedError()
+	}
+	receipt, err := c.Receipt()
+	if err != nil {
+		return Receipt{}, err
+	}
+	if err := c.reserve(c.items); err != nil {
+		return Receipt{}, err
+	}
+	if receipt, err = c.reserveGifts(receipt); err != nil {
+		return Receipt{}, err
+	}
+	if len(receipt.Coupons) > 0 {
+		if err := c.coupons.Redeem(c.customer, receipt.Time, receipt.Coupons...); err != nil {
+			return Receipt{}, err
+		}
+	}
+	if c.inventory != nil && len(c.items) > 0 {
+		if err := c.inventory.Commit(c.reservation, c.clock.Now()); err != nil {
+			if len(receipt.Coupons) > 0 {
+				c.coupons.Unredeem(c.customer, receipt.Coupons...)
+			}
+			return Receipt{}, err
+		}
+	}
+	c.finalised = true
+	c.redeemed = receipt.applied
+	return receipt, nil
+}
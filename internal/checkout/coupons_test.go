@@ -0,0 +1,225 @@
+package checkout_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/checkout"
+	"github.com/spa5k/zeller_go/internal/coupons"
+	"github.com/spa5k/zeller_go/internal/money"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+var couponTime = time.Date(2024, 11, 29, 12, 0, 0, 0, time.UTC)
+
+func couponStore() *coupons.Store {
+	return coupons.NewStore(
+		coupons.Coupon{
+			Code: "ATV15",
+			Rule: &pricingrules.PercentOffRule{Name: "ATV15", SKU: "atv", Percent: decimal.NewFromInt(15)},
+		},
+		coupons.Coupon{
+			Code:       "BIG5",
+			BasketRule: &pricingrules.SpendDiscountRule{Name: "BIG5", Percent: decimal.NewFromInt(5)},
+			MinSpend:   aud("1000.00"),
+			MaxUses:    1,
+		},
+		coupons.Coupon{Code: "OLD", Rule: &pricingrules.ThreeForTwoRule{SKU: "atv"}, ExpiresAt: couponTime},
+	)
+}
+
+func scanAll(t *testing.T, co *checkout.Checkout, skus ...string) {
+	for _, sku := range skus {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+}
+
+func TestCheckout_ApplyCoupon(t *testing.T) {
+	c := catalog.NewCatalog()
+	co := checkout.NewCheckout(nil, c, checkout.WithCoupons(couponStore()), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "atv", "atv")
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "219.00 AUD", total.String())
+
+	assert.NoError(t, co.ApplyCoupon("atv15"))
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ATV15"}, receipt.Coupons)
+	assert.Equal(t, "ATV15", receipt.Lines[0].Adjustments[0].Rule)
+	assert.Equal(t, "186.15 AUD", receipt.Total.String())
+
+	assert.NoError(t, co.RemoveCoupon("ATV15"))
+	total, err = co.Total()
+	assert.NoError(t, err)
+	assert.Equal(t, "219.00 AUD", total.String())
+}
+
+func TestCheckout_ApplyCoupon_Errors(t *testing.T) {
+	c := catalog.NewCatalog()
+	co := checkout.NewCheckout(nil, c, checkout.WithCoupons(couponStore()), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "ipd")
+
+	var notFound internal.ErrCouponNotFound
+	assert.True(t, errors.As(co.ApplyCoupon("NOPE"), &notFound))
+
+	var expired internal.ErrCouponExpired
+	assert.True(t, errors.As(co.ApplyCoupon("OLD"), &expired))
+
+	err := co.ApplyCoupon("BIG5")
+	var minSpend internal.ErrCouponMinSpend
+	assert.True(t, errors.As(err, &minSpend))
+	assert.EqualError(t, err, "coupon BIG5 needs a minimum spend of 1000.00 AUD, basket is 549.99 AUD")
+
+	assert.NoError(t, co.ApplyCoupon("ATV15"))
+	var applied internal.ErrCouponAlreadyApplied
+	assert.True(t, errors.As(co.ApplyCoupon(" atv15"), &applied))
+
+	var notApplied internal.ErrCouponNotApplied
+	assert.True(t, errors.As(co.RemoveCoupon("BIG5"), &notApplied))
+
+	withoutStore := checkout.NewCheckout(nil, c)
+	assert.True(t, errors.As(withoutStore.ApplyCoupon("ATV15"), &notFound))
+}
+
+func TestCheckout_ApplyCoupon_PerCustomerNeedsCustomer(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := coupons.NewStore(coupons.Coupon{
+		Code:               "FIRSTORDER",
+		Rule:               &pricingrules.PercentOffRule{SKU: "atv", Percent: decimal.NewFromInt(10)},
+		MaxUsesPerCustomer: 1,
+	})
+	co := checkout.NewCheckout(nil, c, checkout.WithCoupons(store), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "atv")
+
+	var required internal.ErrCouponCustomerRequired
+	assert.True(t, errors.As(co.ApplyCoupon("FIRSTORDER"), &required))
+
+	co = checkout.NewCheckout(nil, c, checkout.WithCoupons(store), checkout.WithCustomer("alice"), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "atv")
+	assert.NoError(t, co.ApplyCoupon("FIRSTORDER"))
+}
+
+func TestCheckout_ApplyCoupon_CurrencyMismatch(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := coupons.NewStore(coupons.Coupon{Code: "USD10", Rule: &pricingrules.PercentOffRule{SKU: "atv", Percent: decimal.NewFromInt(10)}, MinSpend: money.MustParse("10", money.USD)})
+	co := checkout.NewCheckout(nil, c, checkout.WithCoupons(store), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "atv")
+
+	err := co.ApplyCoupon("USD10")
+	var mismatch internal.ErrCurrencyMismatch
+	assert.True(t, errors.As(err, &mismatch))
+	assert.EqualError(t, err, "currency mismatch: expected AUD, got USD")
+
+	// A coupon replaced after it was applied no longer takes effect
+	store.Add(coupons.Coupon{Code: "USD10", Rule: &pricingrules.PercentOffRule{SKU: "atv", Percent: decimal.NewFromInt(10)}})
+	assert.NoError(t, co.ApplyCoupon("USD10"))
+	store.Add(coupons.Coupon{Code: "USD10", Rule: &pricingrules.PercentOffRule{SKU: "atv", Percent: decimal.NewFromInt(10)}, MinSpend: money.MustParse("10", money.USD)})
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Empty(t, receipt.Coupons)
+	assert.Equal(t, "109.50 AUD", receipt.Total.String())
+}
+
+func TestCheckout_Finalise_RedeemsCoupons(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := couponStore()
+	open := func() *checkout.Checkout {
+		co := checkout.NewCheckout(nil, c, checkout.WithCoupons(store), checkout.WithCustomer("alice"), checkout.WithClock(internal.FixedClock(couponTime)))
+		scanAll(t, co, "mbp")
+		return co
+	}
+
+	first := open()
+	assert.NoError(t, first.ApplyCoupon("BIG5"))
+	second := open()
+	assert.NoError(t, second.ApplyCoupon("BIG5"))
+
+	receipt, err := first.Finalise()
+	assert.NoError(t, err)
+	assert.Equal(t, "1329.99 AUD", receipt.Total.String())
+	assert.Equal(t, 1, store.Uses("BIG5"))
+
+	// The single-use coupon was redeemed by the first checkout in the meantime
+	receipt, err = second.Receipt()
+	assert.NoError(t, err)
+	assert.Empty(t, receipt.Coupons)
+	assert.Equal(t, "1399.99 AUD", receipt.Total.String())
+}
+
+func TestCheckout_ReceiptAt_KeepsRedeemedCoupons(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := coupons.NewStore(coupons.Coupon{Code: "HALF", Rule: &pricingrules.PercentOffRule{SKU: "atv", Percent: decimal.NewFromInt(50)}, MaxUses: 1})
+	co := checkout.NewCheckout(nil, c, checkout.WithCoupons(store), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "atv")
+	assert.NoError(t, co.ApplyCoupon("HALF"))
+
+	receipt, err := co.Finalise()
+	assert.NoError(t, err)
+	assert.Equal(t, "54.75 AUD", receipt.Total.String())
+
+	// The coupon has reached its limit by being redeemed, yet a refund
+	// priced at the time of sale still gets its discount
+	refund, err := co.ReceiptAt(receipt.Time)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"HALF"}, refund.Coupons)
+	assert.Equal(t, "54.75 AUD", refund.Total.String())
+	assert.Equal(t, 1, store.Uses("HALF"))
+}
+
+func TestCheckout_Finalise_Once(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := couponStore()
+	co := checkout.NewCheckout(nil, c, checkout.WithCoupons(store), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "mbp", "atv")
+	assert.NoError(t, co.ApplyCoupon("ATV15"))
+
+	receipt, err := co.Finalise()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, store.Uses("ATV15"))
+
+	// The sale has gone through, so nothing about it can change
+	var finalised internal.ErrCheckoutFinalised
+	_, err = co.Finalise()
+	assert.True(t, errors.As(err, &finalised))
+	assert.EqualError(t, err, "checkout is already finalised")
+	assert.Equal(t, 1, store.Uses("ATV15"))
+	assert.True(t, errors.As(co.Scan(checkout.Item{SKU: "vga"}), &finalised))
+	assert.True(t, errors.As(co.SetQuantity("atv", 3), &finalised))
+	assert.True(t, errors.As(co.ApplyCoupon("BIG5"), &finalised))
+	assert.True(t, errors.As(co.RemoveCoupon("ATV15"), &finalised))
+	assert.True(t, errors.As(co.Remove("mbp"), &finalised))
+	assert.True(t, errors.As(co.Void("atv", checkout.VoidChangedMind), &finalised))
+
+	trail := co.AuditTrail()
+	co.Abandon()
+	assert.Equal(t, trail, co.AuditTrail())
+	again, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, receipt.Total.String(), again.Total.String())
+}
+
+func TestCheckout_CouponDroppedBelowMinSpend(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 3, NewPrice: aud("300.00")},
+	}
+	co := checkout.NewCheckout(pricingRules, c, checkout.WithCoupons(couponStore()), checkout.WithClock(internal.FixedClock(couponTime)))
+	scanAll(t, co, "ipd", "ipd")
+	assert.NoError(t, co.ApplyCoupon("BIG5"))
+
+	// A third iPad triggers the bulk price, taking the subtotal below the minimum spend
+	scanAll(t, co, "ipd")
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Empty(t, receipt.Coupons)
+	assert.Equal(t, "900.00 AUD", receipt.Total.String())
+}
@@ -0,0 +1,100 @@
+package checkout
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/inventory"
+)
+
+// reservations numbers the checkouts' stock reservations
+var reservations atomic.Uint64
+
+// WithInventory makes the checkout sell from the stock at location. Scanned
+// items are reserved as they are scanned, so an item that is out of stock is
+// refused with ErrOutOfStock, and the reservation is committed when the
+// checkout is finalised or released when it is abandoned. Free gifts are
+// reserved and committed with the sale, and left out if none are in stock.
+func WithInventory(store *inventory.Store, location string) Option {
+	return func(c *Checkout) {
+		c.inventory = store
+		c.location = location
+		c.reservation = fmt.Sprintf("checkout-%d", reservations.Add(1))
+	}
+}
+
+// quantities returns how much of each SKU items hold, in whole items or the
+// product's unit of measure
+func quantities(items []Item) map[string]decimal.Decimal {
+	held := make(map[string]decimal.Decimal)
+	for _, item := range items {
+		quantity := item.Measure
+		if quantity.IsZero() {
+			quantity = decimal.NewFromInt(int64(item.Quantity))
+		}
+		held[item.SKU] = held[item.SKU].Add(quantity)
+	}
+	return held
+}
+
+// reserve makes the checkout's reservation hold the stock for items, or
+// returns ErrOutOfStock and leaves it as it was
+func (c *Checkout) reserve(items []Item) error {
+	if c.inventory == nil {
+		return nil
+	}
+	return c.inventory.Reserve(c.reservation, c.location, quantities(items), c.clock.Now())
+}
+
+// reserveGifts adds the receipt's free gifts to the checkout's reservation,
+// which must already hold its items. A gift whose stock has run out is taken
+// off the receipt rather than holding up the sale.
+func (c *Checkout) reserveGifts(receipt Receipt) (Receipt, error) {
+	if c.inventory == nil || len(receipt.Gifts) == 0 {
+		return receipt, nil
+	}
+	held := quantities(c.items)
+	for i := 0; i < len(receipt.Gifts); {
+		sku := receipt.Gifts[i].SKU
+		before := held[sku]
+		held[sku] = before.Add(decimal.NewFromInt(1))
+		err := c.inventory.Reserve(c.reservation, c.location, held, c.clock.Now())
+		var outOfStock internal.ErrOutOfStock
+		switch {
+		case errors.As(err, &outOfStock):
+			held[sku] = before
+			receipt.dropGift(i)
+		case err != nil:
+			return Receipt{}, err
+		default:
+			i++
+		}
+	}
+	return receipt, nil
+}
+
+// unreserve shrinks the checkout's reservation to what it holds after items
+// were taken out. Taking items out never needs more stock, but if the
+// reservation has expired it cannot be renewed while the stock is gone;
+// Finalise reserves again and reports that then.
+func (c *Checkout) unreserve() {
+	_ = c.reserve(c.items)
+}
+
+// Abandon empties the checkout and releases any stock it had reserved. A
+// finalised checkout is left as it is.
+func (c *Checkout) Abandon() {
+	if c.finalised {
+		return
+	}
+	c.items = nil
+	c.appliedCoupons = nil
+	if c.inventory != nil {
+		c.inventory.Release(c.reservation)
+	}
+	c.record(ActionAbandon, "", "")
+}
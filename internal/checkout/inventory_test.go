@@ -0,0 +1,181 @@
+package checkout_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/checkout"
+	"github.com/spa5k/zeller_go/internal/inventory"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func stockStore(t *testing.T, levels map[string]int64) *inventory.Store {
+	store := inventory.NewStore()
+	for sku, n := range levels {
+		assert.NoError(t, store.SetStock("sydney", sku, decimal.NewFromInt(n)))
+	}
+	return store
+}
+
+func TestCheckout_ReservesStock(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := stockStore(t, map[string]int64{"mbp": 2, "vga": 5})
+	clock := internal.FixedClock(couponTime)
+	co := checkout.NewCheckout(nil, c, checkout.WithInventory(store, "sydney"), checkout.WithClock(clock))
+
+	scanAll(t, co, "mbp", "vga", "mbp")
+	assert.Equal(t, "2", store.Level("sydney", "mbp", couponTime).Reserved.String())
+
+	var outOfStock internal.ErrOutOfStock
+	err := co.Scan(checkout.Item{SKU: "mbp"})
+	assert.True(t, errors.As(err, &outOfStock))
+	assert.EqualError(t, err, "product mbp is out of stock at sydney: 3 requested, 2 available")
+	assert.True(t, errors.As(co.SetQuantity("vga", 6), &outOfStock))
+
+	// Stock taken out of the checkout is released at once
+	other := checkout.NewCheckout(nil, c, checkout.WithInventory(store, "sydney"), checkout.WithClock(clock))
+	assert.NoError(t, co.Remove("mbp"))
+	assert.NoError(t, other.Scan(checkout.Item{SKU: "mbp"}))
+	assert.NoError(t, co.Void("vga", checkout.VoidChangedMind))
+	assert.NoError(t, other.SetQuantity("vga", 5))
+
+	receipt, err := co.Finalise()
+	assert.NoError(t, err)
+	assert.Equal(t, "1399.99 AUD", receipt.Total.String())
+	level := store.Level("sydney", "mbp", couponTime)
+	assert.Equal(t, "1", level.OnHand.String())
+	assert.Equal(t, "1", level.Reserved.String())
+
+	// Abandoning a checkout gives its stock back
+	other.Abandon()
+	assert.Equal(t, "0", store.Level("sydney", "vga", couponTime).Reserved.String())
+	assert.Equal(t, "0", store.Level("sydney", "mbp", couponTime).Reserved.String())
+	total, err := other.Total()
+	assert.NoError(t, err)
+	assert.True(t, total.IsZero())
+	trail := other.AuditTrail()
+	assert.Equal(t, checkout.ActionAbandon, trail[len(trail)-1].Action)
+}
+
+func TestCheckout_ReservationExpires(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := inventory.NewStore(inventory.WithTTL(10 * time.Minute))
+	assert.NoError(t, store.SetStock("sydney", "atv", decimal.NewFromInt(1)))
+	now := couponTime
+	clock := internal.ClockFunc(func() time.Time { return now })
+
+	slow := checkout.NewCheckout(nil, c, checkout.WithInventory(store, "sydney"), checkout.WithClock(clock))
+	assert.NoError(t, slow.Scan(checkout.Item{SKU: "atv"}))
+
+	// Once the reservation has expired, another checkout can have the stock
+	now = now.Add(10 * time.Minute)
+	fast := checkout.NewCheckout(nil, c, checkout.WithInventory(store, "sydney"), checkout.WithClock(clock))
+	assert.NoError(t, fast.Scan(checkout.Item{SKU: "atv"}))
+	_, err := slow.Finalise()
+	var outOfStock internal.ErrOutOfStock
+	assert.True(t, errors.As(err, &outOfStock))
+
+	// and an expired checkout whose stock is still there sells it
+	fast.Abandon()
+	_, err = slow.Finalise()
+	assert.NoError(t, err)
+	assert.True(t, store.Level("sydney", "atv", now).OnHand.IsZero())
+}
+
+func TestCheckout_FinaliseTakesGiftsFromStock(t *testing.T) {
+	c := catalog.NewCatalog()
+	gift := []pricingrules.BasketRule{&pricingrules.FreeGiftRule{Name: "Free adapter", MinSpend: aud("1500.00"), SKU: "vga"}}
+	stock := stockStore(t, map[string]int64{"mbp": 3, "atv": 3, "vga": 2})
+	sell := func(skus ...string) checkout.Receipt {
+		co := checkout.NewCheckout(nil, c, checkout.WithInventory(stock, "sydney"), checkout.WithBasketRules(gift...), checkout.WithClock(internal.FixedClock(couponTime)))
+		scanAll(t, co, skus...)
+		receipt, err := co.Finalise()
+		assert.NoError(t, err)
+		return receipt
+	}
+
+	receipt := sell("mbp", "atv")
+	assert.Len(t, receipt.Gifts, 1)
+	assert.Equal(t, "vga", receipt.Lines[2].SKU)
+	assert.Equal(t, "1", stock.Level("sydney", "vga", couponTime).OnHand.String())
+
+	// The last adapter is sold, so the next order goes without its gift
+	// rather than failing, and pays the same
+	receipt = sell("mbp", "atv", "vga")
+	assert.Len(t, receipt.Gifts, 0)
+	assert.Equal(t, 1, receipt.Lines[2].Quantity)
+	assert.Equal(t, "30.00 AUD", receipt.Lines[2].Gross.String())
+	assert.Empty(t, receipt.Lines[2].Adjustments)
+	assert.Equal(t, "1539.49 AUD", receipt.Subtotal.String())
+	assert.Equal(t, "1539.49 AUD", receipt.Total.String())
+	assert.Equal(t, "0", stock.Level("sydney", "vga", couponTime).OnHand.String())
+
+	receipt = sell("mbp", "atv")
+	assert.Len(t, receipt.Gifts, 0)
+	assert.Len(t, receipt.Lines, 2)
+	assert.True(t, receipt.Adjustments.IsZero())
+	assert.Equal(t, "1509.49 AUD", receipt.Total.String())
+	assert.Equal(t, "0", stock.Level("sydney", "mbp", couponTime).OnHand.String())
+	assert.Equal(t, "0", stock.Level("sydney", "vga", couponTime).Reserved.String())
+}
+
+func TestCheckout_FailedCommitGivesCouponsBack(t *testing.T) {
+	c := catalog.NewCatalog()
+	coupons := couponStore()
+	// Every reservation has expired by the next time the clock is read, so
+	// committing the stock fails after the coupons were redeemed
+	stock := inventory.NewStore(inventory.WithTTL(time.Nanosecond))
+	assert.NoError(t, stock.SetStock("sydney", "mbp", decimal.NewFromInt(1)))
+	now := couponTime
+	clock := internal.ClockFunc(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	co := checkout.NewCheckout(nil, c, checkout.WithInventory(stock, "sydney"), checkout.WithCoupons(coupons), checkout.WithClock(clock))
+	scanAll(t, co, "mbp")
+	assert.NoError(t, co.ApplyCoupon("BIG5"))
+
+	_, err := co.Finalise()
+	var notFound internal.ErrReservationNotFound
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, 0, coupons.Uses("BIG5"))
+	assert.Equal(t, "1", stock.Level("sydney", "mbp", now).OnHand.String())
+
+	// The checkout is still open
+	assert.NoError(t, co.Remove("mbp"))
+}
+
+func TestCheckout_ConcurrentStock(t *testing.T) {
+	c := catalog.NewCatalog()
+	store := stockStore(t, map[string]int64{"ipd": 20})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sold := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			co := checkout.NewCheckout(nil, c, checkout.WithInventory(store, "sydney"))
+			if co.Scan(checkout.Item{SKU: "ipd", Quantity: 3}) != nil {
+				return
+			}
+			if _, err := co.Finalise(); err == nil {
+				mu.Lock()
+				sold += 3
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 18, sold)
+	assert.Equal(t, "2", store.Level("sydney", "ipd", time.Now()).OnHand.String())
+}
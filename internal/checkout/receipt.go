@@ -0,0 +1,314 @@
+package checkout
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/coupons"
+	"github.com/spa5k/zeller_go/internal/money"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+// Line is the receipt entry for every scanned item of one SKU
+type Line struct {
+	SKU  string
+	Name string
+	// Quantity is the number of whole items, or of measured amounts for a
+	// product sold by measure
+	Quantity int
+	// Measure is the total measured amount in Unit for a product sold by measure
+	Measure decimal.Decimal
+	Unit    catalog.Unit
+	// UnitPrice is the price per item, or per unit of measure
+	UnitPrice money.Money
+	// Gross is the quantity charged at the unit price
+	Gross money.Money
+	// Adjustments holds one entry per pricing rule that changed the price of
+	// this line, in the order the rules first applied
+	Adjustments []pricingrules.Adjustment
+	// Net is Gross plus all adjustments, before rounding
+	Net money.Money
+}
+
+// Receipt is an itemised breakdown of a checkout total
+type Receipt struct {
+	Lines []Line
+	// Subtotal is the sum of every line's gross amount
+	Subtotal money.Money
+	// OrderAdjustments holds the adjustments basket rules made to the order as
+	// a whole rather than to any one line
+	OrderAdjustments []pricingrules.Adjustment
+	// Adjustments is the sum of every adjustment on every line and order
+	Adjustments money.Money
+	// Rounding is the amount added or removed by rounding the lines or basket
+	Rounding money.Money
+	Total    money.Money
+	// Time is the moment the basket was priced at
+	Time time.Time
+	// Coupons lists the codes of the coupons that took effect
+	Coupons []string
+	// Gifts lists the free items basket rules added to the order, which are
+	// also on the lines
+	Gifts []pricingrules.Gift
+	// applied holds the coupons listed in Coupons
+	applied []coupons.Coupon
+	// Assignment explains which rule priced which items when the checkout
+	// uses the optimiser, and is nil otherwise
+	Assignment *pricingrules.Assignment
+}
+
+// basket builds the basket of scanned items as priced at time at, with one
+// basket item per scan holding its whole items or its measured amount. Every
+// item is priced from the same catalog snapshot, so an update made meanwhile
+// cannot leave the basket with a mix of old and new prices.
+func (c *Checkout) basket(products *catalog.Snapshot, at time.Time) (pricingrules.Basket, error) {
+	basket := pricingrules.Basket{Time: at}
+	for _, item := range c.items {
+		product, err := products.GetProduct(item.SKU)
+		if err != nil {
+			return pricingrules.Basket{}, err
+		}
+		quantity := decimal.NewFromInt(int64(item.Quantity))
+		if product.Unit.Measured() {
+			quantity = item.Measure
+		}
+		basket.Items = append(basket.Items, pricingrules.Item{
+			SKU:      item.SKU,
+			Parent:   product.Parent,
+			Category: product.Category,
+			Brand:    product.Brand,
+			Tags:     product.Tags,
+			Price:    money.New(product.PriceAt(at), products.Currency()),
+			Quantity: quantity,
+			Unit:     product.Unit,
+		})
+	}
+	return basket, nil
+}
+
+// price builds the basket and applies the checkout's own item rules to it
+func (c *Checkout) price() (pricingrules.Basket, []pricingrules.Claim, error) {
+	basket, err := c.basket(c.catalog.Snapshot(), c.clock.Now())
+	if err != nil {
+		return pricingrules.Basket{}, nil, err
+	}
+	claims, _, err := c.applyRules(basket, c.pricingRules)
+	if err != nil {
+		return pricingrules.Basket{}, nil, err
+	}
+	return basket, claims, nil
+}
+
+// applyRules prices the basket with the given item rules, each item being
+// claimed by at most one rule. With the optimiser enabled the assignment it
+// chose is returned too.
+func (c *Checkout) applyRules(basket pricingrules.Basket, rules []pricingrules.PricingRule) ([]pricingrules.Claim, *pricingrules.Assignment, error) {
+	if c.optimise {
+		assignment, err := pricingrules.Optimise(basket, rules, c.catalog, c.searchBudget)
+		if err != nil {
+			return nil, nil, err
+		}
+		return assignment.Claims(), &assignment, nil
+	}
+	set := &pricingrules.RuleSet{Policy: pricingrules.Exclusive, Rules: rules}
+	claims, err := set.Apply(basket, c.catalog)
+	return claims, nil, err
+}
+
+// Receipt prices the basket now and breaks the result down per SKU, in the
+// order the SKUs were first scanned
+func (c *Checkout) Receipt() (Receipt, error) {
+	return c.ReceiptAt(c.clock.Now())
+}
+
+// ReceiptAt prices the basket as it would have been priced at time at, using
+// the catalog prices and promotions in effect then. Pricing a refund or an
+// audit at the original receipt's Time reproduces the original prices even
+// after they have changed. Once the checkout is finalised, the coupons it
+// redeemed keep applying, although they have since used up their limits.
+func (c *Checkout) ReceiptAt(at time.Time) (Receipt, error) {
+	products := c.catalog.Snapshot()
+	basket, err := c.basket(products, at)
+	if err != nil {
+		return Receipt{}, err
+	}
+	claims, assignment, err := c.applyRules(basket, c.pricingRules)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	// Coupon rules take the items the checkout's own rules left unclaimed
+	coupons := c.usableCoupons(basket, claims)
+	var couponRules []pricingrules.PricingRule
+	basketRules := c.basketRules
+	for _, coupon := range coupons {
+		if coupon.Rule != nil {
+			couponRules = append(couponRules, coupon.Rule)
+		}
+		if coupon.BasketRule != nil {
+			basketRules = append(basketRules[:len(basketRules):len(basketRules)], coupon.BasketRule)
+		}
+	}
+	if len(couponRules) > 0 {
+		rules := append(append([]pricingrules.PricingRule(nil), c.pricingRules...), couponRules...)
+		if claims, assignment, err = c.applyRules(basket, rules); err != nil {
+			return Receipt{}, err
+		}
+	}
+
+	currency := products.Currency()
+	receipt := Receipt{
+		Subtotal:    money.Zero(currency),
+		Adjustments: money.Zero(currency),
+		Time:        basket.Time,
+		Assignment:  assignment,
+	}
+	for _, coupon := range coupons {
+		receipt.Coupons = append(receipt.Coupons, coupon.Code)
+	}
+	receipt.applied = coupons
+	lineOf := make(map[string]int)
+	lineFor := func(item pricingrules.Item) (*Line, error) {
+		index, ok := lineOf[item.SKU]
+		if !ok {
+			product, err := products.GetProduct(item.SKU)
+			if err != nil {
+				return nil, err
+			}
+			index = len(receipt.Lines)
+			lineOf[item.SKU] = index
+			receipt.Lines = append(receipt.Lines, Line{
+				SKU:       item.SKU,
+				Name:      product.Name,
+				Unit:      product.Unit,
+				UnitPrice: item.Price,
+				Gross:     money.Zero(currency),
+				Net:       money.Zero(currency),
+			})
+		}
+		return &receipt.Lines[index], nil
+	}
+
+	prices := basket.Prices(claims)
+	adjustments := basket.Adjustments(claims)
+	for i, item := range basket.Items {
+		line, err := lineFor(item)
+		if err != nil {
+			return Receipt{}, err
+		}
+		if item.Measured() {
+			line.Quantity++
+			line.Measure = line.Measure.Add(item.Quantity)
+		} else {
+			line.Quantity += int(item.Units().IntPart())
+		}
+		line.Gross = line.Gross.Add(item.Amount())
+		line.Net = line.Net.Add(prices[i])
+		for _, adjustment := range adjustments[i] {
+			line.addAdjustment(adjustment)
+			receipt.Adjustments = receipt.Adjustments.Add(adjustment.Amount)
+		}
+	}
+
+	// Basket rules see the order as the item rules left it
+	priced := pricingrules.PricedBasket{Basket: basket, Prices: prices, Products: products}
+	for _, rule := range basketRules {
+		result, err := rule.ApplyToBasket(priced, c.catalog)
+		if err != nil {
+			return Receipt{}, err
+		}
+		for _, gift := range result.Gifts {
+			line, err := lineFor(pricingrules.Item{SKU: gift.SKU, Price: gift.Price})
+			if err != nil {
+				return Receipt{}, err
+			}
+			discount := money.Zero(currency).Sub(gift.Price)
+			line.Quantity++
+			line.Gross = line.Gross.Add(gift.Price)
+			line.addAdjustment(pricingrules.Adjustment{Rule: gift.Rule, Amount: discount})
+			receipt.Adjustments = receipt.Adjustments.Add(discount)
+			receipt.Gifts = append(receipt.Gifts, gift)
+		}
+		for _, adjustment := range result.Adjustments {
+			priced.Adjustments = append(priced.Adjustments, adjustment)
+			receipt.OrderAdjustments = append(receipt.OrderAdjustments, adjustment)
+			receipt.Adjustments = receipt.Adjustments.Add(adjustment.Amount)
+		}
+	}
+
+	nets := make([]money.Money, 0, len(receipt.Lines)+len(receipt.OrderAdjustments))
+	unrounded := money.Zero(currency)
+	for _, line := range receipt.Lines {
+		receipt.Subtotal = receipt.Subtotal.Add(line.Gross)
+		nets = append(nets, line.Net)
+		unrounded = unrounded.Add(line.Net)
+	}
+	for _, adjustment := range receipt.OrderAdjustments {
+		nets = append(nets, adjustment.Amount)
+		unrounded = unrounded.Add(adjustment.Amount)
+	}
+	receipt.Total = c.rounding.Total(currency, nets)
+	receipt.Rounding = receipt.Total.Sub(unrounded)
+	return receipt, nil
+}
+
+// addAdjustment merges an adjustment into the line, adding it to any earlier
+// adjustment made by the same rule
+func (l *Line) addAdjustment(adjustment pricingrules.Adjustment) {
+	for i := range l.Adjustments {
+		if l.Adjustments[i].Rule == adjustment.Rule {
+			l.Adjustments[i].Amount = l.Adjustments[i].Amount.Add(adjustment.Amount)
+			return
+		}
+	}
+	l.Adjustments = append(l.Adjustments, adjustment)
+}
+
+// dropGift takes the i-th free gift off the receipt. A gift is charged at
+// nothing, so the total does not change.
+func (r *Receipt) dropGift(i int) {
+	gift := r.Gifts[i]
+	r.Gifts = slices.Delete(r.Gifts, i, i+1)
+	index := slices.IndexFunc(r.Lines, func(line Line) bool { return line.SKU == gift.SKU })
+	line := &r.Lines[index]
+	line.Quantity--
+	line.Gross = line.Gross.Sub(gift.Price)
+	line.addAdjustment(pricingrules.Adjustment{Rule: gift.Rule, Amount: gift.Price})
+	line.Adjustments = slices.DeleteFunc(line.Adjustments, func(adjustment pricingrules.Adjustment) bool {
+		return adjustment.Rule == gift.Rule && adjustment.Amount.IsZero()
+	})
+	r.Subtotal = r.Subtotal.Sub(gift.Price)
+	r.Adjustments = r.Adjustments.Add(gift.Price)
+	if line.Quantity == 0 {
+		r.Lines = slices.Delete(r.Lines, index, index+1)
+	}
+}
+
+// String renders the receipt as plain text for a cashier's display
+func (r Receipt) String() string {
+	var b strings.Builder
+	for _, line := range r.Lines {
+		quantity := fmt.Sprint(line.Quantity)
+		if line.Unit.Measured() {
+			quantity = line.Measure.String() + string(line.Unit)
+		}
+		fmt.Fprintf(&b, "%-20s %3s x %12s %14s\n", line.Name, quantity, line.UnitPrice, line.Gross)
+		for _, adjustment := range line.Adjustments {
+			fmt.Fprintf(&b, "  %-36s %14s\n", adjustment.Rule, adjustment.Amount)
+		}
+	}
+	for _, adjustment := range r.OrderAdjustments {
+		fmt.Fprintf(&b, "%-38s %14s\n", adjustment.Rule, adjustment.Amount)
+	}
+	fmt.Fprintf(&b, "%-38s %14s\n", "Subtotal", r.Subtotal)
+	fmt.Fprintf(&b, "%-38s %14s\n", "Discounts", r.Adjustments)
+	if !r.Rounding.IsZero() {
+		fmt.Fprintf(&b, "%-38s %14s\n", "Rounding", r.Rounding)
+	}
+	fmt.Fprintf(&b, "%-38s %14s\n", "Total", r.Total)
+	return b.String()
+}
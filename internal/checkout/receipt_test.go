@@ -0,0 +1,289 @@
+package checkout_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/checkout"
+	"github.com/spa5k/zeller_go/internal/money"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func TestReceipt_Scenario2(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for _, sku := range []string{"atv", "ipd", "ipd", "atv", "ipd", "ipd", "ipd"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines, 2)
+
+	atv := receipt.Lines[0]
+	assert.Equal(t, "atv", atv.SKU)
+	assert.Equal(t, "Apple TV", atv.Name)
+	assert.Equal(t, 2, atv.Quantity)
+	assert.Equal(t, "109.50 AUD", atv.UnitPrice.String())
+	assert.Equal(t, "219.00 AUD", atv.Gross.String())
+	assert.Empty(t, atv.Adjustments)
+	assert.Equal(t, "219.00 AUD", atv.Net.String())
+
+	ipd := receipt.Lines[1]
+	assert.Equal(t, "ipd", ipd.SKU)
+	assert.Equal(t, 5, ipd.Quantity)
+	assert.Equal(t, "2749.95 AUD", ipd.Gross.String())
+	assert.Len(t, ipd.Adjustments, 1)
+	assert.Equal(t, "Bulk discount on ipd", ipd.Adjustments[0].Rule)
+	assert.Equal(t, "-250.00 AUD", ipd.Adjustments[0].Amount.String())
+	assert.Equal(t, "2499.95 AUD", ipd.Net.String())
+
+	assert.Equal(t, "2968.95 AUD", receipt.Subtotal.String())
+	assert.Equal(t, "-250.00 AUD", receipt.Adjustments.String())
+	assert.True(t, receipt.Rounding.IsZero())
+	assert.Equal(t, "2718.95 AUD", receipt.Total.String())
+
+	total, err := co.Total()
+	assert.NoError(t, err)
+	assert.True(t, receipt.Total.Equal(total))
+}
+
+func TestReceipt_NamedRule(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{Name: "Opening day 3 for 2", SKU: "atv"},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for _, sku := range []string{"atv", "atv", "atv", "vga"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines[0].Adjustments, 1)
+	assert.Equal(t, "Opening day 3 for 2", receipt.Lines[0].Adjustments[0].Rule)
+	assert.Equal(t, "-109.50 AUD", receipt.Lines[0].Adjustments[0].Amount.String())
+	assert.Empty(t, receipt.Lines[1].Adjustments)
+	assert.Equal(t, "249.00 AUD", receipt.Total.String())
+}
+
+func TestReceipt_StackedRulesReportEachAdjustment(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.RuleSet{
+			Policy: pricingrules.Sequential,
+			Rules: []pricingrules.PricingRule{
+				&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+				&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+			},
+		},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for i := 0; i < 6; i++ {
+		err := co.Scan(checkout.Item{SKU: "ipd"})
+		assert.NoError(t, err)
+	}
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines, 1)
+
+	line := receipt.Lines[0]
+	assert.Len(t, line.Adjustments, 2)
+	assert.Equal(t, "Bulk discount on ipd", line.Adjustments[0].Rule)
+	assert.Equal(t, "-300.00 AUD", line.Adjustments[0].Amount.String())
+	assert.Equal(t, "3 for 2 on ipd", line.Adjustments[1].Rule)
+	assert.Equal(t, "-999.98 AUD", line.Adjustments[1].Amount.String())
+	assert.Equal(t, "1999.96 AUD", line.Net.String())
+}
+
+func TestReceipt_BundleDiscountSplitAcrossLines(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+	}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for _, sku := range []string{"ipd", "atv"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines, 2)
+	for _, line := range receipt.Lines {
+		assert.Len(t, line.Adjustments, 1)
+		assert.Equal(t, "Bundle ipd + atv", line.Adjustments[0].Rule)
+		assert.True(t, line.Adjustments[0].Amount.IsNegative())
+	}
+	assert.Equal(t, "-60.49 AUD", receipt.Adjustments.String())
+	assert.Equal(t, "599.00 AUD", receipt.Total.String())
+}
+
+func TestReceipt_RoundingLine(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{
+		&pricingrules.BulkDiscountRule{SKU: "atv", MinQuantity: 1, NewPrice: aud("33.335")},
+	}
+	co := checkout.NewCheckout(pricingRules, c, checkout.WithRounding(money.Rounding{Mode: money.HalfUp, Point: money.PerLine}))
+
+	err := co.Scan(checkout.Item{SKU: "atv"})
+	assert.NoError(t, err)
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, "33.335 AUD", receipt.Lines[0].Net.String())
+	assert.Equal(t, "0.005 AUD", receipt.Rounding.String())
+	assert.Equal(t, "33.34 AUD", receipt.Total.String())
+}
+
+func TestReceipt_Empty(t *testing.T) {
+	co := checkout.NewCheckout(nil, catalog.NewCatalog())
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Empty(t, receipt.Lines)
+	assert.Equal(t, "0.00 AUD", receipt.Subtotal.String())
+	assert.Equal(t, "0.00 AUD", receipt.Total.String())
+}
+
+func TestReceipt_BasketRules(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}
+	basketRules := []pricingrules.BasketRule{
+		&pricingrules.FreeGiftRule{Name: "Free adapter", MinSpend: aud("1500.00"), SKU: "vga"},
+		&pricingrules.SpendDiscountRule{Name: "Big spender", MinSpend: aud("1600.00"), Percent: decimal.NewFromInt(5)},
+	}
+	co := checkout.NewCheckout(pricingRules, c, checkout.WithBasketRules(basketRules...))
+
+	for _, sku := range []string{"mbp", "atv", "atv", "atv", "vga"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines, 3)
+
+	// The gift joins the scanned adapter's line and is discounted in full
+	vga := receipt.Lines[2]
+	assert.Equal(t, 2, vga.Quantity)
+	assert.Equal(t, "60.00 AUD", vga.Gross.String())
+	assert.Equal(t, "Free adapter", vga.Adjustments[0].Rule)
+	assert.Equal(t, "-30.00 AUD", vga.Adjustments[0].Amount.String())
+	assert.Equal(t, "30.00 AUD", vga.Net.String())
+
+	// The order discount is taken from the subtotal after the 3 for 2:
+	// 1399.99 + 219.00 + 30.00 = 1648.99
+	assert.Len(t, receipt.OrderAdjustments, 1)
+	assert.Equal(t, "Big spender", receipt.OrderAdjustments[0].Rule)
+	assert.Equal(t, "-82.4495 AUD", receipt.OrderAdjustments[0].Amount.String())
+
+	assert.Equal(t, "1788.49 AUD", receipt.Subtotal.String())
+	assert.Equal(t, "-221.9495 AUD", receipt.Adjustments.String())
+	assert.Equal(t, "1566.54 AUD", receipt.Total.String())
+	assert.Contains(t, receipt.String(), "Big spender")
+}
+
+func TestReceipt_BasketRulesBelowThreshold(t *testing.T) {
+	c := catalog.NewCatalog()
+	basketRules := []pricingrules.BasketRule{
+		&pricingrules.FreeGiftRule{MinSpend: aud("1500.00"), SKU: "vga"},
+		&pricingrules.SpendDiscountRule{MinSpend: aud("2000.00"), Percent: decimal.NewFromInt(5)},
+	}
+	co := checkout.NewCheckout(nil, c, checkout.WithBasketRules(basketRules...))
+
+	err := co.Scan(checkout.Item{SKU: "mbp"})
+	assert.NoError(t, err)
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Len(t, receipt.Lines, 1)
+	assert.Empty(t, receipt.OrderAdjustments)
+	assert.Equal(t, "1399.99 AUD", receipt.Total.String())
+}
+
+func TestReceipt_NoGiftOnceDiscontinued(t *testing.T) {
+	c := catalog.NewCatalog()
+	co := checkout.NewCheckout(nil, c, checkout.WithBasketRules(&pricingrules.FreeGiftRule{MinSpend: aud("1500.00"), SKU: "vga"}))
+	scanAll(t, co, "mbp", "atv")
+	assert.NoError(t, c.SetStatus(context.Background(), "vga", catalog.Discontinued))
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Empty(t, receipt.Gifts)
+	assert.Len(t, receipt.Lines, 2)
+	assert.Equal(t, "1509.49 AUD", receipt.Total.String())
+}
+
+func TestReceipt_String(t *testing.T) {
+	c := catalog.NewCatalog()
+	pricingRules := []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}
+	co := checkout.NewCheckout(pricingRules, c)
+
+	for _, sku := range []string{"atv", "atv", "atv", "vga"} {
+		err := co.Scan(checkout.Item{SKU: sku})
+		assert.NoError(t, err)
+	}
+
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+
+	text := receipt.String()
+	assert.Contains(t, text, "Apple TV")
+	assert.Contains(t, text, "3 for 2 on atv")
+	assert.Contains(t, text, "-109.50 AUD")
+	assert.Contains(t, text, "VGA adapter")
+	assert.Contains(t, text, "249.00 AUD")
+}
+
+func TestReceipt_PricedAtTransactionTime(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	monday := time.Date(2026, time.March, 9, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, c.SchedulePrice(ctx, "atv", catalog.PricePeriod{Price: decimal.RequireFromString("99.50"), From: monday}))
+	assert.NoError(t, c.SchedulePrice(ctx, "vga", catalog.PricePeriod{Price: decimal.RequireFromString("25.00"), From: monday}))
+	pricingRules := []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}
+	basketRules := []pricingrules.BasketRule{&pricingrules.FreeGiftRule{MinSpend: aud("150"), SKU: "vga"}}
+
+	now := monday.Add(-time.Hour)
+	clock := internal.ClockFunc(func() time.Time { return now })
+	co := checkout.NewCheckout(pricingRules, c, checkout.WithClock(clock), checkout.WithBasketRules(basketRules...))
+	assert.NoError(t, co.Scan(checkout.Item{SKU: "atv", Quantity: 3}))
+
+	sunday, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, "109.50 AUD", sunday.Lines[0].UnitPrice.String())
+	assert.Equal(t, "-30.00 AUD", sunday.Lines[1].Adjustments[0].Amount.String())
+	assert.Equal(t, "219.00 AUD", sunday.Total.String())
+
+	now = monday
+	receipt, err := co.Receipt()
+	assert.NoError(t, err)
+	assert.Equal(t, "99.50 AUD", receipt.Lines[0].UnitPrice.String())
+	assert.Equal(t, "-25.00 AUD", receipt.Lines[1].Adjustments[0].Amount.String())
+	assert.Equal(t, "199.00 AUD", receipt.Total.String())
+
+	// Repricing at the original time reproduces the original receipt
+	assert.NoError(t, c.UpdateProduct(ctx, catalog.Product{SKU: "atv", Name: "Apple TV", Price: decimal.RequireFromString("119.50")}))
+	again, err := co.ReceiptAt(sunday.Time)
+	assert.NoError(t, err)
+	assert.Equal(t, sunday.String(), again.String())
+	assert.Equal(t, sunday.Time, again.Time)
+}
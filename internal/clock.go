@@ -0,0 +1,28 @@
+package internal
+
+import "time"
+
+// Clock tells the current time. Components that depend on the time of day
+// take a Clock so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock reads the machine's clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ClockFunc adapts a function to the Clock interface
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// FixedClock returns a clock that is always at t
+func FixedClock(t time.Time) Clock {
+	return ClockFunc(func() time.Time { return t })
+}
@@ -0,0 +1,140 @@
+package coupons
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/money"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+// Coupon is a promotion code that unlocks a pricing rule for the checkout it
+// is applied to
+type Coupon struct {
+	Code string
+	// Rule is the item rule the coupon unlocks, if any
+	Rule pricingrules.PricingRule
+	// BasketRule is the order-level rule the coupon unlocks, if any
+	BasketRule pricingrules.BasketRule
+	// MaxUses limits how often the coupon can be redeemed in total. Zero
+	// means no limit and one makes it single-use.
+	MaxUses int
+	// MaxUsesPerCustomer limits how often each customer can redeem the
+	// coupon. Zero means no limit.
+	MaxUsesPerCustomer int
+	// ExpiresAt is the first moment the coupon can no longer be used. The
+	// zero time means it never expires.
+	ExpiresAt time.Time
+	// MinSpend is the subtotal a basket must reach for the coupon to apply
+	MinSpend money.Money
+}
+
+// NormalizeCode returns the canonical form of a code as typed by a customer,
+// so " save10 " and "SAVE10" are the same coupon
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Store holds the coupons on offer and how often each has been redeemed. It is
+// safe for concurrent use by many checkouts.
+type Store struct {
+	mu           sync.Mutex
+	coupons      map[string]Coupon
+	uses         map[string]int
+	customerUses map[string]map[string]int
+}
+
+// NewStore returns a store offering the given coupons
+func NewStore(coupons ...Coupon) *Store {
+	s := &Store{
+		coupons:      make(map[string]Coupon),
+		uses:         make(map[string]int),
+		customerUses: make(map[string]map[string]int),
+	}
+	for _, coupon := range coupons {
+		s.Add(coupon)
+	}
+	return s
+}
+
+// Add offers a coupon, replacing any coupon with the same code. Redemptions
+// already recorded for the code are kept.
+func (s *Store) Add(coupon Coupon) {
+	coupon.Code = NormalizeCode(coupon.Code)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coupons[coupon.Code] = coupon
+}
+
+// Check returns the coupon for code if customer can use it at the given time.
+// A coupon limited per customer cannot be used without a customer.
+func (s *Store) Check(code, customer string, at time.Time) (Coupon, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.check(NormalizeCode(code), customer, at)
+}
+
+func (s *Store) check(code, customer string, at time.Time) (Coupon, error) {
+	coupon, ok := s.coupons[code]
+	if !ok {
+		return Coupon{}, internal.NewCouponNotFoundError(code)
+	}
+	if !coupon.ExpiresAt.IsZero() && !at.Before(coupon.ExpiresAt) {
+		return Coupon{}, internal.NewCouponExpiredError(code, coupon.ExpiresAt)
+	}
+	if coupon.MaxUses > 0 && s.uses[code] >= coupon.MaxUses {
+		return Coupon{}, internal.NewCouponLimitReachedError(code, "", coupon.MaxUses)
+	}
+	// Anonymous customers cannot be told apart, so they would share one limit
+	if coupon.MaxUsesPerCustomer > 0 && customer == "" {
+		return Coupon{}, internal.NewCouponCustomerRequiredError(code)
+	}
+	if coupon.MaxUsesPerCustomer > 0 && s.customerUses[code][customer] >= coupon.MaxUsesPerCustomer {
+		return Coupon{}, internal.NewCouponLimitReachedError(code, customer, coupon.MaxUsesPerCustomer)
+	}
+	return coupon, nil
+}
+
+// Redeem records one use of every given code by customer. Either every code
+// is redeemed or, if any of them can no longer be used, none is.
+func (s *Store) Redeem(customer string, at time.Time, codes ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, code := range codes {
+		if _, err := s.check(NormalizeCode(code), customer, at); err != nil {
+			return err
+		}
+	}
+	for _, code := range codes {
+		code = NormalizeCode(code)
+		s.uses[code]++
+		if s.customerUses[code] == nil {
+			s.customerUses[code] = make(map[string]int)
+		}
+		s.customerUses[code][customer]++
+	}
+	return nil
+}
+
+// Unredeem gives back one use of every given code that customer redeemed,
+// undoing a Redeem for a sale that did not go through
+func (s *Store) Unredeem(customer string, codes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, code := range codes {
+		code = NormalizeCode(code)
+		if s.customerUses[code][customer] > 0 {
+			s.customerUses[code][customer]--
+			s.uses[code]--
+		}
+	}
+}
+
+// Uses returns how often the coupon has been redeemed
+func (s *Store) Uses(code string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uses[NormalizeCode(code)]
+}
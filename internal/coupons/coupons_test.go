@@ -0,0 +1,130 @@
+package coupons_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/coupons"
+)
+
+var now = time.Date(2024, 11, 29, 12, 0, 0, 0, time.UTC)
+
+func TestStore_Check(t *testing.T) {
+	store := coupons.NewStore(
+		coupons.Coupon{Code: "save10"},
+		coupons.Coupon{Code: "BLACKFRIDAY", ExpiresAt: now},
+	)
+
+	coupon, err := store.Check(" Save10 ", "alice", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "SAVE10", coupon.Code)
+
+	_, err = store.Check("SAVE20", "alice", now)
+	assert.EqualError(t, err, "coupon not found: SAVE20")
+	var notFound internal.ErrCouponNotFound
+	assert.True(t, errors.As(err, &notFound))
+
+	_, err = store.Check("BLACKFRIDAY", "alice", now.Add(-time.Second))
+	assert.NoError(t, err)
+	_, err = store.Check("BLACKFRIDAY", "alice", now)
+	var expired internal.ErrCouponExpired
+	assert.True(t, errors.As(err, &expired))
+	assert.True(t, now.Equal(expired.ExpiresAt))
+}
+
+func TestStore_Redeem_SingleUse(t *testing.T) {
+	store := coupons.NewStore(coupons.Coupon{Code: "WELCOME", MaxUses: 1})
+
+	assert.NoError(t, store.Redeem("alice", now, "WELCOME"))
+	assert.Equal(t, 1, store.Uses("welcome"))
+
+	err := store.Redeem("bob", now, "WELCOME")
+	assert.EqualError(t, err, "coupon WELCOME can be used 1 time(s) and has reached the limit")
+	assert.Equal(t, 1, store.Uses("WELCOME"))
+}
+
+func TestStore_Redeem_PerCustomer(t *testing.T) {
+	store := coupons.NewStore(coupons.Coupon{Code: "LOYAL", MaxUses: 3, MaxUsesPerCustomer: 2})
+
+	assert.NoError(t, store.Redeem("alice", now, "LOYAL"))
+	assert.NoError(t, store.Redeem("alice", now, "LOYAL"))
+
+	err := store.Redeem("alice", now, "LOYAL")
+	var limit internal.ErrCouponLimitReached
+	assert.True(t, errors.As(err, &limit))
+	assert.Equal(t, "alice", limit.Customer)
+	assert.Equal(t, 2, limit.Limit)
+
+	assert.NoError(t, store.Redeem("bob", now, "LOYAL"))
+	err = store.Redeem("carol", now, "LOYAL")
+	assert.True(t, errors.As(err, &limit))
+	assert.Empty(t, limit.Customer)
+}
+
+func TestStore_PerCustomer_NeedsCustomer(t *testing.T) {
+	store := coupons.NewStore(coupons.Coupon{Code: "FIRSTORDER", MaxUsesPerCustomer: 1}, coupons.Coupon{Code: "SAVE10"})
+
+	_, err := store.Check("FIRSTORDER", "", now)
+	assert.EqualError(t, err, "coupon FIRSTORDER is limited per customer and needs a known customer")
+	var required internal.ErrCouponCustomerRequired
+	assert.True(t, errors.As(store.Redeem("", now, "FIRSTORDER"), &required))
+	assert.Equal(t, 0, store.Uses("FIRSTORDER"))
+
+	// Coupons without a per-customer limit need no customer
+	assert.NoError(t, store.Redeem("", now, "SAVE10"))
+}
+
+func TestStore_Redeem_AllOrNothing(t *testing.T) {
+	store := coupons.NewStore(
+		coupons.Coupon{Code: "MULTI"},
+		coupons.Coupon{Code: "ONCE", MaxUses: 1},
+	)
+	assert.NoError(t, store.Redeem("alice", now, "ONCE"))
+
+	err := store.Redeem("bob", now, "MULTI", "ONCE")
+	assert.Error(t, err)
+	assert.Equal(t, 0, store.Uses("MULTI"))
+}
+
+func TestStore_Unredeem(t *testing.T) {
+	store := coupons.NewStore(coupons.Coupon{Code: "ONCE", MaxUses: 1, MaxUsesPerCustomer: 1})
+	assert.NoError(t, store.Redeem("alice", now, "ONCE"))
+
+	// A use given back can be redeemed again, by anyone
+	store.Unredeem("alice", "once")
+	assert.Equal(t, 0, store.Uses("ONCE"))
+	assert.NoError(t, store.Redeem("alice", now, "ONCE"))
+
+	// Giving back what was never redeemed changes nothing
+	store.Unredeem("bob", "ONCE", "UNKNOWN")
+	assert.Equal(t, 1, store.Uses("ONCE"))
+	assert.Error(t, store.Redeem("alice", now, "ONCE"))
+}
+
+func TestStore_Redeem_Concurrent(t *testing.T) {
+	store := coupons.NewStore(coupons.Coupon{Code: "FIRST50", MaxUses: 50})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	redeemed := 0
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if store.Redeem("", now, "FIRST50") == nil {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, redeemed)
+	assert.Equal(t, 50, store.Uses("FIRST50"))
+}
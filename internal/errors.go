@@ -2,6 +2,8 @@ package internal
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -69,3 +71,562 @@ func NewInvalidProductError(sku, reason string) ErrInvalidProduct {
 func (e ErrInvalidProduct) Error() string {
 	return fmt.Sprintf("invalid product %s: %s", e.SKU, e.Reason)
 }
+
+// ErrCurrencyMismatch represents an error when amounts in different currencies are combined
+type ErrCurrencyMismatch struct {
+	Expected string
+	Actual   string
+}
+
+func NewCurrencyMismatchError(expected, actual string) ErrCurrencyMismatch {
+	return ErrCurrencyMismatch{
+		Expected: expected,
+		Actual:   actual,
+	}
+}
+
+func (e ErrCurrencyMismatch) Error() string {
+	return fmt.Sprintf("currency mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// ErrUnknownRuleType represents an error when a rule configuration names a rule type that is not registered
+type ErrUnknownRuleType struct {
+	Type string
+}
+
+func NewUnknownRuleTypeError(ruleType string) ErrUnknownRuleType {
+	return ErrUnknownRuleType{
+		Type: ruleType,
+	}
+}
+
+func (e ErrUnknownRuleType) Error() string {
+	return fmt.Sprintf("unknown rule type: %q", e.Type)
+}
+
+// ErrInvalidRuleParam represents an error when a rule configuration parameter is missing or invalid
+type ErrInvalidRuleParam struct {
+	Param  string
+	Reason string
+}
+
+func NewInvalidRuleParamError(param, reason string) ErrInvalidRuleParam {
+	return ErrInvalidRuleParam{
+		Param:  param,
+		Reason: reason,
+	}
+}
+
+func (e ErrInvalidRuleParam) Error() string {
+	return fmt.Sprintf("invalid parameter %s: %s", e.Param, e.Reason)
+}
+
+// ErrInvalidRule represents an error in the rule configuration at the given path, e.g. "rules[2]"
+type ErrInvalidRule struct {
+	Path string
+	Err  error
+}
+
+func NewInvalidRuleError(path string, err error) ErrInvalidRule {
+	return ErrInvalidRule{
+		Path: path,
+		Err:  err,
+	}
+}
+
+func (e ErrInvalidRule) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+func (e ErrInvalidRule) Unwrap() error {
+	return e.Err
+}
+
+// ErrCouponNotFound represents an error when a coupon code is not known
+type ErrCouponNotFound struct {
+	Code string
+}
+
+func NewCouponNotFoundError(code string) ErrCouponNotFound {
+	return ErrCouponNotFound{
+		Code: code,
+	}
+}
+
+func (e ErrCouponNotFound) Error() string {
+	return fmt.Sprintf("coupon not found: %s", e.Code)
+}
+
+// ErrCouponExpired represents an error when a coupon is used after its expiry date
+type ErrCouponExpired struct {
+	Code      string
+	ExpiresAt time.Time
+}
+
+func NewCouponExpiredError(code string, expiresAt time.Time) ErrCouponExpired {
+	return ErrCouponExpired{
+		Code:      code,
+		ExpiresAt: expiresAt,
+	}
+}
+
+func (e ErrCouponExpired) Error() string {
+	return fmt.Sprintf("coupon %s expired at %s", e.Code, e.ExpiresAt.Format(time.RFC3339))
+}
+
+// ErrCouponLimitReached represents an error when a coupon has been used as often as it allows,
+// either in total or, when Customer is set, by one customer
+type ErrCouponLimitReached struct {
+	Code     string
+	Customer string
+	Limit    int
+}
+
+func NewCouponLimitReachedError(code, customer string, limit int) ErrCouponLimitReached {
+	return ErrCouponLimitReached{
+		Code:     code,
+		Customer: customer,
+		Limit:    limit,
+	}
+}
+
+func (e ErrCouponLimitReached) Error() string {
+	if e.Customer != "" {
+		return fmt.Sprintf("coupon %s can be used %d time(s) per customer and customer %s has reached the limit", e.Code, e.Limit, e.Customer)
+	}
+	return fmt.Sprintf("coupon %s can be used %d time(s) and has reached the limit", e.Code, e.Limit)
+}
+
+// ErrCouponCustomerRequired represents an error when a coupon limited per customer is used without
+// knowing who the customer is
+type ErrCouponCustomerRequired struct {
+	Code string
+}
+
+func NewCouponCustomerRequiredError(code string) ErrCouponCustomerRequired {
+	return ErrCouponCustomerRequired{
+		Code: code,
+	}
+}
+
+func (e ErrCouponCustomerRequired) Error() string {
+	return fmt.Sprintf("coupon %s is limited per customer and needs a known customer", e.Code)
+}
+
+// ErrCouponMinSpend represents an error when a basket does not reach a coupon's minimum spend
+type ErrCouponMinSpend struct {
+	Code     string
+	MinSpend string
+	Subtotal string
+}
+
+func NewCouponMinSpendError(code, minSpend, subtotal string) ErrCouponMinSpend {
+	return ErrCouponMinSpend{
+		Code:     code,
+		MinSpend: minSpend,
+		Subtotal: subtotal,
+	}
+}
+
+func (e ErrCouponMinSpend) Error() string {
+	return fmt.Sprintf("coupon %s needs a minimum spend of %s, basket is %s", e.Code, e.MinSpend, e.Subtotal)
+}
+
+// ErrCouponAlreadyApplied represents an error when a coupon is applied to a checkout twice
+type ErrCouponAlreadyApplied struct {
+	Code string
+}
+
+func NewCouponAlreadyAppliedError(code string) ErrCouponAlreadyApplied {
+	return ErrCouponAlreadyApplied{
+		Code: code,
+	}
+}
+
+func (e ErrCouponAlreadyApplied) Error() string {
+	return fmt.Sprintf("coupon already applied: %s", e.Code)
+}
+
+// ErrCouponNotApplied represents an error when removing a coupon that was never applied
+type ErrCouponNotApplied struct {
+	Code string
+}
+
+func NewCouponNotAppliedError(code string) ErrCouponNotApplied {
+	return ErrCouponNotApplied{
+		Code: code,
+	}
+}
+
+func (e ErrCouponNotApplied) Error() string {
+	return fmt.Sprintf("coupon not applied: %s", e.Code)
+}
+
+// ErrItemNotScanned represents an error when changing an item that is not in the checkout
+type ErrItemNotScanned struct {
+	SKU string
+}
+
+func NewItemNotScannedError(sku string) ErrItemNotScanned {
+	return ErrItemNotScanned{
+		SKU: sku,
+	}
+}
+
+func (e ErrItemNotScanned) Error() string {
+	return fmt.Sprintf("item not scanned: %s", e.SKU)
+}
+
+// ErrInvalidQuantity represents an error when an item quantity is out of range
+type ErrInvalidQuantity struct {
+	SKU      string
+	Quantity int
+}
+
+func NewInvalidQuantityError(sku string, quantity int) ErrInvalidQuantity {
+	return ErrInvalidQuantity{
+		SKU:      sku,
+		Quantity: quantity,
+	}
+}
+
+func (e ErrInvalidQuantity) Error() string {
+	return fmt.Sprintf("invalid quantity for %s: %d", e.SKU, e.Quantity)
+}
+
+// ErrInvalidMeasure represents an error when an item sold by measure is scanned without a valid measure,
+// or an item sold whole is scanned with one
+type ErrInvalidMeasure struct {
+	SKU     string
+	Measure decimal.Decimal
+	Reason  string
+}
+
+func NewInvalidMeasureError(sku string, measure decimal.Decimal, reason string) ErrInvalidMeasure {
+	return ErrInvalidMeasure{
+		SKU:     sku,
+		Measure: measure,
+		Reason:  reason,
+	}
+}
+
+func (e ErrInvalidMeasure) Error() string {
+	return fmt.Sprintf("invalid measure %s for %s: %s", e.Measure, e.SKU, e.Reason)
+}
+
+// ErrCorruptStore represents an error when a catalog store's log cannot be read back
+type ErrCorruptStore struct {
+	Path   string
+	Line   int
+	Reason string
+}
+
+func NewCorruptStoreError(path string, line int, reason string) ErrCorruptStore {
+	return ErrCorruptStore{
+		Path:   path,
+		Line:   line,
+		Reason: reason,
+	}
+}
+
+func (e ErrCorruptStore) Error() string {
+	return fmt.Sprintf("corrupt catalog store %s at line %d: %s", e.Path, e.Line, e.Reason)
+}
+
+// ErrDuplicateSKU represents an error when a product is added under a SKU that is already in use
+type ErrDuplicateSKU struct {
+	SKU string
+}
+
+func NewDuplicateSKUError(sku string) ErrDuplicateSKU {
+	return ErrDuplicateSKU{
+		SKU: sku,
+	}
+}
+
+func (e ErrDuplicateSKU) Error() string {
+	return fmt.Sprintf("product already exists: %s", e.SKU)
+}
+
+// ErrInvalidStatusTransition represents an error when a product cannot move from one lifecycle status to another
+type ErrInvalidStatusTransition struct {
+	SKU  string
+	From string
+	To   string
+}
+
+func NewInvalidStatusTransitionError(sku, from, to string) ErrInvalidStatusTransition {
+	return ErrInvalidStatusTransition{
+		SKU:  sku,
+		From: from,
+		To:   to,
+	}
+}
+
+func (e ErrInvalidStatusTransition) Error() string {
+	return fmt.Sprintf("product %s cannot move from %s to %s", e.SKU, e.From, e.To)
+}
+
+// ErrProductUnavailable represents an error when a product that is not on sale is scanned
+type ErrProductUnavailable struct {
+	SKU    string
+	Status string
+}
+
+func NewProductUnavailableError(sku, status string) ErrProductUnavailable {
+	return ErrProductUnavailable{
+		SKU:    sku,
+		Status: status,
+	}
+}
+
+func (e ErrProductUnavailable) Error() string {
+	return fmt.Sprintf("product %s is %s and cannot be sold", e.SKU, e.Status)
+}
+
+// ErrVariantRequired represents an error when a product that comes in variants is scanned by its parent SKU
+type ErrVariantRequired struct {
+	SKU      string
+	Variants []string
+}
+
+func NewVariantRequiredError(sku string, variants []string) ErrVariantRequired {
+	return ErrVariantRequired{
+		SKU:      sku,
+		Variants: variants,
+	}
+}
+
+func (e ErrVariantRequired) Error() string {
+	return fmt.Sprintf("product %s comes in variants, scan one of: %s", e.SKU, strings.Join(e.Variants, ", "))
+}
+
+// ErrValidation represents every problem found when validating one or more products.
+// errors.As finds the individual errors, such as ErrNegativePrice, through it.
+type ErrValidation struct {
+	Errors []error
+}
+
+func NewValidationError(errs ...error) ErrValidation {
+	return ErrValidation{
+		Errors: errs,
+	}
+}
+
+func (e ErrValidation) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d validation errors: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+func (e ErrValidation) Unwrap() []error {
+	return e.Errors
+}
+
+// ErrImportRow represents a problem with one row of a catalog import
+type ErrImportRow struct {
+	Line int
+	Err  error
+}
+
+func NewImportRowError(line int, err error) ErrImportRow {
+	return ErrImportRow{
+		Line: line,
+		Err:  err,
+	}
+}
+
+func (e ErrImportRow) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e ErrImportRow) Unwrap() error {
+	return e.Err
+}
+
+// ErrStaleImport represents an error when an import plan is applied after the catalog has changed
+type ErrStaleImport struct{}
+
+func NewStaleImportError() ErrStaleImport {
+	return ErrStaleImport{}
+}
+
+func (e ErrStaleImport) Error() string {
+	return "catalog changed since the import was planned"
+}
+
+// ErrInvalidSelector represents an error when a rule targets products with a malformed selector
+type ErrInvalidSelector struct {
+	Selector string
+	Reason   string
+}
+
+func NewInvalidSelectorError(selector, reason string) ErrInvalidSelector {
+	return ErrInvalidSelector{
+		Selector: selector,
+		Reason:   reason,
+	}
+}
+
+func (e ErrInvalidSelector) Error() string {
+	return fmt.Sprintf("invalid selector %q: %s", e.Selector, e.Reason)
+}
+
+// ErrUnknownCode represents an error when a scanned code is not the SKU, barcode or alias of any product
+type ErrUnknownCode struct {
+	Code string
+}
+
+func NewUnknownCodeError(code string) ErrUnknownCode {
+	return ErrUnknownCode{
+		Code: code,
+	}
+}
+
+func (e ErrUnknownCode) Error() string {
+	return fmt.Sprintf("unknown code %s: no product has it as SKU, barcode or alias", e.Code)
+}
+
+// Unwrap lets callers that look for ErrProductNotFound find an unknown code too
+func (e ErrUnknownCode) Unwrap() error {
+	return NewProductNotFoundError(e.Code)
+}
+
+// ErrInvalidBarcode represents an error when a barcode is malformed or fails its check digit
+type ErrInvalidBarcode struct {
+	Code   string
+	Reason string
+}
+
+func NewInvalidBarcodeError(code, reason string) ErrInvalidBarcode {
+	return ErrInvalidBarcode{
+		Code:   code,
+		Reason: reason,
+	}
+}
+
+func (e ErrInvalidBarcode) Error() string {
+	return fmt.Sprintf("invalid barcode %s: %s", e.Code, e.Reason)
+}
+
+// ErrDuplicateCode represents an error when a SKU, barcode or alias is already used by another product
+type ErrDuplicateCode struct {
+	Code string
+	SKU  string
+}
+
+func NewDuplicateCodeError(code, sku string) ErrDuplicateCode {
+	return ErrDuplicateCode{
+		Code: code,
+		SKU:  sku,
+	}
+}
+
+func (e ErrDuplicateCode) Error() string {
+	return fmt.Sprintf("code %s is already used by product %s", e.Code, e.SKU)
+}
+
+// ErrOutOfStock represents an error when a location does not have enough of a product left to reserve
+type ErrOutOfStock struct {
+	SKU       string
+	Location  string
+	Requested decimal.Decimal
+	Available decimal.Decimal
+}
+
+func NewOutOfStockError(sku, location string, requested, available decimal.Decimal) ErrOutOfStock {
+	return ErrOutOfStock{
+		SKU:       sku,
+		Location:  location,
+		Requested: requested,
+		Available: available,
+	}
+}
+
+func (e ErrOutOfStock) Error() string {
+	return fmt.Sprintf("product %s is out of stock at %s: %s requested, %s available", e.SKU, e.Location, e.Requested, e.Available)
+}
+
+// ErrInvalidStock represents an error when a stock level or reserved quantity is negative
+type ErrInvalidStock struct {
+	SKU      string
+	Quantity decimal.Decimal
+}
+
+func NewInvalidStockError(sku string, quantity decimal.Decimal) ErrInvalidStock {
+	return ErrInvalidStock{
+		SKU:      sku,
+		Quantity: quantity,
+	}
+}
+
+func (e ErrInvalidStock) Error() string {
+	return fmt.Sprintf("invalid stock quantity %s for product %s: cannot be negative", e.Quantity, e.SKU)
+}
+
+// ErrReservationNotFound represents an error when a stock reservation does not exist, for example because it expired
+type ErrReservationNotFound struct {
+	ID string
+}
+
+func NewReservationNotFoundError(id string) ErrReservationNotFound {
+	return ErrReservationNotFound{
+		ID: id,
+	}
+}
+
+func (e ErrReservationNotFound) Error() string {
+	return fmt.Sprintf("stock reservation %s not found, it may have expired", e.ID)
+}
+
+// ErrCheckoutFinalised represents an error when a checkout is changed or finalised again after the sale went through
+type ErrCheckoutFinalised struct{}
+
+func NewCheckoutFinalisedError() ErrCheckoutFinalised {
+	return ErrCheckoutFinalised{}
+}
+
+func (e ErrCheckoutFinalised) Error() string {
+	return "checkout is already finalised"
+}
+
+// ErrInvalidVoidReason represents an error when a line is voided without one of the checkout's reason codes
+type ErrInvalidVoidReason struct {
+	Reason string
+}
+
+func NewInvalidVoidReasonError(reason string) ErrInvalidVoidReason {
+	return ErrInvalidVoidReason{
+		Reason: reason,
+	}
+}
+
+func (e ErrInvalidVoidReason) Error() string {
+	return fmt.Sprintf("invalid void reason %q", e.Reason)
+}
+
+// ErrStoreFailed represents an error when a store can no longer be written safely, so every later change is refused
+type ErrStoreFailed struct {
+	Path string
+	Err  error
+}
+
+func NewStoreFailedError(path string, err error) ErrStoreFailed {
+	return ErrStoreFailed{
+		Path: path,
+		Err:  err,
+	}
+}
+
+func (e ErrStoreFailed) Error() string {
+	return fmt.Sprintf("store %s can no longer be written: %v", e.Path, e.Err)
+}
+
+func (e ErrStoreFailed) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,217 @@
+package inventory
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// DefaultTTL is how long a reservation holds its stock after it was last
+// changed, unless the store is given another time with WithTTL
+const DefaultTTL = 15 * time.Minute
+
+// Level is how much of a product a location has
+type Level struct {
+	// OnHand is the stock at the location, counting stock reserved by
+	// checkouts that have not finished yet
+	OnHand decimal.Decimal
+	// Reserved is the stock held by live reservations
+	Reserved decimal.Decimal
+}
+
+// Available returns the stock that can still be reserved
+func (l Level) Available() decimal.Decimal {
+	return l.OnHand.Sub(l.Reserved)
+}
+
+// reservation is the stock one checkout holds at one location
+type reservation struct {
+	location   string
+	quantities map[string]decimal.Decimal
+	expiresAt  time.Time
+}
+
+// stockKey identifies a product's stock at a location
+type stockKey struct {
+	location string
+	sku      string
+}
+
+// Store tracks the stock of each product at each location and the stock
+// reserved by checkouts that are still open. Reserved stock stays on hand
+// until the reservation is committed, which takes it off, or released or
+// expired, which makes it available again. A product the store has no level
+// for has none in stock. Store is safe for concurrent use by many checkouts.
+type Store struct {
+	mu           sync.Mutex
+	ttl          time.Duration
+	onHand       map[stockKey]decimal.Decimal
+	reserved     map[stockKey]decimal.Decimal
+	reservations map[string]*reservation
+}
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithTTL sets how long a reservation holds its stock after it was last
+// changed. A checkout left open longer loses its reservation.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = ttl
+	}
+}
+
+// NewStore returns a store with no stock
+func NewStore(opts ...Option) *Store {
+	s := &Store{
+		ttl:          DefaultTTL,
+		onHand:       make(map[stockKey]decimal.Decimal),
+		reserved:     make(map[stockKey]decimal.Decimal),
+		reservations: make(map[string]*reservation),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SetStock sets how much of sku is on hand at location, as after a stock
+// take. Stock already reserved stays reserved, so setting less than is
+// reserved leaves none available until reservations end.
+func (s *Store) SetStock(location, sku string, quantity decimal.Decimal) error {
+	if quantity.IsNegative() {
+		return internal.NewInvalidStockError(sku, quantity)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onHand[stockKey{location, sku}] = quantity
+	return nil
+}
+
+// Receive adds a delivery of sku to the stock on hand at location
+func (s *Store) Receive(location, sku string, quantity decimal.Decimal) error {
+	if quantity.IsNegative() {
+		return internal.NewInvalidStockError(sku, quantity)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := stockKey{location, sku}
+	s.onHand[key] = s.onHand[key].Add(quantity)
+	return nil
+}
+
+// Level returns the stock of sku at location at the given time, after
+// reservations that have expired by then are released
+func (s *Store) Level(location, sku string, at time.Time) Level {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(at)
+	key := stockKey{location, sku}
+	return Level{OnHand: s.onHand[key], Reserved: s.reserved[key]}
+}
+
+// Reserve makes reservation id hold exactly the given quantity of each SKU
+// at location, reserving more stock or releasing some as needed, and starts
+// its time to live again. A reservation that does not exist yet, or has
+// expired, is created. Either the whole reservation is updated or, if any SKU
+// does not have enough stock available, nothing changes and ErrOutOfStock
+// names the first such SKU in order. Reserving nothing releases the
+// reservation.
+func (s *Store) Reserve(id, location string, quantities map[string]decimal.Decimal, at time.Time) error {
+	skus := make([]string, 0, len(quantities))
+	for sku, quantity := range quantities {
+		if quantity.IsNegative() {
+			return internal.NewInvalidStockError(sku, quantity)
+		}
+		skus = append(skus, sku)
+	}
+	slices.Sort(skus)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(at)
+	// The stock the reservation already holds here counts as available to it
+	var own map[string]decimal.Decimal
+	if held, ok := s.reservations[id]; ok && held.location == location {
+		own = held.quantities
+	}
+	for _, sku := range skus {
+		key := stockKey{location, sku}
+		available := s.onHand[key].Sub(s.reserved[key]).Add(own[sku])
+		if quantities[sku].GreaterThan(available) {
+			return internal.NewOutOfStockError(sku, location, quantities[sku], decimal.Max(available, decimal.Zero))
+		}
+	}
+
+	s.release(id)
+	if len(skus) == 0 {
+		return nil
+	}
+	held := &reservation{location: location, quantities: make(map[string]decimal.Decimal, len(skus)), expiresAt: at.Add(s.ttl)}
+	for _, sku := range skus {
+		if quantities[sku].IsZero() {
+			continue
+		}
+		key := stockKey{location, sku}
+		held.quantities[sku] = quantities[sku]
+		s.reserved[key] = s.reserved[key].Add(quantities[sku])
+	}
+	s.reservations[id] = held
+	return nil
+}
+
+// Commit takes the stock held by reservation id off hand, as when the sale
+// goes through, and ends the reservation. It fails with ErrReservationNotFound
+// if the reservation has been released or has expired by the given time.
+func (s *Store) Commit(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(at)
+	held, ok := s.reservations[id]
+	if !ok {
+		return internal.NewReservationNotFoundError(id)
+	}
+	for sku, quantity := range held.quantities {
+		key := stockKey{held.location, sku}
+		s.onHand[key] = s.onHand[key].Sub(quantity)
+	}
+	s.release(id)
+	return nil
+}
+
+// Release ends reservation id, making the stock it held available again. A
+// reservation that does not exist is already released.
+func (s *Store) Release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.release(id)
+}
+
+func (s *Store) release(id string) {
+	held, ok := s.reservations[id]
+	if !ok {
+		return
+	}
+	for sku, quantity := range held.quantities {
+		key := stockKey{held.location, sku}
+		if reserved := s.reserved[key].Sub(quantity); reserved.IsZero() {
+			delete(s.reserved, key)
+		} else {
+			s.reserved[key] = reserved
+		}
+	}
+	delete(s.reservations, id)
+}
+
+// expire releases the reservations whose time to live has run out by at
+func (s *Store) expire(at time.Time) {
+	for id, held := range s.reservations {
+		if !at.Before(held.expiresAt) {
+			s.release(id)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package inventory_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/inventory"
+)
+
+var now = time.Date(2024, 11, 29, 12, 0, 0, 0, time.UTC)
+
+func qty(n int64) decimal.Decimal {
+	return decimal.NewFromInt(n)
+}
+
+func assertLevel(t *testing.T, store *inventory.Store, location, sku string, at time.Time, onHand, reserved string) {
+	t.Helper()
+	level := store.Level(location, sku, at)
+	assert.Equal(t, onHand, level.OnHand.String(), "on hand")
+	assert.Equal(t, reserved, level.Reserved.String(), "reserved")
+}
+
+func TestStore_ReserveAndCommit(t *testing.T) {
+	store := inventory.NewStore()
+	assert.NoError(t, store.SetStock("sydney", "mbp", qty(3)))
+	assert.NoError(t, store.Receive("sydney", "mbp", qty(2)))
+	assert.NoError(t, store.SetStock("sydney", "vga", qty(10)))
+
+	assert.NoError(t, store.Reserve("a", "sydney", map[string]decimal.Decimal{"mbp": qty(4)}, now))
+	assertLevel(t, store, "sydney", "mbp", now, "5", "4")
+	assert.Equal(t, "1", store.Level("sydney", "mbp", now).Available().String())
+
+	err := store.Reserve("b", "sydney", map[string]decimal.Decimal{"vga": qty(1), "mbp": qty(2)}, now)
+	assert.EqualError(t, err, "product mbp is out of stock at sydney: 2 requested, 1 available")
+	var outOfStock internal.ErrOutOfStock
+	assert.True(t, errors.As(err, &outOfStock))
+	assert.Equal(t, "sydney", outOfStock.Location)
+	// Nothing is reserved when any SKU is short
+	assertLevel(t, store, "sydney", "vga", now, "10", "0")
+
+	// A reservation can shrink and grow within what it holds
+	assert.NoError(t, store.Reserve("a", "sydney", map[string]decimal.Decimal{"mbp": qty(2)}, now))
+	assert.NoError(t, store.Reserve("b", "sydney", map[string]decimal.Decimal{"vga": qty(1), "mbp": qty(2)}, now))
+	assert.True(t, errors.As(store.Reserve("a", "sydney", map[string]decimal.Decimal{"mbp": qty(4)}, now), &outOfStock))
+	assert.Equal(t, "3", outOfStock.Available.String())
+
+	assert.NoError(t, store.Commit("a", now))
+	assertLevel(t, store, "sydney", "mbp", now, "3", "2")
+	err = store.Commit("a", now)
+	var notFound internal.ErrReservationNotFound
+	assert.True(t, errors.As(err, &notFound))
+
+	// Each location has its own stock, and a product with no level has none
+	assert.True(t, errors.As(store.Reserve("c", "melbourne", map[string]decimal.Decimal{"mbp": qty(1)}, now), &outOfStock))
+	assert.Equal(t, "0", outOfStock.Available.String())
+}
+
+func TestStore_ReleaseAndExpiry(t *testing.T) {
+	store := inventory.NewStore(inventory.WithTTL(10 * time.Minute))
+	assert.NoError(t, store.SetStock("sydney", "atv", qty(2)))
+	assert.NoError(t, store.SetStock("sydney", "cbl", decimal.RequireFromString("5.5")))
+
+	assert.NoError(t, store.Reserve("a", "sydney", map[string]decimal.Decimal{"atv": qty(2)}, now))
+	store.Release("a")
+	store.Release("a")
+	assertLevel(t, store, "sydney", "atv", now, "2", "0")
+
+	measured := map[string]decimal.Decimal{"cbl": decimal.RequireFromString("1.25")}
+	assert.NoError(t, store.Reserve("b", "sydney", measured, now))
+	assertLevel(t, store, "sydney", "cbl", now, "5.5", "1.25")
+
+	// Changing a reservation starts its time to live again
+	assert.NoError(t, store.Reserve("c", "sydney", map[string]decimal.Decimal{"atv": qty(1)}, now))
+	assert.NoError(t, store.Reserve("c", "sydney", map[string]decimal.Decimal{"atv": qty(2)}, now.Add(5*time.Minute)))
+	assertLevel(t, store, "sydney", "cbl", now.Add(10*time.Minute), "5.5", "0")
+	assertLevel(t, store, "sydney", "atv", now.Add(10*time.Minute), "2", "2")
+	assertLevel(t, store, "sydney", "atv", now.Add(15*time.Minute), "2", "0")
+	err := store.Commit("c", now.Add(15*time.Minute))
+	var notFound internal.ErrReservationNotFound
+	assert.True(t, errors.As(err, &notFound))
+
+	// An expired reservation is made again if the stock is still there
+	assert.NoError(t, store.Reserve("c", "sydney", map[string]decimal.Decimal{"atv": qty(2)}, now.Add(20*time.Minute)))
+	assert.NoError(t, store.Commit("c", now.Add(20*time.Minute)))
+	assertLevel(t, store, "sydney", "atv", now.Add(20*time.Minute), "0", "0")
+}
+
+func TestStore_InvalidQuantities(t *testing.T) {
+	store := inventory.NewStore()
+	var invalid internal.ErrInvalidStock
+	assert.True(t, errors.As(store.SetStock("sydney", "atv", qty(-1)), &invalid))
+	assert.True(t, errors.As(store.Receive("sydney", "atv", qty(-1)), &invalid))
+	err := store.Reserve("a", "sydney", map[string]decimal.Decimal{"atv": qty(-1)}, now)
+	assert.EqualError(t, err, "invalid stock quantity -1 for product atv: cannot be negative")
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	store := inventory.NewStore()
+	assert.NoError(t, store.SetStock("sydney", "mbp", qty(50)))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sold := 0
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("checkout-%d", i)
+			if store.Reserve(id, "sydney", map[string]decimal.Decimal{"mbp": qty(1)}, now) != nil {
+				return
+			}
+			if i%2 == 0 {
+				store.Release(id)
+				return
+			}
+			if store.Commit(id, now) == nil {
+				mu.Lock()
+				sold++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	level := store.Level("sydney", "mbp", now)
+	assert.Equal(t, "0", level.Reserved.String())
+	assert.Equal(t, int64(50-sold), level.OnHand.IntPart())
+	assert.False(t, level.OnHand.IsNegative())
+}
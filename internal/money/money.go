@@ -0,0 +1,136 @@
+package money
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
+)
+
+// Currency is an ISO 4217 currency code
+type Currency string
+
+const (
+	AUD Currency = "AUD"
+	USD Currency = "USD"
+	JPY Currency = "JPY"
+)
+
+// Places returns the number of decimal places the currency is settled in
+func (c Currency) Places() int32 {
+	switch c {
+	case JPY:
+		return 0
+	default:
+		return 2
+	}
+}
+
+// Money is an exact decimal amount in a given currency. The zero Money has no
+// currency and can be combined with an amount in any currency.
+type Money struct {
+	Amount   decimal.Decimal
+	Currency Currency
+}
+
+func New(amount decimal.Decimal, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Zero returns a zero amount in the given currency
+func Zero(currency Currency) Money {
+	return Money{Amount: decimal.Zero, Currency: currency}
+}
+
+// Parse reads an amount such as "499.99" in the given currency
+func Parse(amount string, currency Currency) (Money, error) {
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", amount, err)
+	}
+	return New(d, currency), nil
+}
+
+// MustParse is like Parse but panics if the amount is invalid. It is meant for
+// amounts written as literals in code and tests.
+func MustParse(amount string, currency Currency) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// SameCurrency reports whether m and o can be combined
+func (m Money) SameCurrency(o Money) bool {
+	return m.Currency == "" || o.Currency == "" || m.Currency == o.Currency
+}
+
+// combine returns the currency of a result of m and o. It panics on a
+// currency mismatch, which callers are expected to rule out up front.
+func (m Money) combine(o Money) Currency {
+	if !m.SameCurrency(o) {
+		panic(internal.NewCurrencyMismatchError(string(m.Currency), string(o.Currency)))
+	}
+	if m.Currency == "" {
+		return o.Currency
+	}
+	return m.Currency
+}
+
+func (m Money) Add(o Money) Money {
+	return New(m.Amount.Add(o.Amount), m.combine(o))
+}
+
+func (m Money) Sub(o Money) Money {
+	return New(m.Amount.Sub(o.Amount), m.combine(o))
+}
+
+// Mul multiplies the amount by a plain factor such as a quantity or a ratio
+func (m Money) Mul(factor decimal.Decimal) Money {
+	return New(m.Amount.Mul(factor), m.Currency)
+}
+
+// MulInt multiplies the amount by a whole number such as a quantity
+func (m Money) MulInt(n int64) Money {
+	return m.Mul(decimal.NewFromInt(n))
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount.IsZero()
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount.IsNegative()
+}
+
+// Equal reports whether m and o are the same amount in the same currency
+func (m Money) Equal(o Money) bool {
+	return m.Currency == o.Currency && m.Amount.Equal(o.Amount)
+}
+
+func (m Money) LessThan(o Money) bool {
+	m.combine(o)
+	return m.Amount.LessThan(o.Amount)
+}
+
+// Round rounds the amount to the currency's settlement places using mode
+func (m Money) Round(mode RoundingMode) Money {
+	return New(mode.round(m.Amount, m.Currency.Places()), m.Currency)
+}
+
+// String formats the amount with at least the currency's settlement places
+// and any further places it actually carries, followed by the currency,
+// e.g. "249.00 AUD"
+func (m Money) String() string {
+	places := m.Currency.Places()
+	s := m.Amount.String()
+	if i := strings.IndexByte(s, '.'); i < 0 || int32(len(s)-i-1) < places {
+		s = m.Amount.StringFixed(places)
+	}
+	if m.Currency == "" {
+		return s
+	}
+	return s + " " + string(m.Currency)
+}
@@ -0,0 +1,127 @@
+package money_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+func TestParse(t *testing.T) {
+	m, err := money.Parse("499.99", money.AUD)
+	assert.NoError(t, err)
+	assert.Equal(t, "499.99 AUD", m.String())
+
+	_, err = money.Parse("four hundred", money.AUD)
+	assert.Error(t, err)
+}
+
+func TestMustParse_Panics(t *testing.T) {
+	assert.Panics(t, func() { money.MustParse("", money.AUD) })
+}
+
+func TestMoney_String(t *testing.T) {
+	testCases := []struct {
+		amount   string
+		expected string
+	}{
+		{"249", "249.00 AUD"},
+		{"109.5", "109.50 AUD"},
+		{"2718.95", "2718.95 AUD"},
+		{"33.335", "33.335 AUD"},
+		{"-10", "-10.00 AUD"},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, money.MustParse(tc.amount, money.AUD).String(), tc.amount)
+	}
+	assert.Equal(t, "0.00", money.Money{}.String())
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	a := money.MustParse("109.50", money.AUD)
+	b := money.MustParse("30.00", money.AUD)
+
+	assert.Equal(t, "139.50 AUD", a.Add(b).String())
+	assert.Equal(t, "79.50 AUD", a.Sub(b).String())
+	assert.Equal(t, "328.50 AUD", a.MulInt(3).String())
+	assert.Equal(t, "54.75 AUD", a.Mul(decimal.NewFromFloat(0.5)).String())
+	assert.True(t, b.LessThan(a))
+	assert.True(t, a.Sub(a).IsZero())
+	assert.True(t, b.Sub(a).IsNegative())
+}
+
+func TestMoney_NoDrift(t *testing.T) {
+	total := money.Zero(money.AUD)
+	for i := 0; i < 10000; i++ {
+		total = total.Add(money.MustParse("0.10", money.AUD))
+	}
+	assert.Equal(t, "1000.00 AUD", total.String())
+}
+
+func TestMoney_ZeroValueTakesOtherCurrency(t *testing.T) {
+	var total money.Money
+	total = total.Add(money.MustParse("1.00", money.USD))
+	assert.Equal(t, money.USD, total.Currency)
+}
+
+func TestMoney_CurrencyMismatchPanics(t *testing.T) {
+	aud := money.MustParse("1.00", money.AUD)
+	usd := money.MustParse("1.00", money.USD)
+
+	assert.False(t, aud.SameCurrency(usd))
+	assert.PanicsWithValue(t, internal.NewCurrencyMismatchError("AUD", "USD"), func() { aud.Add(usd) })
+}
+
+func TestMoney_Equal(t *testing.T) {
+	assert.True(t, money.MustParse("249", money.AUD).Equal(money.MustParse("249.00", money.AUD)))
+	assert.False(t, money.MustParse("249", money.AUD).Equal(money.MustParse("249", money.USD)))
+}
+
+func TestMoney_Round(t *testing.T) {
+	testCases := []struct {
+		amount   string
+		mode     money.RoundingMode
+		expected string
+	}{
+		{"0.125", money.HalfUp, "0.13 AUD"},
+		{"0.125", money.HalfEven, "0.12 AUD"},
+		{"0.135", money.HalfEven, "0.14 AUD"},
+		{"0.129", money.Down, "0.12 AUD"},
+		{"0.121", money.Up, "0.13 AUD"},
+		{"-0.125", money.HalfUp, "-0.13 AUD"},
+	}
+
+	for _, tc := range testCases {
+		rounded := money.MustParse(tc.amount, money.AUD).Round(tc.mode)
+		assert.Equal(t, tc.expected, rounded.String(), "%s %s", tc.amount, tc.mode)
+	}
+}
+
+func TestMoney_Round_ZeroDecimalCurrency(t *testing.T) {
+	rounded := money.MustParse("1234.5", money.JPY).Round(money.HalfEven)
+	assert.Equal(t, "1234 JPY", rounded.String())
+}
+
+func TestRounding_Total(t *testing.T) {
+	lines := []money.Money{
+		money.MustParse("33.335", money.AUD),
+		money.MustParse("10.005", money.AUD),
+	}
+
+	perLine := money.Rounding{Mode: money.HalfUp, Point: money.PerLine}
+	assert.Equal(t, "43.35 AUD", perLine.Total(money.AUD, lines).String())
+
+	perBasket := money.Rounding{Mode: money.HalfUp, Point: money.PerBasket}
+	assert.Equal(t, "43.34 AUD", perBasket.Total(money.AUD, lines).String())
+
+	bankers := money.Rounding{Mode: money.HalfEven, Point: money.PerLine}
+	assert.Equal(t, "43.34 AUD", bankers.Total(money.AUD, lines).String())
+}
+
+func TestRounding_Total_Empty(t *testing.T) {
+	assert.Equal(t, "0.00 AUD", money.DefaultRounding.Total(money.AUD, nil).String())
+}
@@ -0,0 +1,93 @@
+package money
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// RoundingMode decides which way an amount halfway between two settlement
+// values is rounded
+type RoundingMode int
+
+const (
+	// HalfUp rounds halves away from zero, 0.125 becomes 0.13
+	HalfUp RoundingMode = iota
+	// HalfEven rounds halves to the nearest even digit, also known as banker's
+	// rounding, 0.125 becomes 0.12
+	HalfEven
+	// Down truncates towards zero
+	Down
+	// Up rounds away from zero
+	Up
+)
+
+func (m RoundingMode) String() string {
+	switch m {
+	case HalfUp:
+		return "half-up"
+	case HalfEven:
+		return "half-even"
+	case Down:
+		return "down"
+	case Up:
+		return "up"
+	default:
+		return fmt.Sprintf("RoundingMode(%d)", int(m))
+	}
+}
+
+func (m RoundingMode) round(d decimal.Decimal, places int32) decimal.Decimal {
+	switch m {
+	case HalfEven:
+		return d.RoundBank(places)
+	case Down:
+		return d.RoundDown(places)
+	case Up:
+		return d.RoundUp(places)
+	default:
+		return d.Round(places)
+	}
+}
+
+// RoundingPoint decides where in a basket rounding takes place
+type RoundingPoint int
+
+const (
+	// PerBasket adds up exact line amounts and rounds the total once
+	PerBasket RoundingPoint = iota
+	// PerLine rounds every line before the lines are added up
+	PerLine
+)
+
+func (p RoundingPoint) String() string {
+	switch p {
+	case PerBasket:
+		return "per-basket"
+	case PerLine:
+		return "per-line"
+	default:
+		return fmt.Sprintf("RoundingPoint(%d)", int(p))
+	}
+}
+
+// Rounding combines a rounding mode with the point at which it is applied
+type Rounding struct {
+	Mode  RoundingMode
+	Point RoundingPoint
+}
+
+// DefaultRounding rounds the basket total once, halves away from zero
+var DefaultRounding = Rounding{Mode: HalfUp, Point: PerBasket}
+
+// Total adds up lines, all in the given currency, rounding them according to r
+func (r Rounding) Total(currency Currency, lines []Money) Money {
+	total := Zero(currency)
+	for _, line := range lines {
+		if r.Point == PerLine {
+			line = line.Round(r.Mode)
+		}
+		total = total.Add(line)
+	}
+	return total.Round(r.Mode)
+}
@@ -0,0 +1,125 @@
+package pricingrules
+
+import (
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+// PricedBasket is the basket as the item rules left it, offered to the basket
+// rules that run after them
+type PricedBasket struct {
+	Basket
+	// Prices holds what each item costs after the item rules
+	Prices []money.Money
+	// Adjustments holds the order-level adjustments made by earlier basket rules
+	Adjustments []Adjustment
+	// Products is the catalog snapshot the basket was priced from. Rules
+	// that look products up read them from here, or from the catalog as it
+	// is now when it is nil.
+	Products *catalog.Snapshot
+}
+
+// products returns the snapshot the basket was priced from, or else the
+// catalog as it is now
+func (b PricedBasket) products(catalog *catalog.Catalog) *catalog.Snapshot {
+	if b.Products != nil {
+		return b.Products
+	}
+	return catalog.Snapshot()
+}
+
+// Subtotal returns the discounted price of the items plus every order-level
+// adjustment made so far
+func (b PricedBasket) Subtotal() money.Money {
+	var total money.Money
+	for _, price := range b.Prices {
+		total = total.Add(price)
+	}
+	for _, adjustment := range b.Adjustments {
+		total = total.Add(adjustment.Amount)
+	}
+	return total
+}
+
+// Gift is an item a basket rule adds to the order for free. Price is its list
+// price, which is reported as discounted in full by Rule.
+type Gift struct {
+	SKU   string
+	Price money.Money
+	Rule  string
+}
+
+// BasketResult is what a basket rule adds to the order
+type BasketResult struct {
+	Adjustments []Adjustment
+	Gifts       []Gift
+}
+
+// BasketRule looks at the order as a whole once every item rule has run, for
+// example to take 5% off orders over $2000. Basket rules run in order, each
+// seeing the subtotal left by the rules before it.
+type BasketRule interface {
+	ApplyToBasket(basket PricedBasket, catalog *catalog.Catalog) (BasketResult, error)
+}
+
+// SpendDiscountRule takes Percent off the whole order once its subtotal reaches
+// MinSpend, for example 5% off when spending $2000 or more
+type SpendDiscountRule struct {
+	Name     string
+	MinSpend money.Money
+	Percent  decimal.Decimal
+}
+
+func (r *SpendDiscountRule) name() string {
+	return nameOr(r.Name, r.Percent.String()+"% off orders of "+r.MinSpend.String()+" or more")
+}
+
+func (r *SpendDiscountRule) ApplyToBasket(basket PricedBasket, catalog *catalog.Catalog) (BasketResult, error) {
+	if err := checkCurrency(basket.Basket, r.MinSpend); err != nil {
+		return BasketResult{}, err
+	}
+	subtotal := basket.Subtotal()
+	if len(basket.Items) == 0 || subtotal.LessThan(r.MinSpend) {
+		return BasketResult{}, nil
+	}
+	discounted := takeOff(subtotal, subtotal.Mul(r.Percent.Div(decimal.NewFromInt(100))))
+	off := discounted.Sub(subtotal)
+	if off.IsZero() {
+		return BasketResult{}, nil
+	}
+	return BasketResult{Adjustments: []Adjustment{{Rule: r.name(), Amount: off}}}, nil
+}
+
+// FreeGiftRule adds one SKU item to the order for free once its subtotal
+// reaches MinSpend, for example a free VGA adapter with orders of $1500 or more.
+// No gift is given while the product cannot be sold, or if it comes in
+// variants, since the gift must be a product a customer could buy.
+type FreeGiftRule struct {
+	Name     string
+	MinSpend money.Money
+	SKU      string
+}
+
+func (r *FreeGiftRule) name() string {
+	return nameOr(r.Name, "Free "+r.SKU+" with orders of "+r.MinSpend.String()+" or more")
+}
+
+func (r *FreeGiftRule) ApplyToBasket(basket PricedBasket, catalog *catalog.Catalog) (BasketResult, error) {
+	products := basket.products(catalog)
+	product, err := products.GetProduct(r.SKU)
+	if err != nil {
+		return BasketResult{}, err
+	}
+	if err := checkCurrency(basket.Basket, r.MinSpend); err != nil {
+		return BasketResult{}, err
+	}
+	if len(basket.Items) == 0 || basket.Subtotal().LessThan(r.MinSpend) {
+		return BasketResult{}, nil
+	}
+	if variants, _ := products.Variants(r.SKU); !product.Status.Sellable() || len(variants) > 0 {
+		return BasketResult{}, nil
+	}
+	gift := Gift{SKU: r.SKU, Price: money.New(product.PriceAt(basket.Time), products.Currency()), Rule: r.name()}
+	return BasketResult{Gifts: []Gift{gift}}, nil
+}
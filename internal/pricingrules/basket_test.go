@@ -0,0 +1,99 @@
+package pricingrules_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func pricedBasket(t *testing.T, c *catalog.Catalog, rules []pricingrules.PricingRule, skus ...string) pricingrules.PricedBasket {
+	basket, err := pricingrules.NewBasket(c, skus...)
+	assert.NoError(t, err)
+	claims, err := (&pricingrules.RuleSet{Rules: rules}).Apply(basket, c)
+	assert.NoError(t, err)
+	return pricingrules.PricedBasket{Basket: basket, Prices: basket.Prices(claims)}
+}
+
+func TestPricedBasket_Subtotal(t *testing.T) {
+	c := catalog.NewCatalog()
+	basket := pricedBasket(t, c, []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}, "atv", "atv", "atv", "vga")
+	assert.Equal(t, "249.00 AUD", basket.Subtotal().String())
+
+	basket.Adjustments = []pricingrules.Adjustment{{Rule: "Loyalty", Amount: aud("-9.00")}}
+	assert.Equal(t, "240.00 AUD", basket.Subtotal().String())
+}
+
+func TestSpendDiscountRule_ApplyToBasket(t *testing.T) {
+	c := catalog.NewCatalog()
+	rule := &pricingrules.SpendDiscountRule{MinSpend: aud("2000.00"), Percent: decimal.NewFromInt(5)}
+
+	// List prices reach the threshold but the discounted subtotal does not
+	bulk := []pricingrules.PricingRule{&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")}}
+	result, err := rule.ApplyToBasket(pricedBasket(t, c, bulk, repeat("ipd", 4)...), c)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Adjustments)
+
+	result, err = rule.ApplyToBasket(pricedBasket(t, c, nil, repeat("ipd", 4)...), c)
+	assert.NoError(t, err)
+	assert.Len(t, result.Adjustments, 1)
+	assert.Equal(t, "5% off orders of 2000.00 AUD or more", result.Adjustments[0].Rule)
+	assert.Equal(t, "-109.998 AUD", result.Adjustments[0].Amount.String())
+}
+
+func TestSpendDiscountRule_CurrencyMismatch(t *testing.T) {
+	c := catalog.NewCatalog()
+	rule := &pricingrules.SpendDiscountRule{MinSpend: money.MustParse("2000", money.USD), Percent: decimal.NewFromInt(5)}
+
+	_, err := rule.ApplyToBasket(pricedBasket(t, c, nil, "mbp"), c)
+	assert.EqualError(t, err, "currency mismatch: expected AUD, got USD")
+}
+
+func TestFreeGiftRule_ApplyToBasket(t *testing.T) {
+	c := catalog.NewCatalog()
+	rule := &pricingrules.FreeGiftRule{Name: "Free adapter", MinSpend: aud("1500.00"), SKU: "vga"}
+
+	result, err := rule.ApplyToBasket(pricedBasket(t, c, nil, "mbp"), c)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Gifts)
+
+	result, err = rule.ApplyToBasket(pricedBasket(t, c, nil, "mbp", "atv"), c)
+	assert.NoError(t, err)
+	assert.Len(t, result.Gifts, 1)
+	assert.Equal(t, "vga", result.Gifts[0].SKU)
+	assert.Equal(t, "30.00 AUD", result.Gifts[0].Price.String())
+	assert.Equal(t, "Free adapter", result.Gifts[0].Rule)
+
+	_, err = (&pricingrules.FreeGiftRule{MinSpend: aud("1"), SKU: "hdmi"}).ApplyToBasket(pricedBasket(t, c, nil, "mbp"), c)
+	assert.EqualError(t, err, "product not found: hdmi")
+}
+
+func TestFreeGiftRule_OnlyGivesSellableProducts(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	rule := &pricingrules.FreeGiftRule{MinSpend: aud("1500.00"), SKU: "vga"}
+	basket := pricedBasket(t, c, nil, "mbp", "atv")
+
+	// The gift is read from the snapshot the basket was priced from
+	basket.Products = c.Snapshot()
+	assert.NoError(t, c.SetStatus(ctx, "vga", catalog.Discontinued))
+	result, err := rule.ApplyToBasket(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, result.Gifts, 1)
+
+	basket.Products = nil
+	result, err = rule.ApplyToBasket(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Gifts)
+
+	// A parent is not a product anyone can be handed
+	assert.NoError(t, c.AddProduct(ctx, catalog.Product{SKU: "ipd-64", Name: "Super iPad 64GB", Price: decimal.RequireFromString("449.99"), Parent: "ipd"}))
+	result, err = (&pricingrules.FreeGiftRule{MinSpend: aud("1500.00"), SKU: "ipd"}).ApplyToBasket(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Gifts)
+}
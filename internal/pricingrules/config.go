@@ -0,0 +1,664 @@
+package pricingrules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v3"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+// Format is the encoding of a pricing rule document
+type Format string
+
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// FormatFromPath picks the document format from a file extension
+func FormatFromPath(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	default:
+		return "", fmt.Errorf("unsupported pricing rule file: %s", path)
+	}
+}
+
+// RuleBuilder builds a pricing rule from its configuration. Builders read
+// their parameters through p, which records every problem it finds, and
+// return p.Err() once they are done.
+type RuleBuilder func(p *Params) (PricingRule, error)
+
+// Registry maps rule type names used in configuration documents to the
+// builders that create them
+type Registry struct {
+	builders map[string]RuleBuilder
+}
+
+// NewRegistry returns a registry that knows every rule type in this package
+func NewRegistry() *Registry {
+	r := &Registry{builders: make(map[string]RuleBuilder)}
+	r.Register("three_for_two", buildThreeForTwo)
+	r.Register("buy_x_get_y", buildBuyXGetY)
+	r.Register("bulk_discount", buildBulkDiscount)
+	r.Register("percent_off", buildPercentOff)
+	r.Register("amount_off", buildAmountOff)
+	r.Register("tiered_price", buildTieredPrice)
+	r.Register("bundle_price", buildBundlePrice)
+	r.Register("free_with_purchase", buildFreeWithPurchase)
+	r.Register("rule_set", buildRuleSet)
+	r.Register("time_window", buildTimeWindow)
+	return r
+}
+
+// Register adds a rule type, replacing any builder already registered under
+// the same name
+func (r *Registry) Register(ruleType string, builder RuleBuilder) {
+	r.builders[ruleType] = builder
+}
+
+// document is the top level of a pricing rule document. Rules is a pointer so
+// a document without a rules key, such as an empty or truncated file, can be
+// told from one that deliberately lists no rules.
+type document struct {
+	Rules *[]map[string]any `json:"rules" yaml:"rules"`
+}
+
+// Load builds the rules described by a JSON or YAML document, in document
+// order, ready to be passed to a checkout. SKUs are checked against catalog.
+// Every problem in the document is reported, not just the first one. A
+// document must have a top-level rules key; "rules: []" means no rules.
+func (r *Registry) Load(reader io.Reader, format Format, catalog *catalog.Catalog) ([]PricingRule, error) {
+	var doc document
+	var err error
+	switch format {
+	case JSON:
+		decoder := json.NewDecoder(reader)
+		decoder.UseNumber()
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&doc)
+	case YAML:
+		decoder := yaml.NewDecoder(reader)
+		decoder.KnownFields(true)
+		err = decoder.Decode(&doc)
+	default:
+		return nil, fmt.Errorf("unsupported pricing rule format: %q", format)
+	}
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("decoding pricing rules: document is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding pricing rules: %w", err)
+	}
+	if doc.Rules == nil {
+		return nil, errors.New("decoding pricing rules: missing top-level rules list")
+	}
+	return r.buildAll("rules", *doc.Rules, catalog)
+}
+
+// LoadFile loads a pricing rule document, choosing the format from the file extension
+func (r *Registry) LoadFile(path string, catalog *catalog.Catalog) ([]PricingRule, error) {
+	format, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return r.Load(f, format, catalog)
+}
+
+func (r *Registry) buildAll(path string, configs []map[string]any, catalog *catalog.Catalog) ([]PricingRule, error) {
+	rules := make([]PricingRule, 0, len(configs))
+	var errs []error
+	for i, config := range configs {
+		rule, err := r.build(fmt.Sprintf("%s[%d]", path, i), config, catalog)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return rules, nil
+}
+
+func (r *Registry) build(path string, config map[string]any, catalog *catalog.Catalog) (PricingRule, error) {
+	p := &Params{
+		registry: r,
+		catalog:  catalog,
+		path:     path,
+		values:   config,
+		used:     make(map[string]bool),
+	}
+	ruleType := p.String("type")
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	builder, ok := r.builders[ruleType]
+	if !ok {
+		return nil, internal.NewInvalidRuleError(path, internal.NewUnknownRuleTypeError(ruleType))
+	}
+
+	rule, err := builder(p)
+	p.failUnused()
+	if p.Err() != nil {
+		return nil, p.Err()
+	}
+	if err != nil {
+		return nil, internal.NewInvalidRuleError(path, err)
+	}
+	return rule, nil
+}
+
+// Params gives a RuleBuilder typed access to the parameters of one rule. Each
+// accessor records a problem and returns a zero value if the parameter is
+// missing or invalid, so a builder can read everything before checking Err.
+type Params struct {
+	registry *Registry
+	catalog  *catalog.Catalog
+	path     string
+	values   map[string]any
+	used     map[string]bool
+	errs     []error
+}
+
+// failUnused records every parameter no accessor has read, in name order
+func (p *Params) failUnused() {
+	var unknown []string
+	for key := range p.values {
+		if !p.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		p.Fail(key, "unknown parameter")
+	}
+}
+
+// Catalog returns the catalog the rules are being built against
+func (p *Params) Catalog() *catalog.Catalog {
+	return p.catalog
+}
+
+// Err returns every problem recorded so far, or nil
+func (p *Params) Err() error {
+	return errors.Join(p.errs...)
+}
+
+// Fail records an invalid parameter
+func (p *Params) Fail(key, reason string) {
+	p.errs = append(p.errs, internal.NewInvalidRuleError(p.path, internal.NewInvalidRuleParamError(key, reason)))
+}
+
+func (p *Params) lookup(key string, required bool) (any, bool) {
+	p.used[key] = true
+	value, ok := p.values[key]
+	if !ok || value == nil {
+		if required {
+			p.Fail(key, "is required")
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (p *Params) str(key string, required bool) string {
+	value, ok := p.lookup(key, required)
+	if !ok {
+		return ""
+	}
+	s, isString := value.(string)
+	if !isString || (required && s == "") {
+		p.Fail(key, "must be a non-empty string")
+		return ""
+	}
+	return s
+}
+
+// String returns a required, non-empty string parameter
+func (p *Params) String(key string) string {
+	return p.str(key, true)
+}
+
+// OptionalString returns a string parameter, or "" if it is not set
+func (p *Params) OptionalString(key string) string {
+	return p.str(key, false)
+}
+
+func (p *Params) integer(key string, required bool, fallback int) int {
+	value, ok := p.lookup(key, required)
+	if !ok {
+		return fallback
+	}
+	d, err := toDecimal(value)
+	if err != nil || !d.IsInteger() {
+		p.Fail(key, "must be a whole number")
+		return fallback
+	}
+	return int(d.IntPart())
+}
+
+// Int returns a required whole number parameter
+func (p *Params) Int(key string) int {
+	return p.integer(key, true, 0)
+}
+
+// OptionalInt returns a whole number parameter, or fallback if it is not set
+func (p *Params) OptionalInt(key string, fallback int) int {
+	return p.integer(key, false, fallback)
+}
+
+func (p *Params) dec(key string, required bool, fallback decimal.Decimal) decimal.Decimal {
+	value, ok := p.lookup(key, required)
+	if !ok {
+		return fallback
+	}
+	d, err := toDecimal(value)
+	if err != nil {
+		p.Fail(key, "must be a number")
+		return fallback
+	}
+	return d
+}
+
+// Decimal returns a required decimal parameter, given either as a number or
+// as a string such as "0.15"
+func (p *Params) Decimal(key string) decimal.Decimal {
+	return p.dec(key, true, decimal.Zero)
+}
+
+// OptionalDecimal returns a decimal parameter, or fallback if it is not set
+func (p *Params) OptionalDecimal(key string, fallback decimal.Decimal) decimal.Decimal {
+	return p.dec(key, false, fallback)
+}
+
+func (p *Params) amount(key string, required bool) money.Money {
+	value, ok := p.lookup(key, required)
+	if !ok {
+		return money.Money{}
+	}
+	d, err := toDecimal(value)
+	if err != nil {
+		p.Fail(key, "must be an amount such as \"499.99\"")
+		return money.Money{}
+	}
+	if d.IsNegative() {
+		p.Fail(key, "must not be negative")
+		return money.Money{}
+	}
+	return money.New(d, p.catalog.Currency())
+}
+
+// Money returns a required, non-negative amount in the catalog currency
+func (p *Params) Money(key string) money.Money {
+	return p.amount(key, true)
+}
+
+// OptionalMoney returns a non-negative amount in the catalog currency, or a
+// zero amount if it is not set
+func (p *Params) OptionalMoney(key string) money.Money {
+	return p.amount(key, false)
+}
+
+// SKU returns a required SKU parameter that exists in the catalog, or a
+// selector such as "category:accessories", see Selector
+func (p *Params) SKU(key string) string {
+	sku := p.String(key)
+	if sku != "" {
+		p.checkSKU(sku)
+	}
+	return sku
+}
+
+// SKUs returns a required, non-empty list of SKUs that all exist in the
+// catalog, or selectors
+func (p *Params) SKUs(key string) []string {
+	value, ok := p.lookup(key, true)
+	if !ok {
+		return nil
+	}
+	list, isList := value.([]any)
+	if !isList || len(list) == 0 {
+		p.Fail(key, "must be a non-empty list of SKUs")
+		return nil
+	}
+	skus := make([]string, 0, len(list))
+	for _, item := range list {
+		sku, isString := item.(string)
+		if !isString || sku == "" {
+			p.Fail(key, "must be a non-empty list of SKUs")
+			return nil
+		}
+		p.checkSKU(sku)
+		skus = append(skus, sku)
+	}
+	return skus
+}
+
+func (p *Params) checkSKU(sku string) {
+	if err := checkTarget(p.catalog, sku); err != nil {
+		p.errs = append(p.errs, internal.NewInvalidRuleError(p.path, err))
+	}
+}
+
+// OptionalTime returns an RFC 3339 timestamp parameter such as
+// "2024-11-15T00:00:00+11:00", or the zero time if it is not set
+func (p *Params) OptionalTime(key string) time.Time {
+	value, ok := p.lookup(key, false)
+	if !ok {
+		return time.Time{}
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err == nil {
+			return t
+		}
+	}
+	p.Fail(key, "must be an RFC 3339 timestamp")
+	return time.Time{}
+}
+
+// Weekdays returns an optional list of day names such as ["sat", "sunday"]
+func (p *Params) Weekdays(key string) []time.Weekday {
+	value, ok := p.lookup(key, false)
+	if !ok {
+		return nil
+	}
+	list, isList := value.([]any)
+	if !isList {
+		p.Fail(key, "must be a list of days")
+		return nil
+	}
+	days := make([]time.Weekday, 0, len(list))
+	for _, item := range list {
+		name, _ := item.(string)
+		day, err := ParseWeekday(name)
+		if err != nil {
+			p.Fail(key, err.Error())
+			return nil
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// Objects calls read for each entry of a required, non-empty list of nested
+// parameter groups, such as the tiers of a volume schedule. Problems in an
+// entry are reported against its position, for example "rules[0].tiers[1]".
+func (p *Params) Objects(key string, read func(entry *Params)) {
+	value, ok := p.lookup(key, true)
+	if !ok {
+		return
+	}
+	list, isList := value.([]any)
+	if !isList || len(list) == 0 {
+		p.Fail(key, "must be a non-empty list")
+		return
+	}
+	for i, item := range list {
+		values, isMap := item.(map[string]any)
+		if !isMap {
+			p.Fail(fmt.Sprintf("%s[%d]", key, i), "must be a set of parameters")
+			continue
+		}
+		entry := &Params{
+			registry: p.registry,
+			catalog:  p.catalog,
+			path:     fmt.Sprintf("%s.%s[%d]", p.path, key, i),
+			values:   values,
+			used:     make(map[string]bool),
+		}
+		read(entry)
+		entry.failUnused()
+		p.errs = append(p.errs, entry.errs...)
+	}
+}
+
+// Rule builds a required nested rule
+func (p *Params) Rule(key string) PricingRule {
+	value, ok := p.lookup(key, true)
+	if !ok {
+		return nil
+	}
+	config, isMap := value.(map[string]any)
+	if !isMap {
+		p.Fail(key, "must be a rule")
+		return nil
+	}
+	rule, err := p.registry.build(p.path+"."+key, config, p.catalog)
+	if err != nil {
+		p.errs = append(p.errs, err)
+	}
+	return rule
+}
+
+// Rules builds a required, non-empty list of nested rules
+func (p *Params) Rules(key string) []PricingRule {
+	value, ok := p.lookup(key, true)
+	if !ok {
+		return nil
+	}
+	list, isList := value.([]any)
+	if !isList || len(list) == 0 {
+		p.Fail(key, "must be a non-empty list of rules")
+		return nil
+	}
+	configs := make([]map[string]any, 0, len(list))
+	for _, item := range list {
+		config, isMap := item.(map[string]any)
+		if !isMap {
+			p.Fail(key, "must be a non-empty list of rules")
+			return nil
+		}
+		configs = append(configs, config)
+	}
+	rules, err := p.registry.buildAll(p.path+"."+key, configs, p.catalog)
+	if err != nil {
+		p.errs = append(p.errs, err)
+	}
+	return rules
+}
+
+func toDecimal(value any) (decimal.Decimal, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return decimal.NewFromString(v.String())
+	case string:
+		return decimal.NewFromString(v)
+	case int:
+		return decimal.NewFromInt(int64(v)), nil
+	case int64:
+		return decimal.NewFromInt(v), nil
+	case uint64:
+		return decimal.NewFromUint64(v), nil
+	case float64:
+		return decimal.NewFromFloat(v), nil
+	default:
+		return decimal.Zero, fmt.Errorf("not a number: %v", value)
+	}
+}
+
+func buildThreeForTwo(p *Params) (PricingRule, error) {
+	return &ThreeForTwoRule{
+		Name: p.OptionalString("name"),
+		SKU:  p.SKU("sku"),
+	}, p.Err()
+}
+
+func buildBuyXGetY(p *Params) (PricingRule, error) {
+	rule := &BuyXGetYRule{
+		Name:       p.OptionalString("name"),
+		SKUs:       p.SKUs("skus"),
+		Buy:        p.Int("buy"),
+		Get:        p.Int("get"),
+		Percent:    p.OptionalDecimal("percent", decimal.NewFromInt(100)),
+		MaxRepeats: p.OptionalInt("max_repeats", 0),
+	}
+	if rule.Buy < 0 {
+		p.Fail("buy", "must not be negative")
+	}
+	if rule.Get < 1 {
+		p.Fail("get", "must be at least 1")
+	}
+	if rule.Percent.IsNegative() || rule.Percent.GreaterThan(decimal.NewFromInt(100)) {
+		p.Fail("percent", "must be between 0 and 100")
+	}
+	if rule.MaxRepeats < 0 {
+		p.Fail("max_repeats", "must not be negative")
+	}
+	if target := p.OptionalString("discount"); target != "" {
+		parsed, err := ParseDiscountTarget(target)
+		if err != nil {
+			p.Fail("discount", err.Error())
+		}
+		rule.Target = parsed
+	}
+	return rule, p.Err()
+}
+
+func buildBulkDiscount(p *Params) (PricingRule, error) {
+	rule := &BulkDiscountRule{
+		Name:        p.OptionalString("name"),
+		SKU:         p.SKU("sku"),
+		MinQuantity: p.Int("min_quantity"),
+		NewPrice:    p.Money("new_price"),
+	}
+	if rule.MinQuantity < 0 {
+		p.Fail("min_quantity", "must not be negative")
+	}
+	return rule, p.Err()
+}
+
+func buildPercentOff(p *Params) (PricingRule, error) {
+	rule := &PercentOffRule{
+		Name:        p.OptionalString("name"),
+		SKU:         p.SKU("sku"),
+		Percent:     p.Decimal("percent"),
+		MinQuantity: p.OptionalInt("min_quantity", 0),
+		Cap:         p.OptionalMoney("cap"),
+	}
+	if rule.Percent.IsNegative() || rule.Percent.GreaterThan(decimal.NewFromInt(100)) {
+		p.Fail("percent", "must be between 0 and 100")
+	}
+	if rule.MinQuantity < 0 {
+		p.Fail("min_quantity", "must not be negative")
+	}
+	return rule, p.Err()
+}
+
+func buildAmountOff(p *Params) (PricingRule, error) {
+	rule := &AmountOffRule{
+		Name:        p.OptionalString("name"),
+		SKU:         p.SKU("sku"),
+		Amount:      p.Money("amount"),
+		MinQuantity: p.OptionalInt("min_quantity", 0),
+		Cap:         p.OptionalMoney("cap"),
+	}
+	if rule.MinQuantity < 0 {
+		p.Fail("min_quantity", "must not be negative")
+	}
+	return rule, p.Err()
+}
+
+func buildTieredPrice(p *Params) (PricingRule, error) {
+	rule := &TieredPriceRule{
+		Name: p.OptionalString("name"),
+		SKU:  p.SKU("sku"),
+	}
+	p.Objects("tiers", func(entry *Params) {
+		rule.Tiers = append(rule.Tiers, Tier{
+			MinQuantity: entry.Int("min_quantity"),
+			MaxQuantity: entry.OptionalInt("max_quantity", 0),
+			Price:       entry.Money("price"),
+		})
+	})
+	if mode := p.OptionalString("mode"); mode != "" {
+		parsed, err := ParseTierMode(mode)
+		if err != nil {
+			p.Fail("mode", err.Error())
+		}
+		rule.Mode = parsed
+	}
+	if p.Err() == nil {
+		if err := rule.Validate(); err != nil {
+			p.Fail("tiers", err.Error())
+		}
+	}
+	return rule, p.Err()
+}
+
+func buildBundlePrice(p *Params) (PricingRule, error) {
+	return &BundlePriceRule{
+		Name:  p.OptionalString("name"),
+		SKUs:  p.SKUs("skus"),
+		Price: p.Money("price"),
+	}, p.Err()
+}
+
+func buildFreeWithPurchase(p *Params) (PricingRule, error) {
+	return &FreeWithPurchaseRule{
+		Name:    p.OptionalString("name"),
+		SKU:     p.SKU("sku"),
+		FreeSKU: p.SKU("free_sku"),
+	}, p.Err()
+}
+
+func buildRuleSet(p *Params) (PricingRule, error) {
+	rule := &RuleSet{Rules: p.Rules("rules"), Budget: p.OptionalInt("budget", 0)}
+	if rule.Budget < 0 {
+		p.Fail("budget", "must not be negative")
+	}
+	if policy := p.OptionalString("policy"); policy != "" {
+		parsed, err := ParseStackingPolicy(policy)
+		if err != nil {
+			p.Fail("policy", err.Error())
+		}
+		rule.Policy = parsed
+	}
+	return rule, p.Err()
+}
+
+func buildTimeWindow(p *Params) (PricingRule, error) {
+	window := Window{
+		Start:    p.OptionalTime("start"),
+		End:      p.OptionalTime("end"),
+		Days:     p.Weekdays("days"),
+		FromHour: p.OptionalInt("from_hour", 0),
+		ToHour:   p.OptionalInt("to_hour", 0),
+	}
+	if timezone := p.OptionalString("timezone"); timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			p.Fail("timezone", err.Error())
+		}
+		window.Location = location
+	}
+	if err := window.validate(); err != nil {
+		p.Fail("window", err.Error())
+	}
+	return &TimeWindowRule{Window: window, Rule: p.Rule("rule")}, p.Err()
+}
@@ -0,0 +1,401 @@
+package pricingrules_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+const yamlRules = `
+rules:
+  - type: three_for_two
+    sku: atv
+  - type: bulk_discount
+    name: iPad bulk
+    sku: ipd
+    min_quantity: 5
+    new_price: "499.99"
+  - type: bundle_price
+    skus: [mbp, vga]
+    price: 1400
+  - type: rule_set
+    policy: best-for-customer
+    rules:
+      - type: free_with_purchase
+        sku: mbp
+        free_sku: vga
+`
+
+const jsonRules = `{
+  "rules": [
+    {"type": "three_for_two", "sku": "atv"},
+    {"type": "bulk_discount", "name": "iPad bulk", "sku": "ipd", "min_quantity": 5, "new_price": 499.99},
+    {"type": "bundle_price", "skus": ["mbp", "vga"], "price": "1400"},
+    {"type": "rule_set", "policy": "best-for-customer", "rules": [
+      {"type": "free_with_purchase", "sku": "mbp", "free_sku": "vga"}
+    ]}
+  ]
+}`
+
+func TestRegistry_Load(t *testing.T) {
+	c := catalog.NewCatalog()
+	registry := pricingrules.NewRegistry()
+
+	for format, doc := range map[pricingrules.Format]string{pricingrules.YAML: yamlRules, pricingrules.JSON: jsonRules} {
+		rules, err := registry.Load(strings.NewReader(doc), format, c)
+		assert.NoError(t, err, format)
+		assert.Len(t, rules, 4, format)
+
+		assert.Equal(t, &pricingrules.ThreeForTwoRule{SKU: "atv"}, rules[0], format)
+
+		bulk, ok := rules[1].(*pricingrules.BulkDiscountRule)
+		assert.True(t, ok, format)
+		assert.Equal(t, "iPad bulk", bulk.Name)
+		assert.Equal(t, "ipd", bulk.SKU)
+		assert.Equal(t, 5, bulk.MinQuantity)
+		assert.Equal(t, "499.99 AUD", bulk.NewPrice.String())
+
+		bundle, ok := rules[2].(*pricingrules.BundlePriceRule)
+		assert.True(t, ok, format)
+		assert.Equal(t, []string{"mbp", "vga"}, bundle.SKUs)
+		assert.Equal(t, "1400.00 AUD", bundle.Price.String())
+
+		set, ok := rules[3].(*pricingrules.RuleSet)
+		assert.True(t, ok, format)
+		assert.Equal(t, pricingrules.BestForCustomer, set.Policy)
+		assert.Equal(t, []pricingrules.PricingRule{&pricingrules.FreeWithPurchaseRule{SKU: "mbp", FreeSKU: "vga"}}, set.Rules)
+	}
+}
+
+func TestRegistry_Load_UnknownRuleType(t *testing.T) {
+	doc := `{"rules": [{"type": "buy_one_get_two", "sku": "atv"}]}`
+
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.JSON, catalog.NewCatalog())
+	assert.EqualError(t, err, `rules[0]: unknown rule type: "buy_one_get_two"`)
+
+	var unknown internal.ErrUnknownRuleType
+	assert.True(t, errors.As(err, &unknown))
+	assert.Equal(t, "buy_one_get_two", unknown.Type)
+}
+
+func TestRegistry_Load_MissingSKU(t *testing.T) {
+	doc := `
+rules:
+  - type: rule_set
+    rules:
+      - type: bundle_price
+        skus: [ipd, hdmi]
+        price: "599.00"
+`
+
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.EqualError(t, err, "rules[0].rules[0]: product not found: hdmi")
+
+	var notFound internal.ErrProductNotFound
+	assert.True(t, errors.As(err, &notFound))
+	assert.Equal(t, "hdmi", notFound.SKU)
+}
+
+func TestRegistry_Load_InvalidParameters(t *testing.T) {
+	doc := `
+rules:
+  - type: bulk_discount
+    sku: ipd
+    min_quantity: -1
+    new_price: "-499.99"
+  - type: three_for_two
+  - type: bundle_price
+    skus: []
+    price: cheap
+    discount: 10
+  - type: rule_set
+    policy: cheapest
+    budget: -1
+    rules:
+      - type: three_for_two
+        sku: atv
+  - sku: atv
+`
+
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.Error(t, err)
+
+	// Every problem is reported at once
+	expected := []string{
+		"rules[0]: invalid parameter new_price: must not be negative",
+		"rules[0]: invalid parameter min_quantity: must not be negative",
+		"rules[1]: invalid parameter sku: is required",
+		"rules[2]: invalid parameter skus: must be a non-empty list of SKUs",
+		`rules[2]: invalid parameter price: must be an amount such as "499.99"`,
+		"rules[2]: invalid parameter discount: unknown parameter",
+		"rules[3]: invalid parameter budget: must not be negative",
+		`rules[3]: invalid parameter policy: unknown stacking policy: "cheapest"`,
+		"rules[4]: invalid parameter type: is required",
+	}
+	assert.Equal(t, strings.Join(expected, "\n"), err.Error())
+
+	var invalid internal.ErrInvalidRuleParam
+	assert.True(t, errors.As(err, &invalid))
+}
+
+func TestRegistry_Load_DiscountRules(t *testing.T) {
+	doc := `
+rules:
+  - type: percent_off
+    sku: atv
+    percent: 15
+  - type: amount_off
+    sku: ipd
+    amount: "100.00"
+    min_quantity: 2
+    cap: "300.00"
+`
+
+	rules, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	percent, ok := rules[0].(*pricingrules.PercentOffRule)
+	assert.True(t, ok)
+	assert.Equal(t, "15", percent.Percent.String())
+	assert.True(t, percent.Cap.IsZero())
+
+	amount, ok := rules[1].(*pricingrules.AmountOffRule)
+	assert.True(t, ok)
+	assert.Equal(t, "100.00 AUD", amount.Amount.String())
+	assert.Equal(t, 2, amount.MinQuantity)
+	assert.Equal(t, "300.00 AUD", amount.Cap.String())
+}
+
+func TestRegistry_Load_BuyXGetY(t *testing.T) {
+	doc := `{"rules": [
+  {"type": "buy_x_get_y", "skus": ["atv", "vga"], "buy": 3, "get": 1, "percent": 50, "discount": "most-expensive", "max_repeats": 2},
+  {"type": "buy_x_get_y", "skus": ["atv"], "buy": -1, "get": 0, "percent": "110", "discount": "random", "max_repeats": -1}
+]}`
+
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.JSON, catalog.NewCatalog())
+	expected := []string{
+		"rules[1]: invalid parameter buy: must not be negative",
+		"rules[1]: invalid parameter get: must be at least 1",
+		"rules[1]: invalid parameter percent: must be between 0 and 100",
+		"rules[1]: invalid parameter max_repeats: must not be negative",
+		`rules[1]: invalid parameter discount: unknown discount target: "random"`,
+	}
+	assert.EqualError(t, err, strings.Join(expected, "\n"))
+
+	doc = `{"rules": [{"type": "buy_x_get_y", "skus": ["atv"], "buy": 2, "get": 1}]}`
+	rules, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.JSON, catalog.NewCatalog())
+	assert.NoError(t, err)
+	rule, ok := rules[0].(*pricingrules.BuyXGetYRule)
+	assert.True(t, ok)
+	assert.Equal(t, "100", rule.Percent.String())
+	assert.Equal(t, pricingrules.DiscountCheapest, rule.Target)
+	assert.Equal(t, 0, rule.MaxRepeats)
+}
+
+func TestRegistry_Load_TieredPrice(t *testing.T) {
+	doc := `
+rules:
+  - type: tiered_price
+    sku: ipd
+    mode: graduated
+    tiers:
+      - min_quantity: 5
+        max_quantity: 9
+        price: "499.99"
+      - min_quantity: 10
+        price: "479.99"
+`
+
+	rules, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.NoError(t, err)
+	rule, ok := rules[0].(*pricingrules.TieredPriceRule)
+	assert.True(t, ok)
+	assert.Equal(t, pricingrules.Graduated, rule.Mode)
+	assert.Len(t, rule.Tiers, 2)
+	assert.Equal(t, 9, rule.Tiers[0].MaxQuantity)
+	assert.Equal(t, "479.99 AUD", rule.Tiers[1].Price.String())
+}
+
+func TestRegistry_Load_InvalidTieredPrice(t *testing.T) {
+	doc := `
+rules:
+  - type: tiered_price
+    sku: ipd
+    mode: stepped
+    tiers:
+      - min_quantity: 5
+        price: "-499.99"
+      - max_quantity: 9
+        price: "479.99"
+        discount: 10
+      - 10
+  - type: tiered_price
+    sku: ipd
+    tiers:
+      - min_quantity: 10
+        price: "479.99"
+      - min_quantity: 5
+        max_quantity: 9
+        price: "499.99"
+  - type: tiered_price
+    sku: ipd
+    tiers: []
+`
+
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	expected := []string{
+		"rules[0].tiers[0]: invalid parameter price: must not be negative",
+		"rules[0].tiers[1]: invalid parameter min_quantity: is required",
+		"rules[0].tiers[1]: invalid parameter discount: unknown parameter",
+		"rules[0]: invalid parameter tiers[2]: must be a set of parameters",
+		`rules[0]: invalid parameter mode: unknown tier mode: "stepped"`,
+		"rules[1]: invalid parameter tiers: tier 2 must start after tier 1",
+		"rules[2]: invalid parameter tiers: must be a non-empty list",
+	}
+	assert.EqualError(t, err, strings.Join(expected, "\n"))
+}
+
+func TestRegistry_Load_InvalidDiscountRules(t *testing.T) {
+	doc := `
+rules:
+  - type: percent_off
+    sku: mbp
+    percent: 150
+  - type: amount_off
+    sku: mbp
+    amount: "-50"
+    min_quantity: -2
+`
+
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	expected := []string{
+		"rules[0]: invalid parameter percent: must be between 0 and 100",
+		"rules[1]: invalid parameter amount: must not be negative",
+		"rules[1]: invalid parameter min_quantity: must not be negative",
+	}
+	assert.EqualError(t, err, strings.Join(expected, "\n"))
+}
+
+func TestRegistry_Load_TimeWindow(t *testing.T) {
+	doc := `
+rules:
+  - type: time_window
+    start: "2024-11-29T00:00:00+11:00"
+    end: "2024-12-03T00:00:00+11:00"
+    days: [fri, saturday]
+    from_hour: 9
+    to_hour: 17
+    timezone: Australia/Melbourne
+    rule:
+      type: three_for_two
+      sku: atv
+`
+
+	rules, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+
+	rule, ok := rules[0].(*pricingrules.TimeWindowRule)
+	assert.True(t, ok)
+	assert.Equal(t, &pricingrules.ThreeForTwoRule{SKU: "atv"}, rule.Rule)
+	assert.Equal(t, []time.Weekday{time.Friday, time.Saturday}, rule.Window.Days)
+	assert.Equal(t, 9, rule.Window.FromHour)
+	assert.Equal(t, 17, rule.Window.ToHour)
+	assert.Equal(t, "Australia/Melbourne", rule.Window.Location.String())
+	assert.True(t, rule.Window.Start.Equal(time.Date(2024, 11, 28, 13, 0, 0, 0, time.UTC)))
+}
+
+func TestRegistry_Load_InvalidTimeWindow(t *testing.T) {
+	doc := `
+rules:
+  - type: time_window
+    start: "2024-12-03T00:00:00Z"
+    end: "2024-11-29"
+    days: [someday]
+    timezone: Mars/Olympus_Mons
+  - type: time_window
+    start: "2024-12-03T00:00:00Z"
+    end: "2024-11-29T00:00:00Z"
+    to_hour: 25
+    rule:
+      type: three_for_two
+      sku: hdmi
+`
+
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.Error(t, err)
+
+	expected := []string{
+		"rules[0]: invalid parameter end: must be an RFC 3339 timestamp",
+		`rules[0]: invalid parameter days: unknown day of the week: "someday"`,
+		"rules[0]: invalid parameter timezone: unknown time zone Mars/Olympus_Mons",
+		"rules[0]: invalid parameter rule: is required",
+		"rules[1]: invalid parameter window: window ends before it starts",
+		"rules[1].rule: product not found: hdmi",
+	}
+	assert.Equal(t, strings.Join(expected, "\n"), err.Error())
+}
+
+func TestRegistry_Load_Malformed(t *testing.T) {
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(`{"rules": [`), pricingrules.JSON, catalog.NewCatalog())
+	assert.ErrorContains(t, err, "decoding pricing rules")
+
+	_, err = pricingrules.NewRegistry().Load(strings.NewReader(`promotions: []`), pricingrules.YAML, catalog.NewCatalog())
+	assert.ErrorContains(t, err, "decoding pricing rules")
+
+	_, err = pricingrules.NewRegistry().Load(strings.NewReader(``), "toml", catalog.NewCatalog())
+	assert.EqualError(t, err, `unsupported pricing rule format: "toml"`)
+}
+
+func TestRegistry_Load_Empty(t *testing.T) {
+	// An empty or truncated file must not pass for a file with no promotions
+	for _, format := range []pricingrules.Format{pricingrules.JSON, pricingrules.YAML} {
+		_, err := pricingrules.NewRegistry().Load(strings.NewReader(``), format, catalog.NewCatalog())
+		assert.EqualError(t, err, "decoding pricing rules: document is empty", format)
+		_, err = pricingrules.NewRegistry().Load(strings.NewReader(`{}`), format, catalog.NewCatalog())
+		assert.EqualError(t, err, "decoding pricing rules: missing top-level rules list", format)
+
+		rules, err := pricingrules.NewRegistry().Load(strings.NewReader(`{"rules": []}`), format, catalog.NewCatalog())
+		assert.NoError(t, err, format)
+		assert.Empty(t, rules)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	registry := pricingrules.NewRegistry()
+	registry.Register("half_price", func(p *pricingrules.Params) (pricingrules.PricingRule, error) {
+		sku := p.SKU("sku")
+		return &pricingrules.BulkDiscountRule{SKU: sku, NewPrice: aud("274.995")}, p.Err()
+	})
+
+	rules, err := registry.Load(strings.NewReader(`{"rules": [{"type": "half_price", "sku": "ipd"}]}`), pricingrules.JSON, catalog.NewCatalog())
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+}
+
+func TestRegistry_LoadFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yml")
+	err := os.WriteFile(path, []byte(yamlRules), 0o600)
+	assert.NoError(t, err)
+
+	rules, err := pricingrules.NewRegistry().LoadFile(path, catalog.NewCatalog())
+	assert.NoError(t, err)
+	assert.Len(t, rules, 4)
+
+	_, err = pricingrules.NewRegistry().LoadFile(filepath.Join(dir, "rules.txt"), catalog.NewCatalog())
+	assert.Error(t, err)
+
+	_, err = pricingrules.NewRegistry().LoadFile(filepath.Join(dir, "missing.json"), catalog.NewCatalog())
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
@@ -0,0 +1,252 @@
+package pricingrules
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+// DefaultSearchBudget is how much work Optimise may spend when no budget is
+// given: ten thousand search steps over a basket of a hundred items
+const DefaultSearchBudget = 1000000
+
+// Step is one rule's part in an assignment: the claims it made on the items
+// still open when it ran
+type Step struct {
+	// Rule is the position of the rule in the list that was optimised
+	Rule   int
+	Name   string
+	Claims []Claim
+}
+
+// Assignment is the outcome of Optimise: which rule priced which items, and
+// how thoroughly the search was able to look for it
+type Assignment struct {
+	Steps []Step
+	Total money.Money
+	// Evaluations counts the work the search did, as the basket items each
+	// rule evaluation and each search node passed over
+	Evaluations int
+	// Exhausted reports that the budget ran out, after which the search
+	// finished with the rules in list order. The total is then the lowest
+	// found rather than the lowest possible.
+	Exhausted bool
+}
+
+// Claims returns the claims of every step, in order
+func (a Assignment) Claims() []Claim {
+	var claims []Claim
+	for _, step := range a.Steps {
+		claims = append(claims, step.Claims...)
+	}
+	return claims
+}
+
+// String explains the assignment, one line per rule that claimed items
+func (a Assignment) String() string {
+	var b strings.Builder
+	for _, step := range a.Steps {
+		var indexes []string
+		for _, claim := range step.Claims {
+			for _, item := range claim.Items {
+				index := fmt.Sprint(item.Index)
+				if item.Quantity > 1 {
+					index += fmt.Sprintf(" (%d of them)", item.Quantity)
+				}
+				indexes = append(indexes, index)
+			}
+		}
+		fmt.Fprintf(&b, "%s (rule %d) claimed items %s\n", step.Name, step.Rule+1, strings.Join(indexes, ", "))
+	}
+	search := "searched in full"
+	if a.Exhausted {
+		search = "search budget exhausted"
+	}
+	fmt.Fprintf(&b, "Total %s; %s; items examined: %d\n", a.Total, search, a.Evaluations)
+	return b.String()
+}
+
+// Optimise looks for the assignment of rules to items that gives the basket
+// its lowest total. Each rule is used at most once, on the items no earlier
+// step claimed, and may keep any leading part of the claims it makes there, so
+// per-basket limits such as repeat caps still hold. A claim that repeats a
+// group, such as several bundles out of the same lines, counts as that many
+// claims. Ties go to the assignment
+// that applies rules closest to list order, which makes the result match an
+// Exclusive RuleSet whenever that is already the cheapest.
+//
+// budget bounds the work of the search. Every step is charged for the basket
+// items it passes over: a search node for the whole basket, whose claimed
+// items make its key, and a rule evaluation for the items still open, so a
+// large basket affords fewer steps than a small one. Zero means
+// DefaultSearchBudget. Once the budget is spent, the remaining items are
+// priced by the unused rules in list order, which takes at most a few steps
+// per rule.
+func Optimise(basket Basket, rules []PricingRule, catalog *catalog.Catalog, budget int) (Assignment, error) {
+	if budget <= 0 {
+		budget = DefaultSearchBudget
+	}
+	o := &optimiser{
+		basket:  basket,
+		rules:   rules,
+		catalog: catalog,
+		budget:  budget,
+		memo:    make(map[string]plan),
+	}
+	best, err := o.search(make([]int, len(basket.Items)), make([]bool, len(rules)))
+	if err != nil {
+		return Assignment{}, err
+	}
+	return Assignment{
+		Steps:       best.steps,
+		Total:       best.total,
+		Evaluations: o.evaluations,
+		Exhausted:   o.exhausted,
+	}, nil
+}
+
+// plan prices the items still open at a search node
+type plan struct {
+	steps []Step
+	total money.Money
+}
+
+type optimiser struct {
+	basket      Basket
+	rules       []PricingRule
+	catalog     *catalog.Catalog
+	budget      int
+	evaluations int
+	exhausted   bool
+	// memo holds the best plan found for each combination of claimed items
+	// and used rules
+	memo map[string]plan
+}
+
+func (o *optimiser) search(taken []int, used []bool) (plan, error) {
+	// A node costs a pass over the basket to find its key and open items,
+	// so it is charged even when its plan is already known
+	o.spend(len(taken))
+	key := stateKey(taken, used)
+	if best, ok := o.memo[key]; ok {
+		return best, nil
+	}
+	open, positions := o.basket.without(taken)
+	var best *plan
+	for r, rule := range o.rules {
+		if used[r] || len(open.Items) == 0 {
+			continue
+		}
+		greedy := o.spend(len(open.Items))
+		claims, err := rule.Apply(open, o.catalog)
+		if err != nil {
+			return plan{}, err
+		}
+		if err := checkClaims(rule, open, claims); err != nil {
+			return plan{}, err
+		}
+		claims = mapClaims(open, claims, positions)
+
+		// Taking every claim comes first so list order wins ties
+		repeats := 0
+		for _, claim := range claims {
+			repeats += claim.repeats()
+		}
+		for k := repeats; k >= 1; k-- {
+			if k < repeats && o.evaluations >= o.budget {
+				o.exhausted = true
+				break
+			}
+			kept := leading(claims, k)
+			nextTaken := append([]int(nil), taken...)
+			for _, claim := range kept {
+				for _, item := range claim.Items {
+					nextTaken[item.Index] += item.Quantity
+				}
+			}
+			nextUsed := append([]bool(nil), used...)
+			nextUsed[r] = true
+			rest, err := o.search(nextTaken, nextUsed)
+			if err != nil {
+				return plan{}, err
+			}
+			total := rest.total
+			for _, claim := range kept {
+				total = total.Add(claim.Price())
+			}
+			if best == nil || total.LessThan(best.total) {
+				step := Step{Rule: r, Name: ruleName(rule), Claims: kept}
+				best = &plan{steps: append([]Step{step}, rest.steps...), total: total}
+			}
+			if greedy {
+				break
+			}
+		}
+		if greedy && best != nil {
+			break
+		}
+	}
+
+	// Leaving the open items at their basket price
+	if listed := open.Total(nil); best == nil || listed.LessThan(best.total) {
+		best = &plan{total: listed}
+	}
+	o.memo[key] = *best
+	return *best, nil
+}
+
+// leading returns the first n groups the claims price, cutting down the claim
+// they end in
+func leading(claims []Claim, n int) []Claim {
+	var kept []Claim
+	for _, claim := range claims {
+		if n == 0 {
+			break
+		}
+		if claim.repeats() > n {
+			claim = claim.first(n)
+		}
+		n -= claim.repeats()
+		kept = append(kept, claim)
+	}
+	return kept
+}
+
+// spend charges a search step that passes over the given number of items
+// against the budget and reports whether the budget had already run out
+func (o *optimiser) spend(items int) bool {
+	spent := o.evaluations >= o.budget
+	if spent {
+		o.exhausted = true
+	}
+	o.evaluations += max(items, 1)
+	return spent
+}
+
+// stateKey packs how much of each item is claimed and the used rules into a
+// string, a varint per item followed by a bit per rule
+func stateKey(taken []int, used []bool) string {
+	key := make([]byte, 0, len(taken)+(len(used)+7)/8)
+	for _, n := range taken {
+		key = binary.AppendUvarint(key, uint64(n))
+	}
+	flags := make([]byte, (len(used)+7)/8)
+	for i, flag := range used {
+		if flag {
+			flags[i/8] |= 1 << (i % 8)
+		}
+	}
+	return string(append(key, flags...))
+}
+
+// ruleName returns the name a rule reports its adjustments under, or its type
+// for rules that have no name
+func ruleName(rule PricingRule) string {
+	if named, ok := rule.(interface{ name() string }); ok {
+		return named.name()
+	}
+	return fmt.Sprintf("%T", rule)
+}
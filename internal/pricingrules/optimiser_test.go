@@ -0,0 +1,177 @@
+package pricingrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func TestOptimise_IndependentOfRuleOrder(t *testing.T) {
+	c := catalog.NewCatalog()
+	bulk := &pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")}
+	bundle := &pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")}
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd", "ipd", "ipd", "ipd", "atv")
+	assert.NoError(t, err)
+
+	for _, rules := range [][]pricingrules.PricingRule{{bulk, bundle}, {bundle, bulk}} {
+		assignment, err := pricingrules.Optimise(basket, rules, c, 0)
+		assert.NoError(t, err)
+		assert.False(t, assignment.Exhausted)
+		assert.Equal(t, "2609.45 AUD", assignment.Total.String())
+		assert.Equal(t, assignment.Total.String(), basket.Total(assignment.Claims()).String())
+		assert.Len(t, assignment.Steps, 1)
+		assert.Equal(t, "Bulk discount on ipd", assignment.Steps[0].Name)
+	}
+}
+
+func TestOptimise_TakesPartOfARulesClaims(t *testing.T) {
+	c := catalog.NewCatalog()
+	rules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
+	}
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd", "ipd", "ipd", "ipd", "atv", "atv")
+	assert.NoError(t, err)
+
+	// One bundle leaves four iPads for the bulk price, which beats both
+	// two bundles and bulk pricing all five iPads
+	assignment, err := pricingrules.Optimise(basket, rules, c, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "2708.46 AUD", assignment.Total.String())
+	assert.Len(t, assignment.Steps, 2)
+	assert.Equal(t, 0, assignment.Steps[0].Rule)
+	assert.Len(t, assignment.Steps[0].Claims, 1)
+	assert.Equal(t, 1, assignment.Steps[1].Rule)
+
+	exclusive, err := (&pricingrules.RuleSet{Rules: rules}).Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "2847.97 AUD", basket.Total(exclusive).String())
+}
+
+func TestOptimise_TakesPartOfARepeatedClaim(t *testing.T) {
+	c := catalog.NewCatalog()
+	rules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
+	}
+	basket := pricingrules.Basket{Items: []pricingrules.Item{line(t, c, "ipd", 5), line(t, c, "atv", 2)}}
+
+	// The bundle claims both sets from the two lines at once, and the
+	// optimiser keeps only one of them
+	assignment, err := pricingrules.Optimise(basket, rules, c, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "2708.46 AUD", assignment.Total.String())
+	assert.Equal(t, assignment.Total.String(), basket.Total(assignment.Claims()).String())
+	assert.Equal(t, 1, assignment.Steps[0].Claims[0].Repeats)
+	assert.Contains(t, assignment.String(), "Bulk discount on ipd (rule 2) claimed items 0 (4 of them)\n")
+}
+
+func TestOptimise_TiesKeepListOrder(t *testing.T) {
+	c := catalog.NewCatalog()
+	rules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{Name: "First", SKU: "atv"},
+		&pricingrules.ThreeForTwoRule{Name: "Second", SKU: "atv"},
+	}
+
+	basket, err := pricingrules.NewBasket(c, repeat("atv", 6)...)
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		assignment, err := pricingrules.Optimise(basket, rules, c, 0)
+		assert.NoError(t, err)
+		assert.Len(t, assignment.Steps, 1)
+		assert.Equal(t, "First", assignment.Steps[0].Name)
+		assert.Len(t, assignment.Steps[0].Claims, 2)
+	}
+}
+
+func TestOptimise_Budget(t *testing.T) {
+	c := catalog.NewCatalog()
+	rules := []pricingrules.PricingRule{
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+	}
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd", "ipd", "ipd", "ipd", "atv", "atv", "atv", "atv")
+	assert.NoError(t, err)
+
+	full, err := pricingrules.Optimise(basket, rules, c, 0)
+	assert.NoError(t, err)
+	assert.False(t, full.Exhausted)
+
+	limited, err := pricingrules.Optimise(basket, rules, c, 2)
+	assert.NoError(t, err)
+	assert.True(t, limited.Exhausted)
+	assert.Less(t, limited.Evaluations, full.Evaluations)
+
+	// A limited search still prices every item at least as well as list order
+	exclusive, err := (&pricingrules.RuleSet{Rules: rules}).Apply(basket, c)
+	assert.NoError(t, err)
+	assert.False(t, basket.Total(exclusive).LessThan(limited.Total))
+	assert.False(t, limited.Total.LessThan(full.Total))
+}
+
+func TestOptimise_LargeBasketStaysWithinBudget(t *testing.T) {
+	c := catalog.NewCatalog()
+	rules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "atv"},
+		&pricingrules.BundlePriceRule{SKUs: []string{"atv", "vga"}, Price: aud("129.99")},
+	}
+
+	basket, err := pricingrules.NewBasket(c, append(repeat("atv", 20000), repeat("vga", 20000)...)...)
+	assert.NoError(t, err)
+
+	// Each rule claims thousands of sets, so trying every share of them would
+	// never finish; every step passes over the whole basket, so the default
+	// budget stops the search after a few dozen of them
+	assignment, err := pricingrules.Optimise(basket, rules, c, 0)
+	assert.NoError(t, err)
+	assert.True(t, assignment.Exhausted)
+	// Finishing in list order once the budget is spent takes a few steps per rule
+	finish := len(rules) * (len(rules) + 1) * len(basket.Items)
+	assert.LessOrEqual(t, assignment.Evaluations, pricingrules.DefaultSearchBudget+finish)
+	assert.Equal(t, assignment.Total.String(), basket.Total(assignment.Claims()).String())
+}
+
+func TestOptimise_EmptyBasket(t *testing.T) {
+	assignment, err := pricingrules.Optimise(pricingrules.Basket{}, []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}, catalog.NewCatalog(), 0)
+	assert.NoError(t, err)
+	assert.Empty(t, assignment.Steps)
+	assert.True(t, assignment.Total.IsZero())
+}
+
+func TestAssignment_String(t *testing.T) {
+	c := catalog.NewCatalog()
+	rules := []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}
+
+	basket, err := pricingrules.NewBasket(c, "vga", "atv", "atv", "atv")
+	assert.NoError(t, err)
+
+	assignment, err := pricingrules.Optimise(basket, rules, c, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "3 for 2 on atv (rule 1) claimed items 1, 2, 3\nTotal 249.00 AUD; searched in full; items examined: 12\n", assignment.String())
+}
+
+func TestRuleSet_Optimal(t *testing.T) {
+	c := catalog.NewCatalog()
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.Optimal,
+		Rules: []pricingrules.PricingRule{
+			&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+		},
+	}
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd", "ipd", "ipd", "ipd", "atv")
+	assert.NoError(t, err)
+
+	claims, err := set.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "2609.45 AUD", basket.Total(claims).String())
+}
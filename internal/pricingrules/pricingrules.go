@@ -2,57 +2,819 @@ package pricingrules
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal"
 	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
 )
 
+// Item is a line of scanned items offered to a pricing rule: a number of whole
+// items of one SKU, or for products sold by measure, one measured amount such
+// as 1.25 kg. Rules may claim some of the whole items on a line and leave the
+// rest to other rules.
 type Item struct {
 	SKU string
+	// Parent is the SKU of the product this item is a variant of, if any
+	Parent string
+	// Category, Brand and Tags classify the product, for rules that target
+	// them rather than a SKU
+	Category string
+	Brand    string
+	Tags     []string
+	// Price is charged per item, or per unit of measure for a measured item
+	Price money.Money
+	// Quantity is the number of whole items, or the measured amount of a
+	// product sold by measure. Zero means a single whole item.
+	Quantity decimal.Decimal
+	// Unit is the product's unit of measure. Items in a measured unit hold
+	// one measured amount rather than a number of whole items.
+	Unit catalog.Unit
 }
 
+// Is reports whether the item is picked by target, a SKU or a selector such as
+// "category:accessories". A SKU matches the item's own SKU or the parent it is
+// a variant of, so a rule for a parent SKU covers every variant.
+func (i Item) Is(target string) bool {
+	selector, err := ParseSelector(target)
+	return err == nil && selector.Matches(i)
+}
+
+// itemOf returns the basket item for one unit of product at price
+func itemOf(product catalog.Product, price money.Money) Item {
+	return Item{
+		SKU:      product.SKU,
+		Parent:   product.Parent,
+		Category: product.Category,
+		Brand:    product.Brand,
+		Tags:     product.Tags,
+		Price:    price,
+	}
+}
+
+// Measured reports whether the item is a measured amount rather than whole items
+func (i Item) Measured() bool {
+	return i.Unit.Measured()
+}
+
+// Units returns how much of the product the item holds: its measured amount,
+// or its number of whole items
+func (i Item) Units() decimal.Decimal {
+	if i.Quantity.IsZero() {
+		return decimal.NewFromInt(1)
+	}
+	return i.Quantity
+}
+
+// count returns how many parts a rule can claim the item in: each of its
+// whole items, or its measured amount as one
+func (i Item) count() int {
+	if i.Measured() {
+		return 1
+	}
+	return int(i.Units().IntPart())
+}
+
+// Amount returns what the item costs at its basket price
+func (i Item) Amount() money.Money {
+	return i.Price.Mul(i.Units())
+}
+
+// amountOf returns what quantity of the item's whole items cost at its basket
+// price, or the whole item when quantity is zero or the item is measured
+func (i Item) amountOf(quantity int) money.Money {
+	if quantity == 0 || i.Measured() {
+		return i.Amount()
+	}
+	return i.Price.MulInt(int64(quantity))
+}
+
+// Basket is the set of items a pricing rule can inspect. A rule is free to claim
+// any combination of items in the basket, across as many SKUs as it needs.
+// Time is the moment the basket is being priced at.
+type Basket struct {
+	Items []Item
+	Time  time.Time
+}
+
+// NewBasket builds a basket for the given SKUs, pricing each item at its catalog
+// price. Every SKU stands for one whole item.
+func NewBasket(catalog *catalog.Catalog, skus ...string) (Basket, error) {
+	items := make([]Item, 0, len(skus))
+	for _, sku := range skus {
+		product, err := catalog.GetProduct(context.Background(), sku)
+		if err != nil {
+			return Basket{}, err
+		}
+		items = append(items, itemOf(product, money.New(product.Price, catalog.Currency())))
+	}
+	return Basket{Items: items}, nil
+}
+
+// indexesOf returns the positions of all items picked by target, a SKU or a
+// selector, in basket order
+func (b Basket) indexesOf(target string) []int {
+	var indexes []int
+	for i, item := range b.Items {
+		if item.Is(target) {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// wholeItems returns the given items sold whole as runs, for rules that group
+// items, such as bundles, which only make sense for whole items
+func (b Basket) wholeItems(indexes []int) []run {
+	var whole []run
+	for _, index := range indexes {
+		if item := b.Items[index]; !item.Measured() {
+			whole = append(whole, run{index: index, quantity: item.count()})
+		}
+	}
+	return whole
+}
+
+// run is a number of the whole items on one basket item
+type run struct {
+	index    int
+	quantity int
+}
+
+// takeRuns takes the first n whole items off runs
+func takeRuns(runs *[]run, n int) []run {
+	var taken []run
+	for n > 0 {
+		front := &(*runs)[0]
+		quantity := min(front.quantity, n)
+		taken = append(taken, run{index: front.index, quantity: quantity})
+		front.quantity -= quantity
+		n -= quantity
+		if front.quantity == 0 {
+			*runs = (*runs)[1:]
+		}
+	}
+	return taken
+}
+
+// splitRuns splits runs after their first n whole items
+func splitRuns(runs []run, n int) ([]run, []run) {
+	var head, tail []run
+	for _, r := range runs {
+		switch {
+		case n >= r.quantity:
+			head = append(head, r)
+		case n > 0:
+			head = append(head, run{index: r.index, quantity: n})
+			tail = append(tail, run{index: r.index, quantity: r.quantity - n})
+		default:
+			tail = append(tail, r)
+		}
+		n -= r.quantity
+	}
+	return head, tail
+}
+
+// quantityOf returns how many whole items runs hold together
+func quantityOf(runs []run) int {
+	total := 0
+	for _, r := range runs {
+		total += r.quantity
+	}
+	return total
+}
+
+// quantity returns how much of the product the given items hold together
+func (b Basket) quantity(indexes []int) decimal.Decimal {
+	total := decimal.Zero
+	for _, index := range indexes {
+		total = total.Add(b.Items[index].Units())
+	}
+	return total
+}
+
+// hasQuantity reports whether the given items hold at least min of the product
+func (b Basket) hasQuantity(indexes []int, min int) bool {
+	return !b.quantity(indexes).LessThan(decimal.NewFromInt(int64(min)))
+}
+
+// without returns what is left of the items once taken of each has been
+// claimed, together with the position each of them has in the full basket
+func (b Basket) without(taken []int) (Basket, []int) {
+	open := Basket{Time: b.Time}
+	var positions []int
+	for i, item := range b.Items {
+		left := item.count() - taken[i]
+		if left == 0 {
+			continue
+		}
+		if !item.Measured() {
+			item.Quantity = decimal.NewFromInt(int64(left))
+		}
+		open.Items = append(open.Items, item)
+		positions = append(positions, i)
+	}
+	return open, positions
+}
+
+// take records that item claims part of the basket, adding to what taken
+// holds of each basket item, and returns how many parts it claims. It
+// reports false if the claim is on an item that is not in the basket or on
+// more of it than is left.
+func (b Basket) take(taken []int, item ClaimedItem) (int, bool) {
+	if item.Index < 0 || item.Index >= len(b.Items) || item.Quantity < 0 {
+		return 0, false
+	}
+	left := b.Items[item.Index].count() - taken[item.Index]
+	n := item.Quantity
+	if n == 0 {
+		n = b.Items[item.Index].count()
+	}
+	if n > left {
+		return 0, false
+	}
+	taken[item.Index] += n
+	return n, true
+}
+
+// Prices returns what each basket item costs once the given claims are
+// applied. Whole items that are not claimed keep their basket price.
+func (b Basket) Prices(claims []Claim) []money.Money {
+	prices := make([]money.Money, len(b.Items))
+	taken := make([]int, len(b.Items))
+	for i, item := range b.Items {
+		prices[i] = money.Zero(item.Price.Currency)
+	}
+	for _, claim := range claims {
+		for _, item := range claim.Items {
+			b.take(taken, item)
+			prices[item.Index] = prices[item.Index].Add(item.Price)
+		}
+	}
+	for i, item := range b.Items {
+		if left := item.count() - taken[i]; left > 0 {
+			prices[i] = prices[i].Add(item.amountOf(left))
+		}
+	}
+	return prices
+}
+
+// Adjustments returns the adjustments the given claims made to each basket item
+func (b Basket) Adjustments(claims []Claim) [][]Adjustment {
+	adjustments := make([][]Adjustment, len(b.Items))
+	for _, claim := range claims {
+		for _, item := range claim.Items {
+			adjustments[item.Index] = append(adjustments[item.Index], item.Adjustments...)
+		}
+	}
+	return adjustments
+}
+
+// Total prices the basket with the given claims applied, without rounding
+func (b Basket) Total(claims []Claim) money.Money {
+	var total money.Money
+	for _, price := range b.Prices(claims) {
+		total = total.Add(price)
+	}
+	return total
+}
+
+// Adjustment is a change a rule made to the price of an item. Discounts have a
+// negative amount.
+type Adjustment struct {
+	Rule   string
+	Amount money.Money
+}
+
+// ClaimedItem is a basket item, or some of its whole items, taken by a rule
+// together with the price charged for them and the adjustments that explain
+// how that price was reached. For a measured item the price covers its whole
+// measured amount.
+type ClaimedItem struct {
+	Index int
+	// Quantity is how many of the basket item's whole items are taken. Zero
+	// takes all of the basket item, and is how a measured item is claimed.
+	Quantity    int
+	Price       money.Money
+	Adjustments []Adjustment
+}
+
+// reprice claims quantity of the whole items of the basket item at index, or
+// all of it if quantity is zero, for price, recording the difference from
+// their basket amount as an adjustment made by rule
+func reprice(basket Basket, index, quantity int, price money.Money, rule string) ClaimedItem {
+	item := ClaimedItem{Index: index, Quantity: quantity, Price: price}
+	if change := price.Sub(basket.Items[index].amountOf(quantity)); !change.IsZero() {
+		item.Adjustments = []Adjustment{{Rule: rule, Amount: change}}
+	}
+	return item
+}
+
+// Claim is a group of basket items that a rule prices together, such as one
+// "3 for 2" triple or one bundle
+type Claim struct {
+	Items []ClaimedItem
+	// Repeats is how many identical groups the claim prices at once, such as
+	// two triples out of a line of six Apple TVs. Zero means one.
+	Repeats int
+}
+
+// repeats returns how many groups the claim prices
+func (c Claim) repeats() int {
+	return max(c.Repeats, 1)
+}
+
+// first returns the claim cut down to its first n groups
+func (c Claim) first(n int) Claim {
+	share := decimal.NewFromInt(int64(n)).Div(decimal.NewFromInt(int64(c.repeats())))
+	cut := Claim{Items: make([]ClaimedItem, len(c.Items)), Repeats: n}
+	for i, item := range c.Items {
+		cut.Items[i] = ClaimedItem{Index: item.Index, Quantity: item.Quantity / c.repeats() * n, Price: item.Price.Mul(share)}
+		for _, adjustment := range item.Adjustments {
+			cut.Items[i].Adjustments = append(cut.Items[i].Adjustments, Adjustment{Rule: adjustment.Rule, Amount: adjustment.Amount.Mul(share)})
+		}
+	}
+	return cut
+}
+
+// Price returns the total charged for the claimed items
+func (c Claim) Price() money.Money {
+	var total money.Money
+	for _, item := range c.Items {
+		total = total.Add(item.Price)
+	}
+	return total
+}
+
+// PricingRule inspects the whole basket and returns the claims it makes on it.
+// Items that no rule claims are charged at their basket price. Every price
+// change a rule makes is reported as an Adjustment carrying the rule's name.
 type PricingRule interface {
-	Apply(items []Item, catalog *catalog.Catalog) (float64, error)
+	Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error)
 }
 
 // ThreeForTwoRule applies a "3 for 2" deal on a specific SKU
 type ThreeForTwoRule struct {
-	SKU string
+	Name string
+	SKU  string
+}
+
+func (r *ThreeForTwoRule) name() string {
+	return nameOr(r.Name, "3 for 2 on "+r.SKU)
 }
 
-func (r *ThreeForTwoRule) Apply(items []Item, catalog *catalog.Catalog) (float64, error) {
-	if len(items) == 0 {
-		return 0, nil
+func (r *ThreeForTwoRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	rule := &BuyXGetYRule{
+		Name:    r.name(),
+		SKUs:    []string{r.SKU},
+		Buy:     2,
+		Get:     1,
+		Percent: decimal.NewFromInt(100),
 	}
-	product, err := catalog.GetProduct(context.Background(), r.SKU)
-	if err != nil {
-		return 0, err
+	return rule.Apply(basket, catalog)
+}
+
+// DiscountTarget decides which items of a Buy-X-Get-Y group are discounted
+// when the group mixes items with different prices
+type DiscountTarget int
+
+const (
+	// DiscountCheapest discounts the cheapest items of each group
+	DiscountCheapest DiscountTarget = iota
+	// DiscountMostExpensive discounts the most expensive items of each group
+	DiscountMostExpensive
+)
+
+func (t DiscountTarget) String() string {
+	switch t {
+	case DiscountCheapest:
+		return "cheapest"
+	case DiscountMostExpensive:
+		return "most-expensive"
+	default:
+		return fmt.Sprintf("DiscountTarget(%d)", int(t))
 	}
-	count := len(items)
-	freeItems := count / 3
-	chargeableCount := count - freeItems
-	totalPrice := product.Price.Mul(decimal.NewFromInt(int64(chargeableCount))).InexactFloat64()
-	return totalPrice, nil
 }
 
-// BulkDiscountRule applies a bulk discount when a minimum quantity is purchased
+// ParseDiscountTarget reads a discount target from its String form
+func ParseDiscountTarget(s string) (DiscountTarget, error) {
+	for _, target := range []DiscountTarget{DiscountCheapest, DiscountMostExpensive} {
+		if s == target.String() {
+			return target, nil
+		}
+	}
+	return DiscountCheapest, fmt.Errorf("unknown discount target: %q", s)
+}
+
+// BuyXGetYRule groups the items of any of SKUs into sets of Buy + Get and
+// takes Percent off Get items in each set, so "buy 2 get 1 free" is Buy 2,
+// Get 1, Percent 100. Items are grouped from the most expensive down and
+// Target picks which items of a group are discounted. A non-zero MaxRepeats
+// limits how many groups are discounted per basket. Items that do not make a
+// complete group, and items sold by measure, are left unclaimed. Each group
+// is its own claim, except that whole groups within one basket item are
+// claimed together.
+type BuyXGetYRule struct {
+	Name       string
+	SKUs       []string
+	Buy        int
+	Get        int
+	Percent    decimal.Decimal
+	Target     DiscountTarget
+	MaxRepeats int
+}
+
+func (r *BuyXGetYRule) name() string {
+	offer := "free"
+	if !r.Percent.Equal(decimal.NewFromInt(100)) {
+		offer = "at " + r.Percent.String() + "% off"
+	}
+	return nameOr(r.Name, fmt.Sprintf("Buy %d get %d %s on %s", r.Buy, r.Get, offer, strings.Join(r.SKUs, ", ")))
+}
+
+func (r *BuyXGetYRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	var indexes []int
+	for i, item := range basket.Items {
+		if slices.ContainsFunc(r.SKUs, item.Is) {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 || r.Buy < 0 || r.Get <= 0 {
+		return nil, nil
+	}
+	for _, sku := range r.SKUs {
+		if err := checkTarget(catalog, sku); err != nil {
+			return nil, err
+		}
+	}
+
+	// Stable sorting keeps equally priced items in basket order
+	slices.SortStableFunc(indexes, func(a, b int) int {
+		return basket.Items[b].Price.Amount.Cmp(basket.Items[a].Price.Amount)
+	})
+	runs := basket.wholeItems(indexes)
+	fraction := r.Percent.Div(decimal.NewFromInt(100))
+	size := r.Buy + r.Get
+	var claims []Claim
+	for left, repeats := quantityOf(runs), 0; left >= size; {
+		if r.MaxRepeats > 0 && repeats == r.MaxRepeats {
+			break
+		}
+		groups := 1
+		if runs[0].quantity >= size {
+			groups = runs[0].quantity / size
+			if r.MaxRepeats > 0 {
+				groups = min(groups, r.MaxRepeats-repeats)
+			}
+		}
+		repeats += groups
+		left -= size * groups
+
+		// The group runs from the most expensive item down, and Target
+		// decides whether its head or its tail is discounted
+		boundary, discountHead := r.Buy, false
+		if r.Target == DiscountMostExpensive {
+			boundary, discountHead = r.Get, true
+		}
+		head, tail := splitRuns(takeRuns(&runs, size*groups), boundary*groups)
+		claim := Claim{Items: make([]ClaimedItem, 0, size)}
+		for i, part := range append(head, tail...) {
+			price := basket.Items[part.index].Price
+			if (i < len(head)) == discountHead {
+				claim.Items = append(claim.Items, reprice(basket, part.index, part.quantity, takeOff(price, price.Mul(fraction)).MulInt(int64(part.quantity)), r.name()))
+			} else {
+				claim.Items = append(claim.Items, ClaimedItem{Index: part.index, Quantity: part.quantity, Price: price.MulInt(int64(part.quantity))})
+			}
+		}
+		claim.Repeats = groups
+		claims = append(claims, claim)
+	}
+	return claims, nil
+}
+
+// BulkDiscountRule applies a bulk discount when a minimum quantity is purchased.
+// For products sold by measure, NewPrice is per unit of measure.
 type BulkDiscountRule struct {
+	Name        string
+	SKU         string
+	MinQuantity int
+	NewPrice    money.Money
+}
+
+func (r *BulkDiscountRule) name() string {
+	return nameOr(r.Name, "Bulk discount on "+r.SKU)
+}
+
+func (r *BulkDiscountRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	indexes := basket.indexesOf(r.SKU)
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+	if err := checkTarget(catalog, r.SKU); err != nil {
+		return nil, err
+	}
+	if !basket.hasQuantity(indexes, r.MinQuantity) {
+		return nil, nil
+	}
+	if err := checkCurrency(basket, r.NewPrice); err != nil {
+		return nil, err
+	}
+	claim := Claim{Items: make([]ClaimedItem, 0, len(indexes))}
+	for _, index := range indexes {
+		claim.Items = append(claim.Items, reprice(basket, index, 0, r.NewPrice.Mul(basket.Items[index].Units()), r.name()))
+	}
+	return []Claim{claim}, nil
+}
+
+// PercentOffRule takes Percent off the price of every SKU item once the basket
+// holds at least MinQuantity of the SKU, for example 15% off all Apple TVs.
+// A non-zero Cap limits the total discount the rule gives per basket.
+type PercentOffRule struct {
+	Name        string
+	SKU         string
+	Percent     decimal.Decimal
+	MinQuantity int
+	Cap         money.Money
+}
+
+func (r *PercentOffRule) name() string {
+	return nameOr(r.Name, r.Percent.String()+"% off "+r.SKU)
+}
+
+func (r *PercentOffRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	indexes := basket.indexesOf(r.SKU)
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+	if err := checkTarget(catalog, r.SKU); err != nil {
+		return nil, err
+	}
+	if !basket.hasQuantity(indexes, r.MinQuantity) {
+		return nil, nil
+	}
+	if err := checkCurrency(basket, r.Cap); err != nil {
+		return nil, err
+	}
+	fraction := r.Percent.Div(decimal.NewFromInt(100))
+	return discountEach(basket, indexes, r.Cap, r.name(), func(item Item) money.Money {
+		return item.Amount().Mul(fraction)
+	}), nil
+}
+
+// AmountOffRule takes a fixed Amount off the price of every SKU item, or off
+// every unit of measure, once the basket holds at least MinQuantity of the SKU,
+// for example $50 off each MacBook Pro. A non-zero Cap limits the total discount the rule gives per basket.
+type AmountOffRule struct {
+	Name        string
 	SKU         string
+	Amount      money.Money
 	MinQuantity int
-	NewPrice    float64
+	Cap         money.Money
+}
+
+func (r *AmountOffRule) name() string {
+	return nameOr(r.Name, r.Amount.String()+" off "+r.SKU)
+}
+
+func (r *AmountOffRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	indexes := basket.indexesOf(r.SKU)
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+	if err := checkTarget(catalog, r.SKU); err != nil {
+		return nil, err
+	}
+	if !basket.hasQuantity(indexes, r.MinQuantity) {
+		return nil, nil
+	}
+	for _, amount := range []money.Money{r.Amount, r.Cap} {
+		if err := checkCurrency(basket, amount); err != nil {
+			return nil, err
+		}
+	}
+	return discountEach(basket, indexes, r.Cap, r.name(), func(item Item) money.Money {
+		return r.Amount.Mul(item.Units())
+	}), nil
+}
+
+// discountEach takes discount(item) off each whole item and measured amount
+// of the given items, in basket order. A discount never takes an item below
+// zero or raises its price, and a non-zero limit caps the discount across all
+// items. Once the limit is used up the remaining items are left unclaimed for
+// other rules, even when they are on the same basket item.
+func discountEach(basket Basket, indexes []int, limit money.Money, rule string, discount func(Item) money.Money) []Claim {
+	capped := !limit.IsZero()
+	remaining := limit
+	var claim Claim
+	for _, index := range indexes {
+		if capped && remaining.IsZero() {
+			break
+		}
+		item := basket.Items[index]
+		one := item
+		if !item.Measured() {
+			one.Quantity = decimal.NewFromInt(1)
+		}
+		price := one.Amount()
+		off := price.Sub(takeOff(price, discount(one)))
+		count := item.count()
+		if capped && !off.IsZero() {
+			// The limit may run out part way through the basket item, leaving
+			// the item it runs out on partly discounted and the rest unclaimed
+			if whole, _ := remaining.Amount.QuoRem(off.Amount, 0); whole.LessThan(decimal.NewFromInt(int64(count))) {
+				n := int(whole.IntPart())
+				if n > 0 {
+					claim.Items = append(claim.Items, reprice(basket, index, n, price.Sub(off).MulInt(int64(n)), rule))
+				}
+				if left := remaining.Sub(off.MulInt(int64(n))); !left.IsZero() {
+					claim.Items = append(claim.Items, reprice(basket, index, 1, price.Sub(left), rule))
+				}
+				break
+			}
+			remaining = remaining.Sub(off.MulInt(int64(count)))
+		}
+		claim.Items = append(claim.Items, reprice(basket, index, 0, price.Sub(off).MulInt(int64(count)), rule))
+	}
+	if len(claim.Items) == 0 {
+		return nil
+	}
+	return []Claim{claim}
+}
+
+// BundlePriceRule sells one of each listed SKU together for a fixed price, for
+// example an iPad and an Apple TV for $599. Every complete set in the basket is
+// claimed, and the bundle price is split across its items in proportion to
+// their basket prices. A set whose items cost no more than the bundle price is
+// left alone, so a bundle never raises the price.
+type BundlePriceRule struct {
+	Name  string
+	SKUs  []string
+	Price money.Money
+}
+
+func (r *BundlePriceRule) name() string {
+	return nameOr(r.Name, "Bundle "+strings.Join(r.SKUs, " + "))
+}
+
+func (r *BundlePriceRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	if len(r.SKUs) == 0 {
+		return nil, nil
+	}
+	for _, sku := range r.SKUs {
+		if err := checkTarget(catalog, sku); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkCurrency(basket, r.Price); err != nil {
+		return nil, err
+	}
+	var claims []Claim
+	for _, set := range takeSets(basket, r.SKUs) {
+		if !r.Price.LessThan(setTotal(basket, set.indexes)) {
+			continue
+		}
+		claims = append(claims, Claim{Items: allocate(basket, set, r.Price, r.name()), Repeats: set.times})
+	}
+	return claims, nil
+}
+
+// FreeWithPurchaseRule gives away one FreeSKU item for every SKU item bought,
+// for example a free VGA adapter with every MacBook Pro
+type FreeWithPurchaseRule struct {
+	Name    string
+	SKU     string
+	FreeSKU string
+}
+
+func (r *FreeWithPurchaseRule) name() string {
+	return nameOr(r.Name, "Free "+r.FreeSKU+" with "+r.SKU)
+}
+
+func (r *FreeWithPurchaseRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	for _, sku := range []string{r.SKU, r.FreeSKU} {
+		if err := checkTarget(catalog, sku); err != nil {
+			return nil, err
+		}
+	}
+	var claims []Claim
+	for _, set := range takeSets(basket, []string{r.SKU, r.FreeSKU}) {
+		bought, free := set.indexes[0], set.indexes[1]
+		claims = append(claims, Claim{Items: []ClaimedItem{
+			{Index: bought, Quantity: set.times, Price: basket.Items[bought].Price.MulInt(int64(set.times))},
+			reprice(basket, free, set.times, money.Zero(basket.Items[free].Price.Currency), r.name()),
+		}, Repeats: set.times})
+	}
+	return claims, nil
+}
+
+// takeOff returns price less off, never going below zero or above price
+func takeOff(price, off money.Money) money.Money {
+	if off.IsNegative() {
+		return price
+	}
+	if price.LessThan(off) {
+		return money.Zero(price.Currency)
+	}
+	return price.Sub(off)
+}
+
+// set is one whole item of each SKU of a bundle, taken times over from the
+// same basket items
+type set struct {
+	// indexes holds the basket item of each SKU, in the same order as the SKUs
+	indexes []int
+	times   int
 }
 
-func (r *BulkDiscountRule) Apply(items []Item, catalog *catalog.Catalog) (float64, error) {
-	if len(items) == 0 {
-		return 0, nil
+// takeSets groups whole basket items into as many complete sets of the given
+// SKUs as possible. Sets that can all be taken from the same basket items are
+// returned as one.
+func takeSets(basket Basket, skus []string) []set {
+	pools := make(map[string][]run)
+	left := make(map[string]int)
+	need := make(map[string]int)
+	for _, sku := range skus {
+		if _, ok := pools[sku]; !ok {
+			pools[sku] = basket.wholeItems(basket.indexesOf(sku))
+			left[sku] = quantityOf(pools[sku])
+		}
+		need[sku]++
 	}
-	product, err := catalog.GetProduct(context.Background(), r.SKU)
-	if err != nil {
-		return 0, err
+	var sets []set
+	for {
+		times := -1
+		for sku, n := range need {
+			if left[sku] < n {
+				return sets
+			}
+			if fit := pools[sku][0].quantity / n; times < 0 || fit < times {
+				times = fit
+			}
+		}
+		// A set that spans basket items is taken on its own
+		times = max(times, 1)
+		next := set{indexes: make([]int, 0, len(skus)), times: times}
+		for _, sku := range skus {
+			pool := pools[sku]
+			next.indexes = append(next.indexes, takeRuns(&pool, times)[0].index)
+			pools[sku] = pool
+			left[sku] -= times
+		}
+		sets = append(sets, next)
 	}
-	count := len(items)
-	if count >= r.MinQuantity {
-		return float64(count) * r.NewPrice, nil
+}
+
+// allocate spreads price across the items of set in proportion to their
+// basket prices, rounding each share half-up, and charges it for every time
+// the set is taken. The last item absorbs any remainder so the claim adds up
+// exactly.
+func allocate(basket Basket, set set, price money.Money, rule string) []ClaimedItem {
+	listTotal := setTotal(basket, set.indexes)
+	items := make([]ClaimedItem, len(set.indexes))
+	remaining := price
+	for i, index := range set.indexes {
+		share := money.New(price.Amount.Div(decimal.NewFromInt(int64(len(set.indexes)))), price.Currency)
+		if !listTotal.IsZero() {
+			share = price.Mul(basket.Items[index].Price.Amount.Div(listTotal.Amount))
+		}
+		share = share.Round(money.HalfUp)
+		if i == len(set.indexes)-1 {
+			share = remaining
+		}
+		items[i] = reprice(basket, index, set.times, share.MulInt(int64(set.times)), rule)
+		remaining = remaining.Sub(share)
+	}
+	return items
+}
+
+// setTotal returns the basket price of the items at indexes
+func setTotal(basket Basket, indexes []int) money.Money {
+	var total money.Money
+	for _, index := range indexes {
+		total = total.Add(basket.Items[index].Price)
+	}
+	return total
+}
+
+// nameOr returns the configured rule name, falling back to a generated one
+func nameOr(name, fallback string) string {
+	if name != "" {
+		return name
+	}
+	return fallback
+}
+
+// checkCurrency makes sure an amount configured on a rule can be combined with
+// the prices in the basket
+func checkCurrency(basket Basket, price money.Money) error {
+	for _, item := range basket.Items {
+		if !item.Price.SameCurrency(price) {
+			return internal.NewCurrencyMismatchError(string(item.Price.Currency), string(price.Currency))
+		}
 	}
-	return product.Price.Mul(decimal.NewFromInt(int64(count))).InexactFloat64(), nil
+	return nil
 }
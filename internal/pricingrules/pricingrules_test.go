@@ -1,55 +1,74 @@
 package pricingrules_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/exp/rand"
 
 	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
 	"github.com/spa5k/zeller_go/internal/pricingrules"
 )
 
+func repeat(sku string, n int) []string {
+	skus := make([]string, n)
+	for i := range skus {
+		skus[i] = sku
+	}
+	return skus
+}
+
+func aud(amount string) money.Money {
+	return money.MustParse(amount, money.AUD)
+}
+
+func claimedTotal(claims []pricingrules.Claim) money.Money {
+	total := money.Zero(money.AUD)
+	for _, claim := range claims {
+		total = total.Add(claim.Price())
+	}
+	return total
+}
+
 func TestThreeForTwoRule_Apply_Success(t *testing.T) {
 	rule := &pricingrules.ThreeForTwoRule{SKU: "atv"}
 	c := catalog.NewCatalog()
 
-	items := []pricingrules.Item{
-		{SKU: "atv"},
-		{SKU: "atv"},
-		{SKU: "atv"},
-	}
+	basket, err := pricingrules.NewBasket(c, "atv", "atv", "atv")
+	assert.NoError(t, err)
 
-	total, err := rule.Apply(items, c)
+	claims, err := rule.Apply(basket, c)
 	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
 
-	expectedTotal := 2 * 109.50
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("109.50").MulInt(2)
+	assert.Equal(t, expectedTotal.String(), claims[0].Price().String())
 }
 
 func TestThreeForTwoRule_Apply_NoItems(t *testing.T) {
 	rule := &pricingrules.ThreeForTwoRule{SKU: "atv"}
 	c := catalog.NewCatalog()
 
-	items := []pricingrules.Item{}
-
-	total, err := rule.Apply(items, c)
+	claims, err := rule.Apply(pricingrules.Basket{}, c)
 	assert.NoError(t, err)
-	assert.Equal(t, 0.0, total)
+	assert.Empty(t, claims)
 }
 
 func TestThreeForTwoRule_ProductNotFound(t *testing.T) {
 	rule := &pricingrules.ThreeForTwoRule{SKU: "unknown"}
 	c := catalog.NewCatalog()
 
-	items := []pricingrules.Item{
-		{SKU: "unknown"},
-		{SKU: "unknown"},
-		{SKU: "unknown"},
-	}
+	basket := pricingrules.Basket{Items: []pricingrules.Item{
+		{SKU: "unknown", Price: aud("10")},
+		{SKU: "unknown", Price: aud("10")},
+		{SKU: "unknown", Price: aud("10")},
+	}}
 
-	_, err := rule.Apply(items, c)
+	_, err := rule.Apply(basket, c)
 	assert.Error(t, err)
 }
 
@@ -57,63 +76,54 @@ func TestBulkDiscountRule_Apply_DiscountApplied(t *testing.T) {
 	rule := &pricingrules.BulkDiscountRule{
 		SKU:         "ipd",
 		MinQuantity: 5,
-		NewPrice:    499.99,
+		NewPrice:    aud("499.99"),
 	}
 	c := catalog.NewCatalog()
 
-	items := []pricingrules.Item{
-		{SKU: "ipd"},
-		{SKU: "ipd"},
-		{SKU: "ipd"},
-		{SKU: "ipd"},
-		{SKU: "ipd"},
-	}
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd", "ipd", "ipd", "ipd")
+	assert.NoError(t, err)
 
-	total, err := rule.Apply(items, c)
+	claims, err := rule.Apply(basket, c)
 	assert.NoError(t, err)
 
-	expectedTotal := 5 * 499.99
-	assert.Equal(t, expectedTotal, total)
+	expectedTotal := aud("499.99").MulInt(5)
+	assert.Equal(t, expectedTotal.String(), claimedTotal(claims).String())
 }
 
 func TestBulkDiscountRule_Apply_NoDiscount(t *testing.T) {
 	rule := &pricingrules.BulkDiscountRule{
 		SKU:         "ipd",
 		MinQuantity: 5,
-		NewPrice:    499.99,
+		NewPrice:    aud("499.99"),
 	}
 	c := catalog.NewCatalog()
 
-	items := []pricingrules.Item{
-		{SKU: "ipd"},
-		{SKU: "ipd"},
-		{SKU: "ipd"},
-	}
-
-	total, err := rule.Apply(items, c)
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd", "ipd")
 	assert.NoError(t, err)
 
-	expectedTotal := 3 * 549.99
-	assert.Equal(t, expectedTotal, total)
+	// Below the threshold the rule leaves the items for list pricing
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
 }
 
 func TestBulkDiscountRule_ProductNotFound(t *testing.T) {
 	rule := &pricingrules.BulkDiscountRule{
 		SKU:         "unknown",
 		MinQuantity: 5,
-		NewPrice:    499.99,
+		NewPrice:    aud("499.99"),
 	}
 	c := catalog.NewCatalog()
 
-	items := []pricingrules.Item{
-		{SKU: "unknown"},
-		{SKU: "unknown"},
-		{SKU: "unknown"},
-		{SKU: "unknown"},
-		{SKU: "unknown"},
-	}
+	basket := pricingrules.Basket{Items: []pricingrules.Item{
+		{SKU: "unknown", Price: aud("10")},
+		{SKU: "unknown", Price: aud("10")},
+		{SKU: "unknown", Price: aud("10")},
+		{SKU: "unknown", Price: aud("10")},
+		{SKU: "unknown", Price: aud("10")},
+	}}
 
-	_, err := rule.Apply(items, c)
+	_, err := rule.Apply(basket, c)
 	assert.Error(t, err)
 }
 
@@ -121,15 +131,13 @@ func TestBulkDiscountRule_Apply_NoItems(t *testing.T) {
 	rule := &pricingrules.BulkDiscountRule{
 		SKU:         "ipd",
 		MinQuantity: 5,
-		NewPrice:    499.99,
+		NewPrice:    aud("499.99"),
 	}
 	c := catalog.NewCatalog()
 
-	items := []pricingrules.Item{}
-
-	total, err := rule.Apply(items, c)
+	claims, err := rule.Apply(pricingrules.Basket{}, c)
 	assert.NoError(t, err)
-	assert.Equal(t, 0.0, total)
+	assert.Empty(t, claims)
 }
 
 func TestThreeForTwoRule_Apply_VaryingQuantities(t *testing.T) {
@@ -139,17 +147,112 @@ func TestThreeForTwoRule_Apply_VaryingQuantities(t *testing.T) {
 	rand.Seed(uint64(time.Now().UnixNano()))
 	for i := 0; i < 10; i++ {
 		quantity := rand.Intn(20) // Random quantity between 0 and 19
-		items := make([]pricingrules.Item, quantity)
-		for j := 0; j < quantity; j++ {
-			items[j] = pricingrules.Item{SKU: "atv"}
+		basket, err := pricingrules.NewBasket(c, repeat("atv", quantity)...)
+		assert.NoError(t, err)
+
+		claims, err := rule.Apply(basket, c)
+		assert.NoError(t, err)
+		assert.Len(t, claims, quantity/3, "Failed for quantity %d", quantity)
+
+		// Every full triple is claimed at the price of two
+		expectedTotal := aud("109.50").MulInt(int64(quantity / 3 * 2))
+		assert.Equal(t, expectedTotal.String(), claimedTotal(claims).String(), "Failed for quantity %d", quantity)
+	}
+}
+
+func TestBuyXGetYRule_Apply_BuyTwoGetOneFree(t *testing.T) {
+	rule := &pricingrules.BuyXGetYRule{SKUs: []string{"atv"}, Buy: 2, Get: 1, Percent: decimal.NewFromInt(100)}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, repeat("atv", 7)...)
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 2)
+	assert.Equal(t, "438.00 AUD", claimedTotal(claims).String())
+	assert.Equal(t, "Buy 2 get 1 free on atv", claims[0].Items[2].Adjustments[0].Rule)
+}
+
+func TestBuyXGetYRule_Apply_PercentOff(t *testing.T) {
+	rule := &pricingrules.BuyXGetYRule{SKUs: []string{"ipd"}, Buy: 1, Get: 1, Percent: decimal.NewFromInt(50)}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Equal(t, "824.985 AUD", claimedTotal(claims).String())
+	assert.Equal(t, "Buy 1 get 1 at 50% off on ipd", claims[0].Items[1].Adjustments[0].Rule)
+}
+
+func TestBuyXGetYRule_Apply_MaxRepeats(t *testing.T) {
+	rule := &pricingrules.BuyXGetYRule{SKUs: []string{"vga"}, Buy: 1, Get: 1, Percent: decimal.NewFromInt(100), MaxRepeats: 2}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, repeat("vga", 8)...)
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 2)
+	assert.Equal(t, "180.00 AUD", basket.Total(claims).String())
+}
+
+func TestBuyXGetYRule_Apply_MixedSKUs(t *testing.T) {
+	c := catalog.NewCatalog()
+	basket, err := pricingrules.NewBasket(c, "vga", "atv", "ipd", "atv")
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		target        pricingrules.DiscountTarget
+		expectedFree  []string
+		expectedTotal string
+	}{
+		// Grouped from the most expensive down: [ipd atv atv] and vga is left over
+		{pricingrules.DiscountCheapest, []string{"atv"}, "689.49 AUD"},
+		{pricingrules.DiscountMostExpensive, []string{"ipd"}, "249.00 AUD"},
+	}
+
+	for _, tc := range testCases {
+		rule := &pricingrules.BuyXGetYRule{
+			SKUs:    []string{"ipd", "atv", "vga"},
+			Buy:     2,
+			Get:     1,
+			Percent: decimal.NewFromInt(100),
+			Target:  tc.target,
 		}
+		claims, err := rule.Apply(basket, c)
+		assert.NoError(t, err, tc.target)
+		assert.Len(t, claims, 1, tc.target)
+
+		var free []string
+		for _, item := range claims[0].Items {
+			if item.Price.IsZero() {
+				free = append(free, basket.Items[item.Index].SKU)
+			}
+		}
+		assert.Equal(t, tc.expectedFree, free, tc.target)
+		assert.Equal(t, tc.expectedTotal, basket.Total(claims).String(), tc.target)
+	}
+}
+
+func TestThreeForTwoRule_IsBuyTwoGetOneFree(t *testing.T) {
+	c := catalog.NewCatalog()
+	preset := &pricingrules.ThreeForTwoRule{SKU: "atv"}
+	generic := &pricingrules.BuyXGetYRule{Name: "3 for 2 on atv", SKUs: []string{"atv"}, Buy: 2, Get: 1, Percent: decimal.NewFromInt(100)}
 
-		total, err := rule.Apply(items, c)
+	for quantity := 0; quantity <= 10; quantity++ {
+		basket, err := pricingrules.NewBasket(c, repeat("atv", quantity)...)
 		assert.NoError(t, err)
 
-		expectedChargeable := quantity - (quantity / 3)
-		expectedTotal := float64(expectedChargeable) * 109.50
-		assert.Equal(t, expectedTotal, total, "Failed for quantity %d", quantity)
+		expected, err := generic.Apply(basket, c)
+		assert.NoError(t, err)
+		actual, err := preset.Apply(basket, c)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual, "Failed for quantity %d", quantity)
 	}
 }
 
@@ -157,27 +260,23 @@ func TestBulkDiscountRule_Apply_AtThreshold(t *testing.T) {
 	rule := &pricingrules.BulkDiscountRule{
 		SKU:         "ipd",
 		MinQuantity: 5,
-		NewPrice:    499.99,
+		NewPrice:    aud("499.99"),
 	}
 	c := catalog.NewCatalog()
 
 	// Test quantities around the threshold
 	for quantity := 4; quantity <= 6; quantity++ {
-		items := make([]pricingrules.Item, quantity)
-		for i := 0; i < quantity; i++ {
-			items[i] = pricingrules.Item{SKU: "ipd"}
-		}
+		basket, err := pricingrules.NewBasket(c, repeat("ipd", quantity)...)
+		assert.NoError(t, err)
 
-		total, err := rule.Apply(items, c)
+		claims, err := rule.Apply(basket, c)
 		assert.NoError(t, err)
 
-		var expectedTotal float64
+		expectedTotal := money.Zero(money.AUD)
 		if quantity >= rule.MinQuantity {
-			expectedTotal = float64(quantity) * rule.NewPrice
-		} else {
-			expectedTotal = float64(quantity) * 549.99
+			expectedTotal = rule.NewPrice.MulInt(int64(quantity))
 		}
-		assert.Equal(t, expectedTotal, total, "Failed for quantity %d", quantity)
+		assert.Equal(t, expectedTotal.String(), claimedTotal(claims).String(), "Failed for quantity %d", quantity)
 	}
 }
 
@@ -185,21 +284,499 @@ func TestBulkDiscountRule_Apply_LargeQuantities(t *testing.T) {
 	rule := &pricingrules.BulkDiscountRule{
 		SKU:         "ipd",
 		MinQuantity: 5,
-		NewPrice:    499.99,
+		NewPrice:    aud("499.99"),
 	}
 	c := catalog.NewCatalog()
 
 	quantities := []int{10, 50, 100, 1000}
 	for _, quantity := range quantities {
-		items := make([]pricingrules.Item, quantity)
-		for i := 0; i < quantity; i++ {
-			items[i] = pricingrules.Item{SKU: "ipd"}
+		basket, err := pricingrules.NewBasket(c, repeat("ipd", quantity)...)
+		assert.NoError(t, err)
+
+		claims, err := rule.Apply(basket, c)
+		assert.NoError(t, err)
+		assert.Len(t, claims, 1)
+		assert.Len(t, claims[0].Items, quantity)
+		for _, item := range claims[0].Items {
+			assert.True(t, rule.NewPrice.Equal(item.Price), "Failed for quantity %d", quantity)
 		}
+	}
+}
+
+func TestBundlePriceRule_Apply_ClaimsCompleteSets(t *testing.T) {
+	rule := &pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "atv", "ipd", "vga", "atv", "ipd")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 2)
+
+	// Each bundle takes the first free iPad and Apple TV in basket order
+	assert.Equal(t, 0, claims[0].Items[0].Index)
+	assert.Equal(t, 1, claims[0].Items[1].Index)
+	assert.Equal(t, 2, claims[1].Items[0].Index)
+	assert.Equal(t, 4, claims[1].Items[1].Index)
+	for _, claim := range claims {
+		assert.Equal(t, "599.00 AUD", claim.Price().String())
+	}
+}
+
+func TestBundlePriceRule_Apply_IncompleteSet(t *testing.T) {
+	rule := &pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")}
+	c := catalog.NewCatalog()
 
-		total, err := rule.Apply(items, c)
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd", "vga")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+}
+
+func TestBundlePriceRule_Apply_SplitsPriceByListPrice(t *testing.T) {
+	rule := &pricingrules.BundlePriceRule{SKUs: []string{"mbp", "vga"}, Price: aud("1000.00")}
+	basket := pricingrules.Basket{Items: []pricingrules.Item{
+		{SKU: "mbp", Price: aud("1500.00")},
+		{SKU: "vga", Price: aud("500.00")},
+	}}
+
+	claims, err := rule.Apply(basket, catalog.NewCatalog())
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Equal(t, "750.00 AUD", claims[0].Items[0].Price.String())
+	assert.Equal(t, "250.00 AUD", claims[0].Items[1].Price.String())
+}
+
+func TestBundlePriceRule_Apply_NeverRaisesPrice(t *testing.T) {
+	c := catalog.NewCatalog()
+	basket, err := pricingrules.NewBasket(c, "ipd", "atv")
+	assert.NoError(t, err)
+
+	// ipd + atv list at 659.49
+	for _, price := range []string{"700.00", "659.49"} {
+		rule := &pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud(price)}
+		claims, err := rule.Apply(basket, c)
 		assert.NoError(t, err)
+		assert.Empty(t, claims, price)
+	}
+}
+
+func TestBundlePriceRule_ProductNotFound(t *testing.T) {
+	rule := &pricingrules.BundlePriceRule{SKUs: []string{"ipd", "unknown"}, Price: aud("599.00")}
+	c := catalog.NewCatalog()
 
-		expectedTotal := float64(quantity) * rule.NewPrice
-		assert.Equal(t, expectedTotal, total, "Failed for quantity %d", quantity)
+	basket, err := pricingrules.NewBasket(c, "ipd")
+	assert.NoError(t, err)
+
+	_, err = rule.Apply(basket, c)
+	assert.EqualError(t, err, "product not found: unknown")
+}
+
+func TestFreeWithPurchaseRule_Apply(t *testing.T) {
+	rule := &pricingrules.FreeWithPurchaseRule{SKU: "mbp", FreeSKU: "vga"}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "vga", "mbp", "vga", "vga", "mbp")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 2)
+
+	// One adapter per MacBook is free, the third adapter is left unclaimed
+	for _, claim := range claims {
+		assert.Equal(t, "mbp", basket.Items[claim.Items[0].Index].SKU)
+		assert.Equal(t, "vga", basket.Items[claim.Items[1].Index].SKU)
+		assert.True(t, claim.Items[1].Price.IsZero())
+		assert.Equal(t, "1399.99 AUD", claim.Price().String())
 	}
 }
+
+func TestFreeWithPurchaseRule_Apply_NoTrigger(t *testing.T) {
+	rule := &pricingrules.FreeWithPurchaseRule{SKU: "mbp", FreeSKU: "vga"}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "vga", "vga")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+}
+
+func TestPercentOffRule_Apply(t *testing.T) {
+	rule := &pricingrules.PercentOffRule{SKU: "atv", Percent: decimal.NewFromInt(15)}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "atv", "vga", "atv")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Equal(t, "186.15 AUD", claimedTotal(claims).String())
+	assert.Equal(t, "15% off atv", claims[0].Items[0].Adjustments[0].Rule)
+	assert.Equal(t, "-16.425 AUD", claims[0].Items[0].Adjustments[0].Amount.String())
+}
+
+func TestPercentOffRule_Apply_MinQuantityAndCap(t *testing.T) {
+	rule := &pricingrules.PercentOffRule{SKU: "ipd", Percent: decimal.NewFromInt(10), MinQuantity: 2, Cap: aud("100.00")}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "ipd")
+	assert.NoError(t, err)
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+
+	// 10% of each iPad is 54.999 until the 100.00 cap runs out on the second
+	// one, and the third iPad is left for other rules
+	basket, err = pricingrules.NewBasket(c, repeat("ipd", 3)...)
+	assert.NoError(t, err)
+	claims, err = rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Len(t, claims[0].Items, 2)
+	assert.Equal(t, "494.991 AUD", claims[0].Items[0].Price.String())
+	assert.Equal(t, "504.989 AUD", claims[0].Items[1].Price.String())
+	assert.Equal(t, "-100.00 AUD", basket.Total(claims).Sub(basket.Total(nil)).String())
+}
+
+func TestAmountOffRule_Apply(t *testing.T) {
+	rule := &pricingrules.AmountOffRule{SKU: "ipd", Amount: aud("100.00"), MinQuantity: 2}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Equal(t, "899.98 AUD", claimedTotal(claims).String())
+	assert.Equal(t, "100.00 AUD off ipd", claims[0].Items[0].Adjustments[0].Rule)
+}
+
+func TestAmountOffRule_Apply_FloorsAtZero(t *testing.T) {
+	rule := &pricingrules.AmountOffRule{SKU: "vga", Amount: aud("50.00")}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "vga", "vga")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	for _, item := range claims[0].Items {
+		assert.True(t, item.Price.IsZero())
+		assert.Equal(t, "-30.00 AUD", item.Adjustments[0].Amount.String())
+	}
+}
+
+func TestAmountOffRule_Apply_Cap(t *testing.T) {
+	rule := &pricingrules.AmountOffRule{SKU: "mbp", Amount: aud("50.00"), Cap: aud("120.00")}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, repeat("mbp", 4)...)
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims[0].Items, 3)
+	assert.Equal(t, "4079.97 AUD", claimedTotal(claims).String())
+	assert.Equal(t, "5479.96 AUD", basket.Total(claims).String())
+}
+
+func TestAmountOffRule_CurrencyMismatch(t *testing.T) {
+	rule := &pricingrules.AmountOffRule{SKU: "mbp", Amount: money.MustParse("50.00", money.USD)}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "mbp")
+	assert.NoError(t, err)
+
+	_, err = rule.Apply(basket, c)
+	assert.EqualError(t, err, "currency mismatch: expected AUD, got USD")
+}
+
+func TestBundlePriceRule_Apply_RoundsSharesToCents(t *testing.T) {
+	rule := &pricingrules.BundlePriceRule{SKUs: []string{"atv", "atv", "atv"}, Price: aud("100.00")}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "atv", "atv", "atv")
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+
+	// Two shares round to $33.33 and the last one takes the remaining cent
+	assert.Equal(t, "33.33 AUD", claims[0].Items[0].Price.String())
+	assert.Equal(t, "33.33 AUD", claims[0].Items[1].Price.String())
+	assert.Equal(t, "33.34 AUD", claims[0].Items[2].Price.String())
+	assert.Equal(t, "100.00 AUD", claims[0].Price().String())
+}
+
+func TestBulkDiscountRule_CurrencyMismatch(t *testing.T) {
+	rule := &pricingrules.BulkDiscountRule{
+		SKU:         "ipd",
+		MinQuantity: 1,
+		NewPrice:    money.MustParse("499.99", money.USD),
+	}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "ipd")
+	assert.NoError(t, err)
+
+	_, err = rule.Apply(basket, c)
+	assert.EqualError(t, err, "currency mismatch: expected AUD, got USD")
+}
+
+func TestRules_ReportAdjustments(t *testing.T) {
+	c := catalog.NewCatalog()
+
+	testCases := []struct {
+		description string
+		rule        pricingrules.PricingRule
+		skus        []string
+		expected    map[string]string
+	}{
+		{
+			"3 for 2 discounts the third item",
+			&pricingrules.ThreeForTwoRule{SKU: "atv"},
+			[]string{"atv", "atv", "atv"},
+			map[string]string{"3 for 2 on atv": "-109.50 AUD"},
+		},
+		{
+			"Bulk discount reprices every item",
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 2, NewPrice: aud("499.99")},
+			[]string{"ipd", "ipd"},
+			map[string]string{"Bulk discount on ipd": "-100.00 AUD"},
+		},
+		{
+			"Free item is discounted in full",
+			&pricingrules.FreeWithPurchaseRule{Name: "Free adapter", SKU: "mbp", FreeSKU: "vga"},
+			[]string{"mbp", "vga"},
+			map[string]string{"Free adapter": "-30.00 AUD"},
+		},
+	}
+
+	for _, tc := range testCases {
+		basket, err := pricingrules.NewBasket(c, tc.skus...)
+		assert.NoError(t, err)
+
+		claims, err := tc.rule.Apply(basket, c)
+		assert.NoError(t, err)
+
+		totals := make(map[string]money.Money)
+		for _, adjustments := range basket.Adjustments(claims) {
+			for _, adjustment := range adjustments {
+				totals[adjustment.Rule] = totals[adjustment.Rule].Add(adjustment.Amount)
+			}
+		}
+		actual := make(map[string]string)
+		for rule, amount := range totals {
+			actual[rule] = amount.String()
+		}
+		assert.Equal(t, tc.expected, actual, tc.description)
+	}
+}
+
+func TestItem_Measured(t *testing.T) {
+	whole := pricingrules.Item{SKU: "ipd", Price: aud("549.99")}
+	assert.False(t, whole.Measured())
+	assert.Equal(t, "1", whole.Units().String())
+	assert.Equal(t, "549.99 AUD", whole.Amount().String())
+
+	weighed := pricingrules.Item{SKU: "apl", Price: aud("4.40"), Quantity: decimal.RequireFromString("1.25"), Unit: catalog.Kilogram}
+	assert.True(t, weighed.Measured())
+	assert.Equal(t, "1.25", weighed.Units().String())
+	assert.Equal(t, "5.50 AUD", weighed.Amount().String())
+}
+
+// line returns a basket item holding quantity whole items of sku at its list price
+func line(t *testing.T, c *catalog.Catalog, sku string, quantity int) pricingrules.Item {
+	basket, err := pricingrules.NewBasket(c, sku)
+	assert.NoError(t, err)
+	item := basket.Items[0]
+	item.Quantity = decimal.NewFromInt(int64(quantity))
+	return item
+}
+
+func TestRules_PriceLinesLikeSeparateItems(t *testing.T) {
+	c := catalog.NewCatalog()
+	rules := []pricingrules.PricingRule{
+		&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+		&pricingrules.BuyXGetYRule{SKUs: []string{"ipd", "atv"}, Buy: 2, Get: 1, Percent: decimal.NewFromInt(50), Target: pricingrules.DiscountMostExpensive, MaxRepeats: 2},
+		&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+		&pricingrules.PercentOffRule{SKU: "ipd", Percent: decimal.NewFromInt(10), Cap: aud("150.00")},
+		&pricingrules.AmountOffRule{SKU: "ipd", Amount: aud("50.00"), Cap: aud("120.00")},
+		&pricingrules.TieredPriceRule{SKU: "ipd", Tiers: ipadTiers(), Mode: pricingrules.AllUnits},
+		&pricingrules.TieredPriceRule{SKU: "ipd", Tiers: ipadTiers(), Mode: pricingrules.Graduated},
+		&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "ipd", "atv"}, Price: aud("999.00")},
+		&pricingrules.FreeWithPurchaseRule{SKU: "ipd", FreeSKU: "atv"},
+		&pricingrules.RuleSet{Rules: []pricingrules.PricingRule{
+			&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+			&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+		}},
+		&pricingrules.RuleSet{Policy: pricingrules.Sequential, Rules: []pricingrules.PricingRule{
+			&pricingrules.PercentOffRule{SKU: "ipd", Percent: decimal.NewFromInt(10), Cap: aud("150.00")},
+			&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+		}},
+		&pricingrules.RuleSet{Policy: pricingrules.Optimal, Rules: []pricingrules.PricingRule{
+			&pricingrules.BundlePriceRule{SKUs: []string{"ipd", "atv"}, Price: aud("599.00")},
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 4, NewPrice: aud("499.99")},
+		}},
+	}
+
+	// A line of whole items is priced as if each of them had been scanned
+	for i, rule := range rules {
+		for quantity := 1; quantity <= 12; quantity++ {
+			separate, err := pricingrules.NewBasket(c, append(repeat("ipd", quantity), "atv", "atv")...)
+			assert.NoError(t, err)
+			lines := pricingrules.Basket{Items: []pricingrules.Item{line(t, c, "ipd", quantity), line(t, c, "atv", 2)}}
+
+			expected, err := rule.Apply(separate, c)
+			assert.NoError(t, err)
+			actual, err := rule.Apply(lines, c)
+			assert.NoError(t, err)
+			assert.Equal(t, separate.Total(expected).String(), lines.Total(actual).String(), "rule %d x%d", i+1, quantity)
+			assert.Equal(t, adjustmentTotal(separate, expected).String(), adjustmentTotal(lines, actual).String(), "rule %d x%d", i+1, quantity)
+		}
+	}
+}
+
+func adjustmentTotal(basket pricingrules.Basket, claims []pricingrules.Claim) money.Money {
+	total := money.Zero(money.AUD)
+	for _, adjustments := range basket.Adjustments(claims) {
+		for _, adjustment := range adjustments {
+			total = total.Add(adjustment.Amount)
+		}
+	}
+	return total
+}
+
+func TestBuyXGetYRule_Apply_SplitsLine(t *testing.T) {
+	c := catalog.NewCatalog()
+	rule := &pricingrules.ThreeForTwoRule{SKU: "atv"}
+	basket := pricingrules.Basket{Items: []pricingrules.Item{line(t, c, "atv", 7)}}
+
+	// Two triples are claimed together and the seventh Apple TV is left over
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Equal(t, 2, claims[0].Repeats)
+	assert.Equal(t, 4, claims[0].Items[0].Quantity)
+	assert.Equal(t, 2, claims[0].Items[1].Quantity)
+	assert.Equal(t, "-219.00 AUD", claims[0].Items[1].Adjustments[0].Amount.String())
+	assert.Equal(t, "547.50 AUD", basket.Total(claims).String())
+
+	// A quantity far too large to price item by item
+	basket.Items[0].Quantity = decimal.NewFromInt(9300000000002)
+	claims, err = rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "678900000000219.00 AUD", basket.Total(claims).String())
+}
+
+func TestPercentOffRule_Apply_CapRunsOutWithinLine(t *testing.T) {
+	c := catalog.NewCatalog()
+	rule := &pricingrules.PercentOffRule{SKU: "ipd", Percent: decimal.NewFromInt(10), Cap: aud("120.00")}
+	basket := pricingrules.Basket{Items: []pricingrules.Item{line(t, c, "ipd", 5)}}
+
+	// Two iPads get the full 10% off, the third what is left of the cap and
+	// the last two are left for other rules
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims[0].Items, 2)
+	assert.Equal(t, 2, claims[0].Items[0].Quantity)
+	assert.Equal(t, 1, claims[0].Items[1].Quantity)
+	assert.Equal(t, "2629.95 AUD", basket.Total(claims).String())
+}
+
+func TestBulkDiscountRule_Apply_MeasuredQuantity(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "apl", Name: "Apples", Price: decimal.RequireFromString("4.40"), Unit: catalog.Kilogram})
+	assert.NoError(t, err)
+	rule := &pricingrules.BulkDiscountRule{SKU: "apl", MinQuantity: 3, NewPrice: aud("4.00")}
+
+	// 1.5 kg and 1.25 kg falls short of 3 kg
+	basket := pricingrules.Basket{Items: []pricingrules.Item{
+		{SKU: "apl", Price: aud("4.40"), Quantity: decimal.RequireFromString("1.5"), Unit: catalog.Kilogram},
+		{SKU: "apl", Price: aud("4.40"), Quantity: decimal.RequireFromString("1.25"), Unit: catalog.Kilogram},
+	}}
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+
+	basket.Items = append(basket.Items, pricingrules.Item{SKU: "apl", Price: aud("4.40"), Quantity: decimal.RequireFromString("0.5"), Unit: catalog.Kilogram})
+	claims, err = rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "13.00 AUD", claimedTotal(claims).String())
+}
+
+func TestAmountOffRule_Apply_PerUnitOfMeasure(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "apl", Name: "Apples", Price: decimal.RequireFromString("4.40"), Unit: catalog.Kilogram})
+	assert.NoError(t, err)
+	rule := &pricingrules.AmountOffRule{SKU: "apl", Amount: aud("1.00")}
+
+	basket := pricingrules.Basket{Items: []pricingrules.Item{
+		{SKU: "apl", Price: aud("4.40"), Quantity: decimal.RequireFromString("2.5"), Unit: catalog.Kilogram},
+	}}
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "8.50 AUD", claimedTotal(claims).String())
+}
+
+func TestBuyXGetYRule_Apply_IgnoresMeasuredItems(t *testing.T) {
+	c := catalog.NewCatalog()
+	err := c.AddProduct(context.Background(), catalog.Product{SKU: "apl", Name: "Apples", Price: decimal.RequireFromString("4.40"), Unit: catalog.Kilogram})
+	assert.NoError(t, err)
+	rule := &pricingrules.BuyXGetYRule{SKUs: []string{"apl"}, Buy: 2, Get: 1, Percent: decimal.NewFromInt(100)}
+
+	item := pricingrules.Item{SKU: "apl", Price: aud("4.40"), Quantity: decimal.RequireFromString("1"), Unit: catalog.Kilogram}
+	basket := pricingrules.Basket{Items: []pricingrules.Item{item, item, item}}
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+}
+
+func TestItem_Is(t *testing.T) {
+	variant := pricingrules.Item{SKU: "ipd-256", Parent: "ipd"}
+	assert.True(t, variant.Is("ipd-256"))
+	assert.True(t, variant.Is("ipd"))
+	assert.False(t, variant.Is("ipd-128"))
+	assert.False(t, pricingrules.Item{SKU: "atv"}.Is(""))
+}
+
+func TestRules_TargetParentOrVariant(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	for _, variant := range []catalog.Product{
+		{SKU: "ipd-256", Name: "Super iPad 256GB", Price: decimal.NewFromFloat(699.99), Parent: "ipd"},
+		{SKU: "ipd-128", Name: "Super iPad 128GB", Price: decimal.NewFromFloat(549.99), Parent: "ipd"},
+	} {
+		assert.NoError(t, c.AddProduct(ctx, variant))
+	}
+	basket, err := pricingrules.NewBasket(c, "ipd-256", "ipd-128", "ipd-128", "atv")
+	assert.NoError(t, err)
+
+	// A rule for the parent covers every variant
+	parent := &pricingrules.PercentOffRule{SKU: "ipd", Percent: decimal.NewFromInt(10)}
+	claims, err := parent.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims[0].Items, 3)
+
+	// A rule for a variant covers that variant only
+	variant := &pricingrules.PercentOffRule{SKU: "ipd-128", Percent: decimal.NewFromInt(10)}
+	claims, err = variant.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims[0].Items, 2)
+	assert.Equal(t, "989.982 AUD", claimedTotal(claims).String())
+
+	threeForTwo := &pricingrules.ThreeForTwoRule{SKU: "ipd"}
+	claims, err = threeForTwo.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "1249.98 AUD", claimedTotal(claims).String())
+}
@@ -0,0 +1,259 @@
+package pricingrules
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+// StackingPolicy decides how the rules of a RuleSet combine when more than one
+// of them applies to the same items
+type StackingPolicy int
+
+const (
+	// Exclusive prices each item with at most one rule. Rules claim items in
+	// order and later rules only see what earlier rules left unclaimed.
+	Exclusive StackingPolicy = iota
+	// BestForCustomer evaluates every rule on its own against the whole basket
+	// and keeps the one that gives the lowest total. Ties go to the earlier rule.
+	BestForCustomer
+	// Sequential applies every rule in order to the whole basket, each rule
+	// starting from the prices the previous rule left behind
+	Sequential
+	// Optimal prices each item with at most one rule, like Exclusive, but
+	// searches for the assignment of rules to items with the lowest total.
+	// See Optimise.
+	Optimal
+)
+
+func (p StackingPolicy) String() string {
+	switch p {
+	case Exclusive:
+		return "exclusive"
+	case BestForCustomer:
+		return "best-for-customer"
+	case Sequential:
+		return "sequential"
+	case Optimal:
+		return "optimal"
+	default:
+		return fmt.Sprintf("StackingPolicy(%d)", int(p))
+	}
+}
+
+// ParseStackingPolicy reads a policy name as returned by StackingPolicy.String
+func ParseStackingPolicy(s string) (StackingPolicy, error) {
+	for _, policy := range []StackingPolicy{Exclusive, BestForCustomer, Sequential, Optimal} {
+		if policy.String() == s {
+			return policy, nil
+		}
+	}
+	return Exclusive, fmt.Errorf("unknown stacking policy: %q", s)
+}
+
+// RuleSet is an ordered list of pricing rules, such as every promotion running
+// on one SKU, combined according to a stacking policy. A RuleSet is itself a
+// PricingRule, so it can be passed to a checkout or nested in another set.
+type RuleSet struct {
+	Policy StackingPolicy
+	Rules  []PricingRule
+	// Budget bounds the search of the Optimal policy; zero means
+	// DefaultSearchBudget
+	Budget int
+}
+
+func (s *RuleSet) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	switch s.Policy {
+	case Exclusive:
+		return s.applyExclusive(basket, catalog)
+	case BestForCustomer:
+		return s.applyBestForCustomer(basket, catalog)
+	case Sequential:
+		return s.applySequential(basket, catalog)
+	case Optimal:
+		assignment, err := Optimise(basket, s.Rules, catalog, s.Budget)
+		if err != nil {
+			return nil, err
+		}
+		return assignment.Claims(), nil
+	default:
+		return nil, fmt.Errorf("unknown stacking policy: %s", s.Policy)
+	}
+}
+
+func (s *RuleSet) applyExclusive(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	var claims []Claim
+	taken := make([]int, len(basket.Items))
+	for _, rule := range s.Rules {
+		open, positions := basket.without(taken)
+		if len(open.Items) == 0 {
+			break
+		}
+		ruleClaims, err := rule.Apply(open, catalog)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkClaims(rule, open, ruleClaims); err != nil {
+			return nil, err
+		}
+		for _, claim := range mapClaims(open, ruleClaims, positions) {
+			for _, item := range claim.Items {
+				taken[item.Index] += item.Quantity
+			}
+			claims = append(claims, claim)
+		}
+	}
+	return claims, nil
+}
+
+func (s *RuleSet) applyBestForCustomer(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	var best []Claim
+	bestTotal := basket.Total(nil)
+	for _, rule := range s.Rules {
+		claims, err := rule.Apply(basket, catalog)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkClaims(rule, basket, claims); err != nil {
+			return nil, err
+		}
+		if total := basket.Total(claims); total.LessThan(bestTotal) {
+			best, bestTotal = claims, total
+		}
+	}
+	return best, nil
+}
+
+// part is some of the whole items of a basket item, or all of it, as priced by
+// the rules of a Sequential set so far
+type part struct {
+	// origin is the position of the basket item the part was split from
+	origin int
+	item   Item
+	// amount is what the part costs after the rules so far
+	amount      money.Money
+	adjustments []Adjustment
+	touched     bool
+}
+
+// split takes quantity of the part's whole items off it into a part of their
+// own, sharing the part's adjustments between the two in proportion
+func (p *part) split(quantity int) part {
+	taken := *p
+	taken.item.Quantity = decimal.NewFromInt(int64(quantity))
+	p.item.Quantity = p.item.Units().Sub(taken.item.Quantity)
+	taken.amount = p.item.amountOf(quantity)
+	p.amount = p.amount.Sub(taken.amount)
+	share := taken.item.Quantity.Div(taken.item.Quantity.Add(p.item.Quantity))
+	taken.adjustments = make([]Adjustment, len(p.adjustments))
+	for i, adjustment := range p.adjustments {
+		taken.adjustments[i] = Adjustment{Rule: adjustment.Rule, Amount: adjustment.Amount.Mul(share)}
+		p.adjustments[i].Amount = adjustment.Amount.Sub(taken.adjustments[i].Amount)
+	}
+	return taken
+}
+
+func (s *RuleSet) applySequential(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	parts := make([]part, len(basket.Items))
+	for i, item := range basket.Items {
+		parts[i] = part{origin: i, item: item, amount: item.Amount()}
+	}
+	for _, rule := range s.Rules {
+		current := Basket{Items: make([]Item, len(parts)), Time: basket.Time}
+		for i, p := range parts {
+			current.Items[i] = p.item
+		}
+		claims, err := rule.Apply(current, catalog)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkClaims(rule, current, claims); err != nil {
+			return nil, err
+		}
+
+		// A rule that claims some of a part's whole items splits them off, so
+		// later rules see each share at its own price
+		claimed := make([][]ClaimedItem, len(parts))
+		for _, claim := range mapClaims(current, claims, nil) {
+			for _, item := range claim.Items {
+				claimed[item.Index] = append(claimed[item.Index], item)
+			}
+		}
+		var next []part
+		for i, p := range parts {
+			left := true
+			for _, item := range claimed[i] {
+				repriced := p
+				if item.Quantity < p.item.count() {
+					repriced = p.split(item.Quantity)
+				} else {
+					left = false
+				}
+				// Later rules see the new price per item or per unit of measure
+				repriced.amount = item.Price
+				repriced.item.Price = money.New(item.Price.Amount.Div(repriced.item.Units()), item.Price.Currency)
+				repriced.adjustments = append(slices.Clip(repriced.adjustments), item.Adjustments...)
+				repriced.touched = true
+				next = append(next, repriced)
+			}
+			if left {
+				next = append(next, p)
+			}
+		}
+		parts = next
+	}
+
+	// Rules may have regrouped the same items several times over, so the
+	// combined outcome is reported as one claim on every repriced share of an
+	// item, carrying the adjustments of every rule that touched it.
+	var claim Claim
+	for _, p := range parts {
+		if p.touched {
+			claim.Items = append(claim.Items, ClaimedItem{Index: p.origin, Quantity: p.item.count(), Price: p.amount, Adjustments: p.adjustments})
+		}
+	}
+	if len(claim.Items) == 0 {
+		return nil, nil
+	}
+	return []Claim{claim}, nil
+}
+
+// checkClaims verifies that claims only reference items in the basket and
+// claim no more of any of them than it holds
+func checkClaims(rule PricingRule, basket Basket, claims []Claim) error {
+	taken := make([]int, len(basket.Items))
+	for _, claim := range claims {
+		for _, item := range claim.Items {
+			if _, ok := basket.take(taken, item); !ok {
+				return invalidClaimError(rule, item.Index)
+			}
+		}
+	}
+	return nil
+}
+
+// mapClaims returns checked claims made on basket with the quantity each
+// item takes spelled out, and with their indexes mapped to positions in
+// the full basket if positions is not nil
+func mapClaims(basket Basket, claims []Claim, positions []int) []Claim {
+	taken := make([]int, len(basket.Items))
+	mapped := make([]Claim, len(claims))
+	for i, claim := range claims {
+		mapped[i] = Claim{Items: make([]ClaimedItem, len(claim.Items)), Repeats: claim.Repeats}
+		for j, item := range claim.Items {
+			item.Quantity, _ = basket.take(taken, item)
+			if positions != nil {
+				item.Index = positions[item.Index]
+			}
+			mapped[i].Items[j] = item
+		}
+	}
+	return mapped
+}
+
+func invalidClaimError(rule PricingRule, index int) error {
+	return fmt.Errorf("pricing rule %T made an invalid claim on item %d", rule, index)
+}
@@ -0,0 +1,185 @@
+package pricingrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func TestRuleSet_Exclusive_FirstRuleClaimsFirst(t *testing.T) {
+	c := catalog.NewCatalog()
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.Exclusive,
+		Rules: []pricingrules.PricingRule{
+			&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+		},
+	}
+
+	basket, err := pricingrules.NewBasket(c, repeat("ipd", 7)...)
+	assert.NoError(t, err)
+
+	claims, err := set.Apply(basket, c)
+	assert.NoError(t, err)
+
+	// Two triples go to the 3 for 2 deal, leaving a single iPad that is below
+	// the bulk threshold and charged at list price
+	assert.Len(t, claims, 2)
+	assert.Equal(t, aud("549.99").MulInt(5).String(), basket.Total(claims).String())
+}
+
+func TestRuleSet_Exclusive_OrderMatters(t *testing.T) {
+	c := catalog.NewCatalog()
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.Exclusive,
+		Rules: []pricingrules.PricingRule{
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+			&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+		},
+	}
+
+	basket, err := pricingrules.NewBasket(c, repeat("ipd", 6)...)
+	assert.NoError(t, err)
+
+	claims, err := set.Apply(basket, c)
+	assert.NoError(t, err)
+
+	// The bulk discount claims every iPad, so the 3 for 2 deal never applies
+	assert.Len(t, claims, 1)
+	assert.Equal(t, aud("499.99").MulInt(6).String(), basket.Total(claims).String())
+}
+
+func TestRuleSet_BestForCustomer(t *testing.T) {
+	c := catalog.NewCatalog()
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.BestForCustomer,
+		Rules: []pricingrules.PricingRule{
+			&pricingrules.ThreeForTwoRule{SKU: "atv"},
+			&pricingrules.BulkDiscountRule{SKU: "atv", MinQuantity: 2, NewPrice: aud("80.00")},
+		},
+	}
+
+	testCases := []struct {
+		description   string
+		quantity      int
+		expectedTotal string
+	}{
+		{"Below both thresholds", 1, "109.50 AUD"},
+		{"Bulk discount is cheaper", 2, "160.00 AUD"},
+		{"3 for 2 is cheaper", 3, "219.00 AUD"},
+		{"Bulk discount is cheaper again", 4, "320.00 AUD"},
+	}
+
+	for _, tc := range testCases {
+		basket, err := pricingrules.NewBasket(c, repeat("atv", tc.quantity)...)
+		assert.NoError(t, err)
+
+		claims, err := set.Apply(basket, c)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expectedTotal, basket.Total(claims).String(), tc.description)
+	}
+}
+
+func TestRuleSet_BestForCustomer_TieKeepsEarlierRule(t *testing.T) {
+	c := catalog.NewCatalog()
+	first := &pricingrules.BulkDiscountRule{SKU: "atv", MinQuantity: 3, NewPrice: aud("73.00")}
+	second := &pricingrules.ThreeForTwoRule{SKU: "atv"}
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.BestForCustomer,
+		Rules:  []pricingrules.PricingRule{first, second},
+	}
+
+	basket, err := pricingrules.NewBasket(c, "atv", "atv", "atv")
+	assert.NoError(t, err)
+
+	claims, err := set.Apply(basket, c)
+	assert.NoError(t, err)
+
+	// Both rules price three Apple TVs at $219, the bulk discount is listed first
+	assert.Len(t, claims, 1)
+	for _, item := range claims[0].Items {
+		assert.Equal(t, "73.00 AUD", item.Price.String())
+	}
+}
+
+func TestRuleSet_Sequential_DiscountsCompound(t *testing.T) {
+	c := catalog.NewCatalog()
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.Sequential,
+		Rules: []pricingrules.PricingRule{
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+			&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+		},
+	}
+
+	basket, err := pricingrules.NewBasket(c, repeat("ipd", 6)...)
+	assert.NoError(t, err)
+
+	claims, err := set.Apply(basket, c)
+	assert.NoError(t, err)
+
+	// Every iPad drops to the bulk price, then one in each triple becomes free
+	assert.Equal(t, aud("499.99").MulInt(4).String(), basket.Total(claims).String())
+}
+
+func TestRuleSet_Sequential_LaterRuleOverridesPrice(t *testing.T) {
+	c := catalog.NewCatalog()
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.Sequential,
+		Rules: []pricingrules.PricingRule{
+			&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+		},
+	}
+
+	basket, err := pricingrules.NewBasket(c, repeat("ipd", 6)...)
+	assert.NoError(t, err)
+
+	claims, err := set.Apply(basket, c)
+	assert.NoError(t, err)
+
+	// The bulk discount sets an absolute price, replacing the free items
+	assert.Equal(t, aud("499.99").MulInt(6).String(), basket.Total(claims).String())
+}
+
+func TestRuleSet_Sequential_NoRuleApplies(t *testing.T) {
+	c := catalog.NewCatalog()
+	set := &pricingrules.RuleSet{
+		Policy: pricingrules.Sequential,
+		Rules: []pricingrules.PricingRule{
+			&pricingrules.ThreeForTwoRule{SKU: "ipd"},
+			&pricingrules.BulkDiscountRule{SKU: "ipd", MinQuantity: 5, NewPrice: aud("499.99")},
+		},
+	}
+
+	basket, err := pricingrules.NewBasket(c, "ipd", "ipd")
+	assert.NoError(t, err)
+
+	claims, err := set.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+}
+
+func TestRuleSet_PropagatesRuleErrors(t *testing.T) {
+	c := catalog.NewCatalog()
+	basket := pricingrules.Basket{Items: []pricingrules.Item{{SKU: "unknown", Price: aud("10")}}}
+
+	for _, policy := range []pricingrules.StackingPolicy{pricingrules.Exclusive, pricingrules.BestForCustomer, pricingrules.Sequential} {
+		set := &pricingrules.RuleSet{
+			Policy: policy,
+			Rules:  []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "unknown"}},
+		}
+		_, err := set.Apply(basket, c)
+		assert.EqualError(t, err, "product not found: unknown", policy.String())
+	}
+}
+
+func TestRuleSet_UnknownPolicy(t *testing.T) {
+	set := &pricingrules.RuleSet{Policy: pricingrules.StackingPolicy(42)}
+
+	_, err := set.Apply(pricingrules.Basket{}, catalog.NewCatalog())
+	assert.EqualError(t, err, "unknown stacking policy: StackingPolicy(42)")
+}
@@ -0,0 +1,87 @@
+package pricingrules
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+)
+
+// SelectorKind is what a selector picks items by
+type SelectorKind string
+
+const (
+	// BySKU picks the items of one SKU and, for a parent, its variants
+	BySKU SelectorKind = "sku"
+	// ByCategory picks the items in a category or any of its subcategories
+	ByCategory SelectorKind = "category"
+	// ByBrand picks the items of one brand
+	ByBrand SelectorKind = "brand"
+	// ByTag picks the items carrying a tag
+	ByTag SelectorKind = "tag"
+)
+
+// Selector picks the basket items a rule applies to. Wherever a rule takes a
+// SKU it takes a selector written as kind:value instead, such as
+// "category:accessories", "brand:Apple" or "tag:clearance"; a plain SKU
+// selects by SKU. Items are matched when the basket is priced, so a product
+// added to a category later is picked up by every rule for that category.
+// Brands, tags and categories are matched without regard to case.
+type Selector struct {
+	Kind  SelectorKind
+	Value string
+}
+
+// ParseSelector reads a selector from its kind:value form. Text without a
+// known kind prefix is a SKU.
+func ParseSelector(text string) (Selector, error) {
+	if kind, value, ok := strings.Cut(text, ":"); ok {
+		switch kind := SelectorKind(kind); kind {
+		case ByCategory, ByBrand, ByTag:
+			if value == "" {
+				return Selector{}, internal.NewInvalidSelectorError(text, "missing "+string(kind))
+			}
+			return Selector{Kind: kind, Value: value}, nil
+		}
+	}
+	return Selector{Kind: BySKU, Value: text}, nil
+}
+
+func (s Selector) String() string {
+	if s.Kind == BySKU {
+		return s.Value
+	}
+	return string(s.Kind) + ":" + s.Value
+}
+
+// Matches reports whether the selector picks item
+func (s Selector) Matches(item Item) bool {
+	switch s.Kind {
+	case BySKU:
+		return item.SKU == s.Value || (item.Parent != "" && item.Parent == s.Value)
+	case ByCategory:
+		return catalog.WithinCategory(item.Category, s.Value)
+	case ByBrand:
+		return item.Brand != "" && strings.EqualFold(item.Brand, s.Value)
+	case ByTag:
+		return slices.ContainsFunc(item.Tags, func(tag string) bool {
+			return strings.EqualFold(tag, s.Value)
+		})
+	}
+	return false
+}
+
+// checkTarget makes sure a rule's target can be priced: a SKU must be in the
+// catalog, while a category, brand or tag may have no products yet
+func checkTarget(products *catalog.Catalog, target string) error {
+	selector, err := ParseSelector(target)
+	if err != nil {
+		return err
+	}
+	if selector.Kind == BySKU {
+		_, err = products.GetProduct(context.Background(), target)
+	}
+	return err
+}
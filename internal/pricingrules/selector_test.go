@@ -0,0 +1,110 @@
+package pricingrules_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected pricingrules.Selector
+	}{
+		{"ipd", pricingrules.Selector{Kind: pricingrules.BySKU, Value: "ipd"}},
+		{"category:accessories/cables", pricingrules.Selector{Kind: pricingrules.ByCategory, Value: "accessories/cables"}},
+		{"brand:Apple", pricingrules.Selector{Kind: pricingrules.ByBrand, Value: "Apple"}},
+		{"tag:clearance", pricingrules.Selector{Kind: pricingrules.ByTag, Value: "clearance"}},
+		// An unknown kind is part of the SKU
+		{"colour:red", pricingrules.Selector{Kind: pricingrules.BySKU, Value: "colour:red"}},
+	}
+	for _, test := range tests {
+		selector, err := pricingrules.ParseSelector(test.text)
+		assert.NoError(t, err, test.text)
+		assert.Equal(t, test.expected, selector, test.text)
+		assert.Equal(t, test.text, selector.String())
+	}
+
+	_, err := pricingrules.ParseSelector("category:")
+	var invalid internal.ErrInvalidSelector
+	assert.True(t, errors.As(err, &invalid))
+	assert.EqualError(t, err, `invalid selector "category:": missing category`)
+}
+
+func TestItem_IsSelector(t *testing.T) {
+	cable := pricingrules.Item{SKU: "hdmi", Category: "accessories/cables", Brand: "Belkin", Tags: []string{"clearance"}}
+	assert.True(t, cable.Is("category:accessories"))
+	assert.True(t, cable.Is("category:Accessories/Cables"))
+	assert.False(t, cable.Is("category:access"))
+	assert.False(t, cable.Is("category:accessories/cables/hdmi"))
+	assert.True(t, cable.Is("brand:belkin"))
+	assert.False(t, cable.Is("brand:Apple"))
+	assert.True(t, cable.Is("tag:CLEARANCE"))
+	assert.False(t, cable.Is("tag:gift"))
+	assert.False(t, pricingrules.Item{SKU: "vga"}.Is("category:accessories"))
+}
+
+func TestRules_TargetSelector(t *testing.T) {
+	ctx := context.Background()
+	c := catalog.NewCatalog()
+	rule := &pricingrules.PercentOffRule{SKU: "category:accessories", Percent: decimal.NewFromInt(10)}
+
+	basket, err := pricingrules.NewBasket(c, "vga", "atv", "vga")
+	assert.NoError(t, err)
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims[0].Items, 2)
+	assert.Equal(t, "54.00 AUD", claimedTotal(claims).String())
+
+	// A product added to the category later is covered without changing the rule
+	hdmi := catalog.Product{SKU: "hdmi", Name: "HDMI cable", Price: decimal.RequireFromString("20.00"), Category: "accessories/cables"}
+	assert.NoError(t, c.AddProduct(ctx, hdmi))
+	basket, err = pricingrules.NewBasket(c, "vga", "hdmi")
+	assert.NoError(t, err)
+	claims, err = rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "45.00 AUD", claimedTotal(claims).String())
+
+	// Any rule takes a selector where it takes a SKU
+	threeForTwo := &pricingrules.ThreeForTwoRule{SKU: "brand:Apple"}
+	basket, err = pricingrules.NewBasket(c, "ipd", "atv", "atv", "hdmi")
+	assert.NoError(t, err)
+	claims, err = threeForTwo.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Equal(t, "659.49 AUD", claimedTotal(claims).String())
+
+	// A selector with nothing in the catalog yet is not an error
+	empty := &pricingrules.BulkDiscountRule{SKU: "tag:clearance", MinQuantity: 1, NewPrice: aud("1.00")}
+	claims, err = empty.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Empty(t, claims)
+}
+
+func TestRegistry_Load_Selectors(t *testing.T) {
+	doc := `
+rules:
+  - type: percent_off
+    name: 10% off accessories
+    sku: category:accessories
+    percent: 10
+  - type: bundle_price
+    skus: [brand:Apple, category:]
+    price: 599
+`
+	_, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.EqualError(t, err, `rules[1]: invalid selector "category:": missing category`)
+
+	doc = strings.Replace(doc, "category:]", "category:computers/tablets]", 1)
+	rules, err := pricingrules.NewRegistry().Load(strings.NewReader(doc), pricingrules.YAML, catalog.NewCatalog())
+	assert.NoError(t, err)
+	assert.Equal(t, "category:accessories", rules[0].(*pricingrules.PercentOffRule).SKU)
+	assert.Equal(t, []string{"brand:Apple", "category:computers/tablets"}, rules[1].(*pricingrules.BundlePriceRule).SKUs)
+}
@@ -0,0 +1,174 @@
+package pricingrules
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/money"
+)
+
+// TierMode decides how a tiered price schedule applies to a quantity
+type TierMode int
+
+const (
+	// AllUnits charges every unit at the price of the tier the total quantity
+	// falls in
+	AllUnits TierMode = iota
+	// Graduated charges each unit at the price of the tier its own position
+	// falls in, so the first units keep the lower tiers' prices
+	Graduated
+)
+
+func (m TierMode) String() string {
+	switch m {
+	case AllUnits:
+		return "all-units"
+	case Graduated:
+		return "graduated"
+	default:
+		return fmt.Sprintf("TierMode(%d)", int(m))
+	}
+}
+
+// ParseTierMode reads a tier mode from its String form
+func ParseTierMode(s string) (TierMode, error) {
+	for _, mode := range []TierMode{AllUnits, Graduated} {
+		if s == mode.String() {
+			return mode, nil
+		}
+	}
+	return AllUnits, fmt.Errorf("unknown tier mode: %q", s)
+}
+
+// Tier is one step of a volume price schedule covering quantities from
+// MinQuantity to MaxQuantity inclusive. A zero MaxQuantity has no upper bound.
+type Tier struct {
+	MinQuantity int
+	MaxQuantity int
+	Price       money.Money
+}
+
+func (t Tier) contains(quantity decimal.Decimal) bool {
+	return !quantity.LessThan(decimal.NewFromInt(int64(t.MinQuantity))) &&
+		(t.MaxQuantity == 0 || !quantity.GreaterThan(decimal.NewFromInt(int64(t.MaxQuantity))))
+}
+
+// TieredPriceRule prices a SKU on a volume schedule such as 5-9 at $499.99 and
+// 10+ at $479.99. Quantities outside every tier are charged at list price. In
+// Graduated mode a basket item holding several whole items is split across
+// the tiers they fall in. For products sold by measure, tier prices are per
+// unit of measure and a measured item is priced by the tier the running
+// quantity reaches with it.
+type TieredPriceRule struct {
+	Name  string
+	SKU   string
+	Tiers []Tier
+	Mode  TierMode
+}
+
+func (r *TieredPriceRule) name() string {
+	return nameOr(r.Name, "Volume pricing on "+r.SKU)
+}
+
+// Validate checks that the tiers are in ascending order and do not overlap.
+// Only the last tier may be open-ended.
+func (r *TieredPriceRule) Validate() error {
+	for i, tier := range r.Tiers {
+		if tier.MinQuantity < 1 {
+			return fmt.Errorf("tier %d must start at a quantity of at least 1", i+1)
+		}
+		if tier.MaxQuantity != 0 && tier.MaxQuantity < tier.MinQuantity {
+			return fmt.Errorf("tier %d ends before it starts", i+1)
+		}
+		if tier.Price.IsNegative() {
+			return fmt.Errorf("tier %d has a negative price", i+1)
+		}
+		if i == 0 {
+			continue
+		}
+		previous := r.Tiers[i-1]
+		if tier.MinQuantity <= previous.MinQuantity {
+			return fmt.Errorf("tier %d must start after tier %d", i+1, i)
+		}
+		if previous.MaxQuantity == 0 || tier.MinQuantity <= previous.MaxQuantity {
+			return fmt.Errorf("tier %d overlaps tier %d", i+1, i)
+		}
+	}
+	return nil
+}
+
+// tierFor returns the tier covering quantity, if any
+func (r *TieredPriceRule) tierFor(quantity decimal.Decimal) (Tier, bool) {
+	for _, tier := range r.Tiers {
+		if tier.contains(quantity) {
+			return tier, true
+		}
+	}
+	return Tier{}, false
+}
+
+func (r *TieredPriceRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	indexes := basket.indexesOf(r.SKU)
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+	if err := checkTarget(catalog, r.SKU); err != nil {
+		return nil, err
+	}
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	for _, tier := range r.Tiers {
+		if err := checkCurrency(basket, tier.Price); err != nil {
+			return nil, err
+		}
+	}
+
+	var claim Claim
+	total := basket.quantity(indexes)
+	running := decimal.Zero
+	for _, index := range indexes {
+		item := basket.Items[index]
+		if r.Mode == Graduated && !item.Measured() {
+			claim.Items = append(claim.Items, r.graduate(basket, index, running)...)
+			running = running.Add(item.Units())
+			continue
+		}
+		running = running.Add(item.Units())
+		quantity := running
+		if r.Mode == AllUnits {
+			quantity = total
+		}
+		if tier, ok := r.tierFor(quantity); ok {
+			claim.Items = append(claim.Items, reprice(basket, index, 0, tier.Price.Mul(item.Units()), r.name()))
+		}
+	}
+	if len(claim.Items) == 0 {
+		return nil, nil
+	}
+	return []Claim{claim}, nil
+}
+
+// graduate prices the whole items of the basket item at index that come after
+// the first running units of the SKU, claiming those that fall in each tier
+// at its price
+func (r *TieredPriceRule) graduate(basket Basket, index int, running decimal.Decimal) []ClaimedItem {
+	count := decimal.NewFromInt(int64(basket.Items[index].count()))
+	one := decimal.NewFromInt(1)
+	var items []ClaimedItem
+	for _, tier := range r.Tiers {
+		// The item's n-th whole item is the tier's if running + n is within it
+		first := decimal.Max(one, decimal.NewFromInt(int64(tier.MinQuantity)).Sub(running).Ceil())
+		last := count
+		if tier.MaxQuantity != 0 {
+			last = decimal.Min(count, decimal.NewFromInt(int64(tier.MaxQuantity)).Sub(running).Floor())
+		}
+		if last.LessThan(first) {
+			continue
+		}
+		n := int(last.Sub(first).IntPart()) + 1
+		items = append(items, reprice(basket, index, n, tier.Price.MulInt(int64(n)), r.name()))
+	}
+	return items
+}
@@ -0,0 +1,116 @@
+package pricingrules_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func ipadTiers() []pricingrules.Tier {
+	return []pricingrules.Tier{
+		{MinQuantity: 5, MaxQuantity: 9, Price: aud("499.99")},
+		{MinQuantity: 10, Price: aud("479.99")},
+	}
+}
+
+func TestTieredPriceRule_Apply(t *testing.T) {
+	c := catalog.NewCatalog()
+
+	testCases := []struct {
+		mode     pricingrules.TierMode
+		quantity int
+		expected string
+	}{
+		{pricingrules.AllUnits, 4, "2199.96 AUD"},
+		{pricingrules.AllUnits, 5, "2499.95 AUD"},
+		{pricingrules.AllUnits, 9, "4499.91 AUD"},
+		{pricingrules.AllUnits, 10, "4799.90 AUD"},
+		{pricingrules.AllUnits, 12, "5759.88 AUD"},
+		{pricingrules.Graduated, 4, "2199.96 AUD"},
+		// 4 at 549.99 and 1 at 499.99
+		{pricingrules.Graduated, 5, "2699.95 AUD"},
+		// 4 at 549.99, 5 at 499.99 and 3 at 479.99
+		{pricingrules.Graduated, 12, "6139.88 AUD"},
+	}
+
+	for _, tc := range testCases {
+		rule := &pricingrules.TieredPriceRule{SKU: "ipd", Tiers: ipadTiers(), Mode: tc.mode}
+		basket, err := pricingrules.NewBasket(c, repeat("ipd", tc.quantity)...)
+		assert.NoError(t, err)
+
+		claims, err := rule.Apply(basket, c)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, basket.Total(claims).String(), "%s x%d", tc.mode, tc.quantity)
+	}
+}
+
+func TestTieredPriceRule_Apply_GraduatedLeavesListPriceUnitsUnclaimed(t *testing.T) {
+	rule := &pricingrules.TieredPriceRule{SKU: "ipd", Tiers: ipadTiers(), Mode: pricingrules.Graduated}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, repeat("ipd", 6)...)
+	assert.NoError(t, err)
+
+	claims, err := rule.Apply(basket, c)
+	assert.NoError(t, err)
+	assert.Len(t, claims, 1)
+	assert.Len(t, claims[0].Items, 2)
+	assert.Equal(t, 4, claims[0].Items[0].Index)
+	assert.Equal(t, "Volume pricing on ipd", claims[0].Items[0].Adjustments[0].Rule)
+}
+
+func TestTieredPriceRule_Validate(t *testing.T) {
+	testCases := []struct {
+		description string
+		tiers       []pricingrules.Tier
+		expected    string
+	}{
+		{"valid", ipadTiers(), ""},
+		{"no tiers", nil, ""},
+		{"starts at zero", []pricingrules.Tier{{MinQuantity: 0, Price: aud("1")}}, "tier 1 must start at a quantity of at least 1"},
+		{"ends before start", []pricingrules.Tier{{MinQuantity: 5, MaxQuantity: 4, Price: aud("1")}}, "tier 1 ends before it starts"},
+		{"negative price", []pricingrules.Tier{{MinQuantity: 5, Price: aud("-1")}}, "tier 1 has a negative price"},
+		{
+			"unordered",
+			[]pricingrules.Tier{{MinQuantity: 10, Price: aud("1")}, {MinQuantity: 5, MaxQuantity: 9, Price: aud("2")}},
+			"tier 2 must start after tier 1",
+		},
+		{
+			"overlapping",
+			[]pricingrules.Tier{{MinQuantity: 5, MaxQuantity: 10, Price: aud("2")}, {MinQuantity: 10, Price: aud("1")}},
+			"tier 2 overlaps tier 1",
+		},
+		{
+			"open-ended tier before another",
+			[]pricingrules.Tier{{MinQuantity: 5, Price: aud("2")}, {MinQuantity: 10, Price: aud("1")}},
+			"tier 2 overlaps tier 1",
+		},
+	}
+
+	for _, tc := range testCases {
+		rule := &pricingrules.TieredPriceRule{SKU: "ipd", Tiers: tc.tiers}
+		err := rule.Validate()
+		if tc.expected == "" {
+			assert.NoError(t, err, tc.description)
+		} else {
+			assert.EqualError(t, err, tc.expected, tc.description)
+		}
+	}
+}
+
+func TestTieredPriceRule_Apply_InvalidTiers(t *testing.T) {
+	rule := &pricingrules.TieredPriceRule{SKU: "ipd", Tiers: []pricingrules.Tier{
+		{MinQuantity: 10, Price: aud("479.99")},
+		{MinQuantity: 5, Price: aud("499.99")},
+	}}
+	c := catalog.NewCatalog()
+
+	basket, err := pricingrules.NewBasket(c, "ipd")
+	assert.NoError(t, err)
+
+	_, err = rule.Apply(basket, c)
+	assert.EqualError(t, err, "tier 2 must start after tier 1")
+}
@@ -0,0 +1,149 @@
+package pricingrules
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spa5k/zeller_go/internal"
+	"github.com/spa5k/zeller_go/internal/catalog"
+)
+
+// Snapshot is one successfully loaded version of a rule file. Snapshots are
+// never modified once published, so a checkout holding one keeps pricing with
+// the same rules for its whole lifetime.
+type Snapshot struct {
+	Rules    []PricingRule
+	Version  int
+	LoadedAt time.Time
+}
+
+// Watcher keeps the rules from a pricing rule file up to date. Every new
+// version of the file is validated in full before it replaces the current
+// snapshot; a broken file is logged and the last good snapshot stays live.
+type Watcher struct {
+	registry *Registry
+	catalog  *catalog.Catalog
+	path     string
+
+	current atomic.Pointer[Snapshot]
+
+	// mu serialises reloads and guards the fields below
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	digest  [sha256.Size]byte
+	lastErr error
+}
+
+// NewWatcher loads the rule file at path and returns a watcher serving it.
+// It fails if the initial file cannot be loaded, since there is no previous
+// version to fall back to.
+func NewWatcher(registry *Registry, path string, catalog *catalog.Catalog) (*Watcher, error) {
+	w := &Watcher{
+		registry: registry,
+		catalog:  catalog,
+		path:     path,
+	}
+	if _, err := w.reload(true); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Snapshot returns the rules currently in force
+func (w *Watcher) Snapshot() *Snapshot {
+	return w.current.Load()
+}
+
+// Rules returns the rules currently in force, ready to open a checkout with
+func (w *Watcher) Rules() []PricingRule {
+	return w.Snapshot().Rules
+}
+
+// LastError returns the error from the most recent reload attempt, or nil if it succeeded
+func (w *Watcher) LastError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastErr
+}
+
+// Reload reads and validates the rule file now, whether or not it looks
+// changed, for example after the catalog gained a SKU the file refers to. It
+// reports whether a new snapshot was published.
+func (w *Watcher) Reload() (bool, error) {
+	return w.reload(true)
+}
+
+// Run polls the rule file every interval and reloads it when it changes,
+// until ctx is cancelled
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	logger := internal.GetLogger(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			swapped, err := w.reload(false)
+			if err != nil {
+				logger.Error("Keeping previous pricing rules", "path", w.path, "error", err)
+			} else if swapped {
+				logger.Info("Reloaded pricing rules", "path", w.path, "version", w.Snapshot().Version)
+			}
+		}
+	}
+}
+
+func (w *Watcher) reload(force bool) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		w.lastErr = err
+		return false, err
+	}
+	if !force && info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false, nil
+	}
+	data, err := os.ReadFile(filepath.Clean(w.path))
+	if err != nil {
+		w.lastErr = err
+		return false, err
+	}
+	w.modTime, w.size = info.ModTime(), info.Size()
+
+	// Touching the file without changing it, or rewriting the same broken
+	// content, is not a new version
+	digest := sha256.Sum256(data)
+	if !force && digest == w.digest {
+		return false, w.lastErr
+	}
+	w.digest = digest
+
+	format, err := FormatFromPath(w.path)
+	if err != nil {
+		w.lastErr = err
+		return false, err
+	}
+	rules, err := w.registry.Load(bytes.NewReader(data), format, w.catalog)
+	if err != nil {
+		w.lastErr = err
+		return false, err
+	}
+
+	version := 1
+	if previous := w.Snapshot(); previous != nil {
+		version = previous.Version + 1
+	}
+	w.current.Store(&Snapshot{Rules: rules, Version: version, LoadedAt: time.Now()})
+	w.lastErr = nil
+	return true, nil
+}
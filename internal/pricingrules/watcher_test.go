@@ -0,0 +1,189 @@
+package pricingrules_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+const threeForTwoRules = `{"rules": [{"type": "three_for_two", "sku": "atv"}]}`
+const bulkRules = `{"rules": [{"type": "bulk_discount", "sku": "ipd", "min_quantity": 5, "new_price": "499.99"}]}`
+
+// writeRules replaces a rule file the way an operator should: the new version
+// is written beside it and renamed over it, so a watcher polling meanwhile
+// never reads half a file. Its modification time is moved forward so that
+// polling notices the change even on filesystems with coarse timestamps.
+func writeRules(t *testing.T, path, doc string, modTime time.Time) {
+	t.Helper()
+	temp := path + ".tmp"
+	err := os.WriteFile(temp, []byte(doc), 0o600)
+	assert.NoError(t, err)
+	err = os.Chtimes(temp, modTime, modTime)
+	assert.NoError(t, err)
+	err = os.Rename(temp, path)
+	assert.NoError(t, err)
+}
+
+func TestWatcher_InitialLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, path, threeForTwoRules, time.Now())
+
+	w, err := pricingrules.NewWatcher(pricingrules.NewRegistry(), path, catalog.NewCatalog())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, w.Snapshot().Version)
+	assert.Equal(t, []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}, w.Rules())
+}
+
+func TestWatcher_InitialLoadFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	writeRules(t, path, `{"rules": [{"type": "three_for_two", "sku": "unknown"}]}`, time.Now())
+
+	_, err := pricingrules.NewWatcher(pricingrules.NewRegistry(), path, catalog.NewCatalog())
+	assert.EqualError(t, err, "rules[0]: product not found: unknown")
+}
+
+func TestWatcher_ReloadSwapsValidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	start := time.Now()
+	writeRules(t, path, threeForTwoRules, start)
+
+	w, err := pricingrules.NewWatcher(pricingrules.NewRegistry(), path, catalog.NewCatalog())
+	assert.NoError(t, err)
+	before := w.Snapshot()
+
+	writeRules(t, path, bulkRules, start.Add(time.Second))
+	swapped, err := w.Reload()
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	assert.Equal(t, 2, w.Snapshot().Version)
+
+	_, isBulk := w.Rules()[0].(*pricingrules.BulkDiscountRule)
+	assert.True(t, isBulk)
+
+	// The previous snapshot is untouched
+	assert.Equal(t, 1, before.Version)
+	assert.Equal(t, []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}, before.Rules)
+}
+
+func TestWatcher_BrokenFileKeepsLastGoodVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	start := time.Now()
+	writeRules(t, path, threeForTwoRules, start)
+
+	w, err := pricingrules.NewWatcher(pricingrules.NewRegistry(), path, catalog.NewCatalog())
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		description string
+		doc         string
+	}{
+		{"Malformed JSON", `{"rules": [`},
+		{"Unknown rule type", `{"rules": [{"type": "half_price", "sku": "atv"}]}`},
+		{"Half-written file", `{"rules": [{"type": "bulk_discount", "sku": "ipd"`},
+		{"Empty file", ``},
+		{"No rules list", `{}`},
+	}
+
+	for i, tc := range testCases {
+		writeRules(t, path, tc.doc, start.Add(time.Duration(i+1)*time.Second))
+		swapped, err := w.Reload()
+		assert.Error(t, err, tc.description)
+		assert.False(t, swapped, tc.description)
+		assert.Equal(t, err, w.LastError(), tc.description)
+		assert.Equal(t, 1, w.Snapshot().Version, tc.description)
+		assert.Equal(t, []pricingrules.PricingRule{&pricingrules.ThreeForTwoRule{SKU: "atv"}}, w.Rules(), tc.description)
+	}
+
+	// Fixing the file publishes it
+	writeRules(t, path, bulkRules, start.Add(time.Minute))
+	swapped, err := w.Reload()
+	assert.NoError(t, err)
+	assert.True(t, swapped)
+	assert.NoError(t, w.LastError())
+	assert.Equal(t, 2, w.Snapshot().Version)
+}
+
+func TestWatcher_RunPicksUpChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	start := time.Now()
+	writeRules(t, path, threeForTwoRules, start)
+
+	w, err := pricingrules.NewWatcher(pricingrules.NewRegistry(), path, catalog.NewCatalog())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.Run(ctx, 5*time.Millisecond)
+	}()
+
+	isBulk := func() bool {
+		_, ok := w.Rules()[0].(*pricingrules.BulkDiscountRule)
+		return ok
+	}
+
+	// Touching the file without changing its content is not a new version,
+	// however often it is polled
+	writeRules(t, path, threeForTwoRules, start.Add(time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, w.Snapshot().Version)
+
+	writeRules(t, path, bulkRules, start.Add(2*time.Second))
+	assert.Eventually(t, isBulk, 5*time.Second, 5*time.Millisecond)
+	good := w.Snapshot()
+	assert.Greater(t, good.Version, 1)
+
+	writeRules(t, path, `{"rules": [`, start.Add(3*time.Second))
+	assert.Eventually(t, func() bool { return w.LastError() != nil }, 5*time.Second, 5*time.Millisecond)
+	assert.Same(t, good, w.Snapshot())
+
+	cancel()
+	wg.Wait()
+}
+
+func TestWatcher_ConcurrentReaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	start := time.Now()
+	writeRules(t, path, threeForTwoRules, start)
+
+	c := catalog.NewCatalog()
+	w, err := pricingrules.NewWatcher(pricingrules.NewRegistry(), path, c)
+	assert.NoError(t, err)
+
+	basket, err := pricingrules.NewBasket(c, "atv", "atv", "atv")
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				set := &pricingrules.RuleSet{Rules: w.Rules()}
+				_, err := set.Apply(basket, c)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	for i := 1; i <= 10; i++ {
+		doc := threeForTwoRules
+		if i%2 == 1 {
+			doc = bulkRules
+		}
+		writeRules(t, path, doc, start.Add(time.Duration(i)*time.Second))
+		_, err := w.Reload()
+		assert.NoError(t, err)
+	}
+	wg.Wait()
+	assert.Equal(t, 11, w.Snapshot().Version)
+}
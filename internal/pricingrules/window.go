@@ -0,0 +1,96 @@
+package pricingrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+)
+
+// Window is a period during which a promotion runs. Every field is optional;
+// a zero Window is always open.
+type Window struct {
+	// Start is the first moment the window is open
+	Start time.Time
+	// End is the first moment after Start the window is closed again
+	End time.Time
+	// Days limits the window to the given days of the week
+	Days []time.Weekday
+	// FromHour and ToHour limit the window to [FromHour:00, ToHour:00) each
+	// day. A range such as 22 to 2 runs over midnight. Equal hours mean all day.
+	FromHour int
+	ToHour   int
+	// Location is the time zone days and hours are read in. It defaults to
+	// the location of the time being checked.
+	Location *time.Location
+}
+
+// Contains reports whether the window is open at t
+func (w Window) Contains(t time.Time) bool {
+	if !w.Start.IsZero() && t.Before(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && !t.Before(w.End) {
+		return false
+	}
+	if w.Location != nil {
+		t = t.In(w.Location)
+	}
+	if len(w.Days) > 0 && !containsWeekday(w.Days, t.Weekday()) {
+		return false
+	}
+	if w.FromHour != w.ToHour {
+		hour := t.Hour()
+		if w.FromHour < w.ToHour {
+			return hour >= w.FromHour && hour < w.ToHour
+		}
+		return hour >= w.FromHour || hour < w.ToHour
+	}
+	return true
+}
+
+func (w Window) validate() error {
+	if !w.Start.IsZero() && !w.End.IsZero() && !w.End.After(w.Start) {
+		return fmt.Errorf("window ends before it starts")
+	}
+	if w.FromHour < 0 || w.FromHour > 23 || w.ToHour < 0 || w.ToHour > 24 {
+		return fmt.Errorf("hours must be between 0 and 24")
+	}
+	return nil
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseWeekday reads a day name such as "monday" or "mon"
+func ParseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(s)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if s == name || s == name[:3] {
+			return day, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("unknown day of the week: %q", s)
+}
+
+// TimeWindowRule runs Rule only while the basket is priced inside Window,
+// turning any rule into a time-limited promotion
+type TimeWindowRule struct {
+	Window Window
+	Rule   PricingRule
+}
+
+func (r *TimeWindowRule) Apply(basket Basket, catalog *catalog.Catalog) ([]Claim, error) {
+	if !r.Window.Contains(basket.Time) {
+		return nil, nil
+	}
+	return r.Rule.Apply(basket, catalog)
+}
@@ -0,0 +1,86 @@
+package pricingrules_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spa5k/zeller_go/internal/catalog"
+	"github.com/spa5k/zeller_go/internal/pricingrules"
+)
+
+func TestWindow_Contains(t *testing.T) {
+	melbourne, err := time.LoadLocation("Australia/Melbourne")
+	assert.NoError(t, err)
+
+	// 2024-11-29 is a Friday
+	at := func(hour int) time.Time {
+		return time.Date(2024, 11, 29, hour, 30, 0, 0, melbourne)
+	}
+
+	testCases := []struct {
+		description string
+		window      pricingrules.Window
+		time        time.Time
+		expected    bool
+	}{
+		{"zero window is always open", pricingrules.Window{}, at(3), true},
+		{"before start", pricingrules.Window{Start: at(9)}, at(8), false},
+		{"at start", pricingrules.Window{Start: at(9)}, at(9), true},
+		{"before end", pricingrules.Window{End: at(9)}, at(8), true},
+		{"end is exclusive", pricingrules.Window{End: at(9)}, at(9), false},
+		{"matching day", pricingrules.Window{Days: []time.Weekday{time.Friday}}, at(12), true},
+		{"other day", pricingrules.Window{Days: []time.Weekday{time.Saturday, time.Sunday}}, at(12), false},
+		{"inside hours", pricingrules.Window{FromHour: 9, ToHour: 17}, at(16), true},
+		{"after hours", pricingrules.Window{FromHour: 9, ToHour: 17}, at(17), false},
+		{"overnight before midnight", pricingrules.Window{FromHour: 22, ToHour: 2}, at(23), true},
+		{"overnight after midnight", pricingrules.Window{FromHour: 22, ToHour: 2}, at(1), true},
+		{"overnight during the day", pricingrules.Window{FromHour: 22, ToHour: 2}, at(12), false},
+		// 23:30 in Melbourne is 12:30 UTC on the same Friday
+		{"hours in window location", pricingrules.Window{FromHour: 12, ToHour: 13, Location: time.UTC}, at(23), true},
+		{"day in window location", pricingrules.Window{Days: []time.Weekday{time.Thursday}, Location: time.UTC}, at(3), true},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, tc.window.Contains(tc.time), tc.description)
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	for _, name := range []string{"sat", "Saturday", "SAT"} {
+		day, err := pricingrules.ParseWeekday(name)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Saturday, day)
+	}
+
+	_, err := pricingrules.ParseWeekday("caturday")
+	assert.EqualError(t, err, `unknown day of the week: "caturday"`)
+}
+
+func TestTimeWindowRule_Apply(t *testing.T) {
+	c := catalog.NewCatalog()
+	start := time.Date(2024, 11, 29, 0, 0, 0, 0, time.UTC)
+	rule := &pricingrules.TimeWindowRule{
+		Window: pricingrules.Window{Start: start, End: start.AddDate(0, 0, 4)},
+		Rule:   &pricingrules.ThreeForTwoRule{SKU: "atv"},
+	}
+
+	basket, err := pricingrules.NewBasket(c, repeat("atv", 3)...)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		description string
+		time        time.Time
+		claims      int
+	}{
+		{"before the promotion", start.Add(-time.Second), 0},
+		{"during the promotion", start.AddDate(0, 0, 1), 1},
+		{"after the promotion", start.AddDate(0, 0, 4), 0},
+	} {
+		basket.Time = tc.time
+		claims, err := rule.Apply(basket, c)
+		assert.NoError(t, err, tc.description)
+		assert.Len(t, claims, tc.claims, tc.description)
+	}
+}